@@ -0,0 +1,177 @@
+// conversation.go
+package dbop
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"openapi-cms/models"
+)
+
+// CreateConversation 创建会话记录
+func (d *Database) CreateConversation(id, username, title, provider string) error {
+	query := "INSERT INTO conversations (id, username, title, provider) VALUES (?, ?, ?, ?)"
+	_, err := d.db.Exec(query, id, username, title, provider)
+	if err != nil {
+		return fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return nil
+}
+
+// GetConversation 获取指定 ID 的会话，不存在时返回 nil
+func (d *Database) GetConversation(id string) (*models.Conversation, error) {
+	query := "SELECT id, username, title, provider, provider_conversation_id, created_at, updated_at FROM conversations WHERE id = ?"
+	row := d.db.QueryRow(query, id)
+	var conv models.Conversation
+	if err := row.Scan(&conv.ID, &conv.Username, &conv.Title, &conv.Provider, &conv.ProviderConversationID, &conv.CreatedAt, &conv.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 未找到记录
+		}
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// ListConversations 按最近更新时间列出用户的会话
+func (d *Database) ListConversations(username string) ([]models.Conversation, error) {
+	query := "SELECT id, username, title, provider, provider_conversation_id, created_at, updated_at FROM conversations WHERE username = ? ORDER BY updated_at DESC"
+	rows, err := d.db.Query(query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	for rows.Next() {
+		var conv models.Conversation
+		if err := rows.Scan(&conv.ID, &conv.Username, &conv.Title, &conv.Provider, &conv.ProviderConversationID, &conv.CreatedAt, &conv.UpdatedAt); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// UpdateConversationTitle 重命名会话
+func (d *Database) UpdateConversationTitle(id, title string) error {
+	query := "UPDATE conversations SET title = ? WHERE id = ?"
+	_, err := d.db.Exec(query, title, id)
+	if err != nil {
+		return fmt.Errorf("failed to update conversation title: %w", err)
+	}
+	return nil
+}
+
+// UpdateConversationProviderID 记录厂商侧的会话ID
+func (d *Database) UpdateConversationProviderID(id, providerConversationID string) error {
+	query := "UPDATE conversations SET provider_conversation_id = ? WHERE id = ?"
+	_, err := d.db.Exec(query, providerConversationID, id)
+	if err != nil {
+		return fmt.Errorf("failed to update provider conversation id: %w", err)
+	}
+	return nil
+}
+
+// DeleteConversation 删除会话，messages 通过外键级联删除
+func (d *Database) DeleteConversation(id string) error {
+	query := "DELETE FROM conversations WHERE id = ?"
+	_, err := d.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	return nil
+}
+
+// InsertMessage 向会话追加一条消息，content 以 JSON 保存，并刷新会话的更新时间。返回消息ID
+func (d *Database) InsertMessage(conversationID, role string, content interface{}, model string) (int64, error) {
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal message content: %w", err)
+	}
+	query := "INSERT INTO messages (conversation_id, role, content, model) VALUES (?, ?, ?, ?)"
+	result, err := d.db.Exec(query, conversationID, role, string(contentBytes), model)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert message: %w", err)
+	}
+	if _, err := d.db.Exec("UPDATE conversations SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", conversationID); err != nil {
+		return 0, fmt.Errorf("failed to touch conversation: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// SaveMessageSources 保存助手回复引用的知识库片段
+func (d *Database) SaveMessageSources(messageID int64, sources []models.ChatSource) error {
+	sourcesBytes, err := json.Marshal(sources)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message sources: %w", err)
+	}
+	query := "INSERT INTO message_sources (message_id, sources) VALUES (?, ?) ON DUPLICATE KEY UPDATE sources = VALUES(sources)"
+	if _, err := d.db.Exec(query, messageID, string(sourcesBytes)); err != nil {
+		return fmt.Errorf("failed to save message sources: %w", err)
+	}
+	return nil
+}
+
+// GetMessages 按时间顺序获取会话中的全部消息，助手回复带上引用的知识库片段
+func (d *Database) GetMessages(conversationID string) ([]models.ConversationMessage, error) {
+	query := `SELECT m.id, m.conversation_id, m.role, m.content, m.model, ms.sources, m.created_at
+		FROM messages m
+		LEFT JOIN message_sources ms ON ms.message_id = m.id
+		WHERE m.conversation_id = ? ORDER BY m.id ASC`
+	rows, err := d.db.Query(query, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.ConversationMessage{}
+	for rows.Next() {
+		var msg models.ConversationMessage
+		var content string
+		var sources sql.NullString
+		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.Role, &content, &msg.Model, &sources, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(content), &msg.Content); err != nil {
+			// 兼容非 JSON 内容，直接作为文本返回
+			msg.Content = content
+		}
+		if sources.Valid {
+			if err := json.Unmarshal([]byte(sources.String), &msg.Sources); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal message sources: %w", err)
+			}
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetConversationSummary 获取会话的滚动摘要，不存在时返回 nil
+func (d *Database) GetConversationSummary(conversationID string) (*models.ConversationSummary, error) {
+	query := "SELECT conversation_id, summary, covered_until_id, model, updated_at FROM conversation_summaries WHERE conversation_id = ?"
+	row := d.db.QueryRow(query, conversationID)
+	var summary models.ConversationSummary
+	if err := row.Scan(&summary.ConversationID, &summary.Summary, &summary.CoveredUntilID, &summary.Model, &summary.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 未找到记录
+		}
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// SaveConversationSummary 保存会话的滚动摘要，已存在时覆盖
+func (d *Database) SaveConversationSummary(summary models.ConversationSummary) error {
+	query := `INSERT INTO conversation_summaries (conversation_id, summary, covered_until_id, model) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE summary = VALUES(summary), covered_until_id = VALUES(covered_until_id), model = VALUES(model)`
+	if _, err := d.db.Exec(query, summary.ConversationID, summary.Summary, summary.CoveredUntilID, summary.Model); err != nil {
+		return fmt.Errorf("failed to save conversation summary: %w", err)
+	}
+	return nil
+}
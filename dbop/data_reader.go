@@ -84,9 +84,11 @@ func GetFilesByKnowledgeBaseID(db *Database) gin.HandlerFunc {
 				COALESCE(f.id, '') AS vector_file_id,  -- 如果没有数据则返回空字符串
 				COALESCE(f.usage_bytes, 0) AS usage_bytes, 
 				COALESCE(f.created_at, '') AS vector_file_created_at, 
-				COALESCE(f.status, '') AS status  -- 如果没有状态则返回空字符串
+				COALESCE(f.status, '') AS status,  -- 如果没有状态则返回空字符串
+				COALESCE(fsc.failure_reason, '') AS failure_reason
 			FROM uploaded_files uf
 			LEFT JOIN files f ON uf.file_id = f.file_id  -- 使用 LEFT JOIN 获取可能为空的 files 表数据
+			LEFT JOIN file_status_checks fsc ON fsc.file_id = f.id
 			WHERE f.vector_store_id = ?
 		`
 
@@ -110,6 +112,7 @@ func GetFilesByKnowledgeBaseID(db *Database) gin.HandlerFunc {
 			UsageBytes          int    `json:"usage_bytes"`
 			VectorFileCreatedAt string `json:"vector_file_created_at"`
 			Status              string `json:"status"`
+			FailureReason       string `json:"failure_reason,omitempty"`
 		}
 
 		var files []FileInfo
@@ -117,7 +120,7 @@ func GetFilesByKnowledgeBaseID(db *Database) gin.HandlerFunc {
 		// 处理查询结果
 		for rows.Next() {
 			var file FileInfo
-			if err := rows.Scan(&file.FileId, &file.FileName, &file.FilePath, &file.FileType, &file.FileDescription, &file.UploadTime, &file.VectorFileID, &file.UsageBytes, &file.VectorFileCreatedAt, &file.Status); err != nil {
+			if err := rows.Scan(&file.FileId, &file.FileName, &file.FilePath, &file.FileType, &file.FileDescription, &file.UploadTime, &file.VectorFileID, &file.UsageBytes, &file.VectorFileCreatedAt, &file.Status, &file.FailureReason); err != nil {
 				logrus.Printf("扫描文件数据失败: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error", "details": err.Error()})
 				return
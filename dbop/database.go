@@ -152,6 +152,209 @@ func (d *Database) createTables() error {
 		return fmt.Errorf("failed to create table files: %w", err)
 	}
 
+	createKnowledgeChunksTable := `
+	CREATE TABLE IF NOT EXISTS knowledge_chunks (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		vector_store_id VARCHAR(255) NOT NULL,        -- 所属的本地知识库
+		file_id VARCHAR(255) NOT NULL,                -- 上传文件ID，关联 uploaded_files
+		chunk_index INT NOT NULL,                     -- 切片在文件中的序号
+		location VARCHAR(255) NOT NULL DEFAULT '',    -- 切片所在的页、工作表或幻灯片
+		content MEDIUMTEXT NOT NULL,                  -- 切片文本
+		embedding MEDIUMBLOB NOT NULL,                -- 向量，float32 小端序
+		embedding_model VARCHAR(255) NOT NULL,        -- 生成向量的模型
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_knowledge_chunks_store (vector_store_id, embedding_model),
+		INDEX idx_knowledge_chunks_file (file_id),
+		FOREIGN KEY (file_id) REFERENCES uploaded_files(file_id) ON DELETE CASCADE
+	)`
+	_, err = d.db.Exec(createKnowledgeChunksTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table knowledge_chunks: %w", err)
+	}
+
+	createFileStatusChecksTable := `
+	CREATE TABLE IF NOT EXISTS file_status_checks (
+		file_id VARCHAR(255) PRIMARY KEY,             -- files 表的 ID（StepFun 文件ID）
+		remote_status VARCHAR(50) NOT NULL,           -- StepFun 最近一次返回的状态
+		failure_reason TEXT,                          -- 解析失败或查询失败的原因
+		attempts INT NOT NULL DEFAULT 0,              -- 已查询的次数
+		checked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+	)`
+	_, err = d.db.Exec(createFileStatusChecksTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table file_status_checks: %w", err)
+	}
+
+	createJobsTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id VARCHAR(36) PRIMARY KEY,
+		type VARCHAR(50) NOT NULL,                    -- knowledge_upload、file_extract
+		status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending、running、succeeded、failed
+		stage VARCHAR(50) NOT NULL DEFAULT '',        -- 当前所处的步骤
+		progress INT NOT NULL DEFAULT 0,              -- 0-100
+		file_id VARCHAR(255) NOT NULL,                -- 上传文件ID
+		vector_store_id VARCHAR(255) NOT NULL DEFAULT '',
+		username VARCHAR(255) NOT NULL,
+		payload TEXT,                                 -- 任务参数（JSON）
+		result TEXT,                                  -- 任务结果（JSON）
+		error TEXT,                                   -- 最近一次失败的原因
+		attempts INT NOT NULL DEFAULT 0,              -- 已执行的次数
+		max_attempts INT NOT NULL DEFAULT 3,
+		run_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,   -- 最早可以执行的时间，失败重试时推迟
+		locked_until TIMESTAMP NULL,                  -- 执行中任务的租约到期时间，执行的实例定期续期，过期视为已中断
+		active_key VARCHAR(600) NULL,                 -- 未结束任务的去重键（类型:文件:知识库），结束后置空
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_jobs_status_run_at (status, run_at),
+		INDEX idx_jobs_file (file_id, type),
+		UNIQUE KEY uk_jobs_active (active_key)
+	)`
+	_, err = d.db.Exec(createJobsTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table jobs: %w", err)
+	}
+
+	createFileContentsTable := `
+	CREATE TABLE IF NOT EXISTS file_contents (
+		file_id VARCHAR(255) PRIMARY KEY,             -- 上传文件ID，关联 uploaded_files
+		content_hash CHAR(64) NOT NULL,               -- 解析时本地文件内容的 SHA-256，文件被替换后缓存失效
+		step_file_id VARCHAR(255) NOT NULL DEFAULT '', -- StepFun 解析文件的ID
+		content LONGTEXT NOT NULL,                    -- 解析后的文本
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_file_contents_step_file_id (step_file_id),
+		FOREIGN KEY (file_id) REFERENCES uploaded_files(file_id) ON DELETE CASCADE
+	)`
+	_, err = d.db.Exec(createFileContentsTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table file_contents: %w", err)
+	}
+
+	createConversationsTable := `
+	CREATE TABLE IF NOT EXISTS conversations (
+		id VARCHAR(64) PRIMARY KEY,                   -- 会话ID
+		username VARCHAR(255) NOT NULL,               -- 会话所属用户
+		title VARCHAR(255) NOT NULL DEFAULT '',       -- 会话标题
+		provider VARCHAR(50) NOT NULL,                -- 厂商：stepfun、openai、dify
+		provider_conversation_id VARCHAR(255) NOT NULL DEFAULT '', -- 厂商侧会话ID（如 Dify）
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_conversations_username (username, updated_at),
+		FOREIGN KEY (username) REFERENCES users(username)
+	)`
+	_, err = d.db.Exec(createConversationsTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table conversations: %w", err)
+	}
+
+	createMessagesTable := `
+	CREATE TABLE IF NOT EXISTS messages (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		conversation_id VARCHAR(64) NOT NULL,         -- 所属会话
+		role VARCHAR(20) NOT NULL,                    -- user 或 assistant
+		content MEDIUMTEXT NOT NULL,                  -- 消息内容（JSON）
+		model VARCHAR(100) NOT NULL DEFAULT '',       -- 生成回复的模型
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_messages_conversation (conversation_id, id),
+		FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+	)`
+	_, err = d.db.Exec(createMessagesTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table messages: %w", err)
+	}
+
+	createMessageSourcesTable := `
+	CREATE TABLE IF NOT EXISTS message_sources (
+		message_id BIGINT PRIMARY KEY,                -- 助手回复的消息ID
+		sources JSON NOT NULL,                        -- 回复引用的知识库片段
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+	)`
+	_, err = d.db.Exec(createMessageSourcesTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table message_sources: %w", err)
+	}
+
+	createConversationSummariesTable := `
+	CREATE TABLE IF NOT EXISTS conversation_summaries (
+		conversation_id VARCHAR(64) PRIMARY KEY,      -- 所属会话
+		summary MEDIUMTEXT NOT NULL,                  -- 较早消息的滚动摘要
+		covered_until_id BIGINT NOT NULL,             -- 摘要覆盖的最后一条消息ID
+		model VARCHAR(100) NOT NULL DEFAULT '',       -- 生成摘要的模型
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+	)`
+	_, err = d.db.Exec(createConversationSummariesTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table conversation_summaries: %w", err)
+	}
+
+	createUsageLedgerTable := `
+	CREATE TABLE IF NOT EXISTS usage_ledger (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(255) NOT NULL,               -- 调用用户
+		provider VARCHAR(50) NOT NULL,                -- 厂商
+		model VARCHAR(100) NOT NULL,                  -- 实际使用的模型
+		conversation_id VARCHAR(64) NOT NULL DEFAULT '', -- 所属会话
+		prompt_tokens INT NOT NULL DEFAULT 0,
+		completion_tokens INT NOT NULL DEFAULT 0,
+		total_tokens INT NOT NULL DEFAULT 0,
+		cost DECIMAL(14,6) NOT NULL DEFAULT 0,        -- 按价格表计算的费用（元）
+		status VARCHAR(20) NOT NULL DEFAULT 'completed', -- completed：正常完成；aborted：客户端断开或超时，token 数为本地估算
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_usage_ledger_user (username, created_at),
+		INDEX idx_usage_ledger_model (model, created_at)
+	)`
+	_, err = d.db.Exec(createUsageLedgerTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table usage_ledger: %w", err)
+	}
+
+	createUserLimitsTable := `
+	CREATE TABLE IF NOT EXISTS user_limits (
+		username VARCHAR(255) PRIMARY KEY,
+		requests_per_minute INT NOT NULL DEFAULT 0,   -- 每分钟请求数，0 表示不限制
+		daily_tokens BIGINT NOT NULL DEFAULT 0,       -- 每日 token 配额，0 表示不限制
+		daily_upload_bytes BIGINT NOT NULL DEFAULT 0, -- 每日上传字节配额，0 表示不限制
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (username) REFERENCES users(username)
+	)`
+	_, err = d.db.Exec(createUserLimitsTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table user_limits: %w", err)
+	}
+
+	createUploadLedgerTable := `
+	CREATE TABLE IF NOT EXISTS upload_ledger (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(255) NOT NULL,               -- 上传用户
+		path VARCHAR(255) NOT NULL,                   -- 上传接口的路由
+		bytes BIGINT NOT NULL,                        -- 请求体的字节数，每日上传配额按此统计
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_upload_ledger_user_time (username, created_at)
+	)`
+	_, err = d.db.Exec(createUploadLedgerTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table upload_ledger: %w", err)
+	}
+
+	createPromptTemplatesTable := `
+	CREATE TABLE IF NOT EXISTS prompt_templates (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,         -- 唯一标识模板的某个版本
+		name VARCHAR(100) NOT NULL,                   -- 模板名称，同名模板的多个版本共用
+		version INT NOT NULL,                         -- 版本号，从 1 开始递增
+		kind VARCHAR(20) NOT NULL,                    -- system：系统提示；retrieval：retrieval 工具的 prompt_template
+		description TEXT,
+		content TEXT NOT NULL,                        -- 模板正文，变量写作 {{name}}
+		variables TEXT NOT NULL,                      -- 变量定义（JSON）
+		creator VARCHAR(255) NOT NULL,                -- 创建该版本的用户
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_prompt_templates_name_version (name, version)
+	)`
+	_, err = d.db.Exec(createPromptTemplatesTable)
+	if err != nil {
+		return fmt.Errorf("failed to create table prompt_templates: %w", err)
+	}
+
 	return nil
 }
 
@@ -242,12 +445,12 @@ func (d *Database) InsertFileKnowledgeRelationTx(tx *sql.Tx, fileID, knowledgeBa
 	return nil
 }
 
-// GetUploadedFileByID 根据 fileID 获取上传文件记录
+// GetUploadedFileByID 根据 fileID 获取上传文件记录，文件多次发送给 StepFun 时优先取最新的 file-extract 记录
 func (d *Database) GetUploadedFileByID(fileID string) (*models.UploadedFile, error) {
-	query := "SELECT uf.file_id, uf.file_name, uf.file_path,uf.file_type,COALESCE(f.id, '') FROM uploaded_files uf LEFT JOIN files f ON uf.file_id = f.file_id WHERE uf.file_id = ?"
+	query := "SELECT uf.file_id, uf.file_name, uf.file_path,uf.file_type,COALESCE(uf.username, ''),COALESCE(f.id, '') FROM uploaded_files uf LEFT JOIN files f ON uf.file_id = f.file_id WHERE uf.file_id = ? ORDER BY f.purpose = 'file-extract' DESC, f.created_at DESC LIMIT 1"
 	row := d.db.QueryRow(query, fileID)
 	var uf models.UploadedFile
-	if err := row.Scan(&uf.FileID, &uf.Filename, &uf.FilePath, &uf.FileType, &uf.StepFileID); err != nil {
+	if err := row.Scan(&uf.FileID, &uf.Filename, &uf.FilePath, &uf.FileType, &uf.UserName, &uf.StepFileID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // 未找到记录
 		}
@@ -0,0 +1,64 @@
+// file_content.go
+package dbop
+
+import (
+	"database/sql"
+	"fmt"
+	"openapi-cms/models"
+)
+
+// GetFileContent 获取上传文件的解析内容缓存，不存在时返回 nil
+func (d *Database) GetFileContent(fileID string) (*models.FileContent, error) {
+	query := "SELECT file_id, content_hash, step_file_id, content, updated_at FROM file_contents WHERE file_id = ?"
+	return scanFileContent(d.db.QueryRow(query, fileID))
+}
+
+// GetFileContentByStepFileID 按 StepFun 解析文件的 ID 获取解析内容缓存，不存在时返回 nil
+func (d *Database) GetFileContentByStepFileID(stepFileID string) (*models.FileContent, error) {
+	query := "SELECT file_id, content_hash, step_file_id, content, updated_at FROM file_contents WHERE step_file_id = ? LIMIT 1"
+	return scanFileContent(d.db.QueryRow(query, stepFileID))
+}
+
+// SaveFileContent 保存上传文件的解析内容，已存在时覆盖
+func (d *Database) SaveFileContent(content models.FileContent) error {
+	query := `INSERT INTO file_contents (file_id, content_hash, step_file_id, content) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE content_hash = VALUES(content_hash), step_file_id = VALUES(step_file_id), content = VALUES(content)`
+	if _, err := d.db.Exec(query, content.FileID, content.ContentHash, content.StepFileID, content.Content); err != nil {
+		return fmt.Errorf("failed to save file content: %w", err)
+	}
+	return nil
+}
+
+// DeleteFileContent 删除上传文件的解析内容缓存
+func (d *Database) DeleteFileContent(fileID string) error {
+	if _, err := d.db.Exec("DELETE FROM file_contents WHERE file_id = ?", fileID); err != nil {
+		return fmt.Errorf("failed to delete file content: %w", err)
+	}
+	return nil
+}
+
+// GetUploadedFileByStepFileID 根据 StepFun 文件 ID 获取对应的上传文件记录，不存在时返回 nil
+func (d *Database) GetUploadedFileByStepFileID(stepFileID string) (*models.UploadedFile, error) {
+	query := `SELECT uf.file_id, uf.file_name, uf.file_path, uf.file_type, COALESCE(uf.username, ''), f.id
+		FROM files f JOIN uploaded_files uf ON uf.file_id = f.file_id WHERE f.id = ?`
+	var uf models.UploadedFile
+	if err := d.db.QueryRow(query, stepFileID).Scan(&uf.FileID, &uf.Filename, &uf.FilePath, &uf.FileType, &uf.UserName, &uf.StepFileID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 未找到记录
+		}
+		return nil, err
+	}
+	return &uf, nil
+}
+
+// scanFileContent 读取一条解析内容缓存，不存在时返回 nil
+func scanFileContent(row *sql.Row) (*models.FileContent, error) {
+	var content models.FileContent
+	if err := row.Scan(&content.FileID, &content.ContentHash, &content.StepFileID, &content.Content, &content.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 未找到记录
+		}
+		return nil, err
+	}
+	return &content, nil
+}
@@ -0,0 +1,69 @@
+// file_status.go
+package dbop
+
+import (
+	"fmt"
+	"openapi-cms/models"
+)
+
+// ListFilesToSync 列出需要向 StepFun 查询向量化状态的文件。vectorStoreID 为空时返回所有 StepFun 知识库中
+// 处理中的文件；不为空时返回该知识库中除未绑定（uploaded）以外的全部文件，用于强制刷新
+func (d *Database) ListFilesToSync(vectorStoreID string) ([]models.KnowledgeBaseFile, error) {
+	query := `SELECT COALESCE(f.file_id, ''), COALESCE(uf.file_name, ''), f.id, f.status
+		FROM files f
+		JOIN vector_stores vs ON vs.id = f.vector_store_id
+		LEFT JOIN uploaded_files uf ON uf.file_id = f.file_id
+		WHERE vs.model_owner = 'stepfun'
+		AND ((? = '' AND f.status = 'processing') OR (f.vector_store_id = ? AND f.status <> 'uploaded'))
+		ORDER BY f.created_at ASC`
+	rows, err := d.db.Query(query, vectorStoreID, vectorStoreID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []models.KnowledgeBaseFile{}
+	for rows.Next() {
+		var file models.KnowledgeBaseFile
+		if err := rows.Scan(&file.FileID, &file.FileName, &file.VectorFileID, &file.Status); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// SaveFileStatusCheck 记录一次状态查询的结果。status 不为空时更新 files 和 uploaded_files 的状态，
+// reason 为失败原因，成功时传空字符串以清除之前的原因
+func (d *Database) SaveFileStatusCheck(vectorFileID, uploadedFileID, remoteStatus, status, uploadedStatus, reason string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if status != "" {
+		if _, err := tx.Exec("UPDATE files SET status = ? WHERE id = ?", status, vectorFileID); err != nil {
+			return fmt.Errorf("无法更新files的状态: %w", err)
+		}
+	}
+	if uploadedStatus != "" && uploadedFileID != "" {
+		if _, err := tx.Exec("UPDATE uploaded_files SET status = ? WHERE file_id = ?", uploadedStatus, uploadedFileID); err != nil {
+			return fmt.Errorf("无法更新upload_files的状态: %w", err)
+		}
+	}
+	query := `INSERT INTO file_status_checks (file_id, remote_status, failure_reason, attempts) VALUES (?, ?, NULLIF(?, ''), 1)
+		ON DUPLICATE KEY UPDATE remote_status = VALUES(remote_status), failure_reason = VALUES(failure_reason), attempts = attempts + 1`
+	if _, err := tx.Exec(query, vectorFileID, remoteStatus, reason); err != nil {
+		return fmt.Errorf("failed to save file status check: %w", err)
+	}
+	return tx.Commit()
+}
+
+// UpdateFileStatusByID 按 files 表的 ID 更新文件在某个知识库中的状态
+func (d *Database) UpdateFileStatusByID(vectorFileID, status string) error {
+	if _, err := d.db.Exec("UPDATE files SET status = ? WHERE id = ?", status, vectorFileID); err != nil {
+		return fmt.Errorf("无法更新files的状态: %w", err)
+	}
+	return nil
+}
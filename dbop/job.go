@@ -0,0 +1,186 @@
+// job.go
+package dbop
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"openapi-cms/models"
+)
+
+// ErrJobLeaseLost 任务已不属于本次执行：租约过期后被放回队列，可能已由其它工作协程重新领取，本次执行的结果不再保存
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+const jobColumns = `id, type, status, stage, progress, file_id, vector_store_id, username,
+	COALESCE(payload, ''), COALESCE(result, ''), COALESCE(error, ''), attempts, max_attempts, run_at, created_at, updated_at`
+
+// activeJobKey 未结束任务的去重键，同一文件同一知识库的同类任务同时只能有一个处于等待或执行中
+func activeJobKey(jobType, fileID, vectorStoreID string) string {
+	return jobType + ":" + fileID + ":" + vectorStoreID
+}
+
+// InsertJob 创建待执行的任务。文件已有同类未结束的任务时不插入，inserted 为 false。
+// 由 active_key 的唯一索引保证并发创建时只有一个成功
+func (d *Database) InsertJob(job models.Job) (inserted bool, err error) {
+	result, err := d.db.Exec(
+		`INSERT INTO jobs (id, type, status, stage, file_id, vector_store_id, username, payload, max_attempts, active_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+		ON DUPLICATE KEY UPDATE id = id`,
+		job.ID, job.Type, models.JobPending, job.Stage, job.FileID, job.VectorStoreID, job.Username, string(job.Payload), job.MaxAttempts,
+		activeJobKey(job.Type, job.FileID, job.VectorStoreID),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert job: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// GetJob 按ID获取任务，不存在时返回 nil
+func (d *Database) GetJob(id string) (*models.Job, error) {
+	row := d.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ?", id)
+	return scanJob(row)
+}
+
+// FindActiveJob 查找文件尚未结束（等待或执行中）的同类任务，不存在时返回 nil
+func (d *Database) FindActiveJob(jobType, fileID, vectorStoreID string) (*models.Job, error) {
+	row := d.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE active_key = ?", activeJobKey(jobType, fileID, vectorStoreID))
+	return scanJob(row)
+}
+
+// ClaimJob 领取一个到期的待执行任务并标记为执行中，租约在 leaseSeconds 秒后到期，没有可执行的任务时返回 nil。
+// 通过带状态条件的 UPDATE 领取，多个工作协程同时领取时只有一个能成功。
+// 执行次数已用完的任务（执行过程中进程退出，租约过期后被放回队列）直接标记为失败，避免反复使进程崩溃
+func (d *Database) ClaimJob(leaseSeconds int) (*models.Job, error) {
+	_, err := d.db.Exec(`UPDATE jobs SET status = 'failed', active_key = NULL, locked_until = NULL,
+		error = CONCAT('已执行 ', attempts, ' 次仍未完成，执行实例可能在执行过程中退出')
+		WHERE status = 'pending' AND attempts >= max_attempts`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fail exhausted jobs: %w", err)
+	}
+
+	rows, err := d.db.Query("SELECT id FROM jobs WHERE status = 'pending' AND run_at <= NOW() ORDER BY run_at, created_at LIMIT 10")
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		result, err := d.db.Exec("UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_until = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE id = ? AND status = 'pending' AND attempts < max_attempts", leaseSeconds, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim job: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if affected == 1 {
+			return d.GetJob(id)
+		}
+	}
+	return nil, nil
+}
+
+// 以下更新只作用于第 attempt 次执行中的任务：租约过期后任务会被放回队列并由其它工作协程重新领取，
+// 原来的执行即使稍后结束也不能覆盖新一次执行的状态。CompleteJob、RetryJob、FailJob 此时返回 ErrJobLeaseLost
+
+// UpdateJobProgress 更新任务当前的步骤和进度，并把租约延长到 leaseSeconds 秒后
+func (d *Database) UpdateJobProgress(id string, attempt int, stage string, progress, leaseSeconds int) error {
+	_, err := d.db.Exec("UPDATE jobs SET stage = ?, progress = ?, locked_until = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE id = ? AND status = 'running' AND attempts = ?", stage, progress, leaseSeconds, id, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+	return nil
+}
+
+// RenewJobLease 把执行中任务的租约延长到 leaseSeconds 秒后
+func (d *Database) RenewJobLease(id string, attempt, leaseSeconds int) error {
+	_, err := d.db.Exec("UPDATE jobs SET locked_until = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE id = ? AND status = 'running' AND attempts = ?", leaseSeconds, id, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to renew job lease: %w", err)
+	}
+	return nil
+}
+
+// CompleteJob 标记任务成功并保存结果
+func (d *Database) CompleteJob(id string, attempt int, result []byte) error {
+	res, err := d.db.Exec("UPDATE jobs SET status = 'succeeded', stage = 'done', progress = 100, result = NULLIF(?, ''), error = NULL, locked_until = NULL, active_key = NULL WHERE id = ? AND status = 'running' AND attempts = ?", string(result), id, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	return checkJobHeld(res)
+}
+
+// RetryJob 记录失败原因，任务在 delaySeconds 秒后重新执行
+func (d *Database) RetryJob(id string, attempt int, reason string, delaySeconds int) error {
+	result, err := d.db.Exec("UPDATE jobs SET status = 'pending', error = ?, run_at = DATE_ADD(NOW(), INTERVAL ? SECOND), locked_until = NULL WHERE id = ? AND status = 'running' AND attempts = ?", reason, delaySeconds, id, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule job: %w", err)
+	}
+	return checkJobHeld(result)
+}
+
+// FailJob 标记任务失败，不再重试
+func (d *Database) FailJob(id string, attempt int, reason string) error {
+	result, err := d.db.Exec("UPDATE jobs SET status = 'failed', error = ?, locked_until = NULL, active_key = NULL WHERE id = ? AND status = 'running' AND attempts = ?", reason, id, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to mark job failed: %w", err)
+	}
+	return checkJobHeld(result)
+}
+
+// checkJobHeld 没有更新到任务时返回 ErrJobLeaseLost。只用于会改变 status 的更新，
+// 否则值没有变化时 MySQL 也返回 0 行
+func checkJobHeld(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// RequeueExpiredJobs 把租约已过期的执行中任务放回队列，恢复执行实例退出或失联时被中断的任务。
+// 其它实例仍在执行的任务会持续续期，不受影响
+func (d *Database) RequeueExpiredJobs() (int64, error) {
+	result, err := d.db.Exec("UPDATE jobs SET status = 'pending', run_at = NOW(), locked_until = NULL WHERE status = 'running' AND (locked_until IS NULL OR locked_until < NOW())")
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue expired jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func scanJob(row rowScanner) (*models.Job, error) {
+	var job models.Job
+	var payload, result string
+	err := row.Scan(&job.ID, &job.Type, &job.Status, &job.Stage, &job.Progress, &job.FileID, &job.VectorStoreID, &job.Username,
+		&payload, &result, &job.Error, &job.Attempts, &job.MaxAttempts, &job.RunAt, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 未找到记录
+		}
+		return nil, err
+	}
+	if payload != "" {
+		job.Payload = []byte(payload)
+	}
+	if result != "" {
+		job.Result = []byte(result)
+	}
+	return &job, nil
+}
@@ -0,0 +1,169 @@
+// knowledge.go
+package dbop
+
+import (
+	"database/sql"
+	"fmt"
+	"openapi-cms/models"
+)
+
+// SearchKnowledgeBases 按关键字搜索知识库（匹配标识、名称、描述和标签），keyword 为空时返回全部。
+// creatorID 不为空时只返回该用户创建的知识库
+func (d *Database) SearchKnowledgeBases(keyword, creatorID string, limit int) ([]models.KnowledgeBase, error) {
+	query := `SELECT id, name, COALESCE(display_name, ''), COALESCE(description, ''), COALESCE(tags, ''), created_at, model_owner, creator_id
+		FROM vector_stores
+		WHERE (? = '' OR name LIKE ? OR display_name LIKE ? OR description LIKE ? OR tags LIKE ?)
+		AND (? = '' OR creator_id = ?)
+		ORDER BY created_at DESC
+		LIMIT ?`
+	pattern := "%" + keyword + "%"
+	rows, err := d.db.Query(query, keyword, pattern, pattern, pattern, pattern, creatorID, creatorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	knowledgeBases := []models.KnowledgeBase{}
+	for rows.Next() {
+		var kb models.KnowledgeBase
+		if err := rows.Scan(&kb.ID, &kb.Name, &kb.DisplayName, &kb.Description, &kb.Tags, &kb.CreatedAt, &kb.ModelOwner, &kb.CreatorID); err != nil {
+			return nil, err
+		}
+		knowledgeBases = append(knowledgeBases, kb)
+	}
+	return knowledgeBases, rows.Err()
+}
+
+// ListKnowledgeBaseFiles 列出知识库中的文件及其向量化状态
+func (d *Database) ListKnowledgeBaseFiles(vectorStoreID string) ([]models.KnowledgeBaseFile, error) {
+	query := `SELECT uf.file_id, uf.file_name, uf.file_type, f.id, f.usage_bytes, f.status, COALESCE(fsc.failure_reason, '')
+		FROM files f
+		JOIN uploaded_files uf ON uf.file_id = f.file_id
+		LEFT JOIN file_status_checks fsc ON fsc.file_id = f.id
+		WHERE f.vector_store_id = ?
+		ORDER BY f.created_at DESC`
+	rows, err := d.db.Query(query, vectorStoreID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []models.KnowledgeBaseFile{}
+	for rows.Next() {
+		var file models.KnowledgeBaseFile
+		if err := rows.Scan(&file.FileID, &file.FileName, &file.FileType, &file.VectorFileID, &file.UsageBytes, &file.Status, &file.FailureReason); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// DeleteKnowledgeBase 在事务中删除知识库记录、它的 files 记录和本地切片。purge 为 true 时，
+// 同时删除只属于该知识库的上传文件记录（不在其他知识库中，也不在 keepFileIDs 中），并返回这些记录以便删除磁盘上的文件
+func (d *Database) DeleteKnowledgeBase(name string, purge bool, keepFileIDs []string) ([]*models.UploadedFile, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	if err := tx.QueryRow("SELECT id FROM vector_stores WHERE name = ? FOR UPDATE", name).Scan(&id); err != nil {
+		return nil, fmt.Errorf("failed to lock knowledge base: %w", err)
+	}
+
+	keep := make(map[string]bool, len(keepFileIDs))
+	for _, fileID := range keepFileIDs {
+		keep[fileID] = true
+	}
+	var purged []*models.UploadedFile
+	if purge {
+		query := `SELECT uf.file_id, uf.file_name, uf.file_path, uf.username
+			FROM uploaded_files uf
+			WHERE uf.file_id IN (SELECT file_id FROM files WHERE vector_store_id = ?)
+			AND NOT EXISTS (SELECT 1 FROM files f WHERE f.file_id = uf.file_id AND f.vector_store_id <> ?)`
+		rows, err := tx.Query(query, id, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query knowledge base files: %w", err)
+		}
+		for rows.Next() {
+			var uf models.UploadedFile
+			if err := rows.Scan(&uf.FileID, &uf.Filename, &uf.FilePath, &uf.UserName); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if keep[uf.FileID] {
+				continue
+			}
+			purged = append(purged, &uf)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM knowledge_chunks WHERE vector_store_id = ?", id); err != nil {
+		return nil, fmt.Errorf("failed to delete knowledge chunks: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM files WHERE vector_store_id = ?", id); err != nil {
+		return nil, fmt.Errorf("failed to delete knowledge base files: %w", err)
+	}
+	for _, uf := range purged {
+		// file_contents 通过外键级联删除
+		if _, err := tx.Exec("DELETE FROM uploaded_files WHERE file_id = ?", uf.FileID); err != nil {
+			return nil, fmt.Errorf("failed to delete uploaded file: %w", err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM vector_stores WHERE name = ?", name); err != nil {
+		return nil, fmt.Errorf("failed to delete knowledge base: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return purged, nil
+}
+
+// GetKnowledgeBaseFile 获取知识库中指定上传文件的记录，不存在时返回 nil
+func (d *Database) GetKnowledgeBaseFile(vectorStoreID, fileID string) (*models.KnowledgeBaseFile, error) {
+	query := `SELECT uf.file_id, uf.file_name, uf.file_type, f.id, f.usage_bytes, f.status
+		FROM files f
+		JOIN uploaded_files uf ON uf.file_id = f.file_id
+		WHERE f.vector_store_id = ? AND f.file_id = ?
+		ORDER BY f.created_at DESC
+		LIMIT 1`
+	var file models.KnowledgeBaseFile
+	err := d.db.QueryRow(query, vectorStoreID, fileID).Scan(&file.FileID, &file.FileName, &file.FileType, &file.VectorFileID, &file.UsageBytes, &file.Status)
+	if err == sql.ErrNoRows {
+		return nil, nil // 未找到记录
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DeleteKnowledgeBaseFile 在事务中删除文件在知识库中的 files 记录和本地切片，上传文件记录保留
+func (d *Database) DeleteKnowledgeBaseFile(vectorStoreID, fileID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM knowledge_chunks WHERE vector_store_id = ? AND file_id = ?", vectorStoreID, fileID); err != nil {
+		return fmt.Errorf("failed to delete knowledge chunks: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM files WHERE vector_store_id = ? AND file_id = ?", vectorStoreID, fileID); err != nil {
+		return fmt.Errorf("failed to delete knowledge base file: %w", err)
+	}
+	return tx.Commit()
+}
+
+// KnowledgeBaseUsageBytes 统计知识库中文件的 usage_bytes 合计
+func (d *Database) KnowledgeBaseUsageBytes(vectorStoreID string) (int, error) {
+	var usageBytes int
+	err := d.db.QueryRow("SELECT COALESCE(SUM(usage_bytes), 0) FROM files WHERE vector_store_id = ?", vectorStoreID).Scan(&usageBytes)
+	return usageBytes, err
+}
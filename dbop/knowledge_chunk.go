@@ -0,0 +1,81 @@
+// knowledge_chunk.go
+package dbop
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"openapi-cms/models"
+)
+
+// ReplaceKnowledgeChunks 在事务中用新的切片替换文件在本地知识库中的全部切片
+func (d *Database) ReplaceKnowledgeChunks(vectorStoreID, fileID string, chunks []models.KnowledgeChunk) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM knowledge_chunks WHERE vector_store_id = ? AND file_id = ?", vectorStoreID, fileID); err != nil {
+		return fmt.Errorf("failed to delete knowledge chunks: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO knowledge_chunks (vector_store_id, file_id, chunk_index, location, content, embedding, embedding_model)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare knowledge chunk insert statement: %w", err)
+	}
+	defer stmt.Close()
+	for _, chunk := range chunks {
+		if _, err := stmt.Exec(vectorStoreID, fileID, chunk.ChunkIndex, chunk.Location, chunk.Content, encodeEmbedding(chunk.Embedding), chunk.EmbeddingModel); err != nil {
+			return fmt.Errorf("failed to insert knowledge chunk: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListKnowledgeChunks 返回本地知识库的全部切片，向量由哪个模型生成见 EmbeddingModel
+func (d *Database) ListKnowledgeChunks(vectorStoreID string) ([]models.KnowledgeChunk, error) {
+	query := `SELECT kc.id, kc.vector_store_id, kc.file_id, uf.file_name, kc.chunk_index, kc.location, kc.content, kc.embedding, kc.embedding_model
+		FROM knowledge_chunks kc
+		JOIN uploaded_files uf ON uf.file_id = kc.file_id
+		WHERE kc.vector_store_id = ?
+		ORDER BY kc.file_id, kc.chunk_index`
+	rows, err := d.db.Query(query, vectorStoreID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []models.KnowledgeChunk
+	for rows.Next() {
+		var chunk models.KnowledgeChunk
+		var embedding []byte
+		if err := rows.Scan(&chunk.ID, &chunk.VectorStoreID, &chunk.FileID, &chunk.FileName, &chunk.ChunkIndex, &chunk.Location, &chunk.Content, &embedding, &chunk.EmbeddingModel); err != nil {
+			return nil, err
+		}
+		chunk.Embedding = decodeEmbedding(embedding)
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// encodeEmbedding 把向量编码为 float32 小端序的字节
+func encodeEmbedding(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+// decodeEmbedding 解码 encodeEmbedding 写入的向量
+func decodeEmbedding(buf []byte) []float32 {
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vector
+}
@@ -0,0 +1,98 @@
+// limits.go
+package dbop
+
+import (
+	"database/sql"
+	"fmt"
+	"openapi-cms/models"
+)
+
+// GetUserLimit 获取用户单独设置的限流配额，未设置时返回 nil
+func (d *Database) GetUserLimit(username string) (*models.UserLimit, error) {
+	query := "SELECT username, requests_per_minute, daily_tokens, daily_upload_bytes, updated_at FROM user_limits WHERE username = ?"
+	row := d.db.QueryRow(query, username)
+	var limit models.UserLimit
+	if err := row.Scan(&limit.Username, &limit.RequestsPerMinute, &limit.DailyTokens, &limit.DailyUploadBytes, &limit.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 未找到记录
+		}
+		return nil, err
+	}
+	return &limit, nil
+}
+
+// ListUserLimits 列出所有单独设置过限流配额的用户
+func (d *Database) ListUserLimits() ([]models.UserLimit, error) {
+	query := "SELECT username, requests_per_minute, daily_tokens, daily_upload_bytes, updated_at FROM user_limits ORDER BY username"
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := []models.UserLimit{}
+	for rows.Next() {
+		var limit models.UserLimit
+		if err := rows.Scan(&limit.Username, &limit.RequestsPerMinute, &limit.DailyTokens, &limit.DailyUploadBytes, &limit.UpdatedAt); err != nil {
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// UpsertUserLimit 新增或更新用户的限流配额
+func (d *Database) UpsertUserLimit(limit models.UserLimit) error {
+	query := `
+		INSERT INTO user_limits (username, requests_per_minute, daily_tokens, daily_upload_bytes)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE requests_per_minute = VALUES(requests_per_minute),
+			daily_tokens = VALUES(daily_tokens), daily_upload_bytes = VALUES(daily_upload_bytes)
+	`
+	_, err := d.db.Exec(query, limit.Username, limit.RequestsPerMinute, limit.DailyTokens, limit.DailyUploadBytes)
+	if err != nil {
+		return fmt.Errorf("failed to upsert user limit: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserLimit 删除用户的单独设置，恢复为默认配额
+func (d *Database) DeleteUserLimit(username string) error {
+	_, err := d.db.Exec("DELETE FROM user_limits WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user limit: %w", err)
+	}
+	return nil
+}
+
+// GetDailyTokenUsage 统计用户当天已使用的 token 数
+func (d *Database) GetDailyTokenUsage(username string) (int64, error) {
+	query := "SELECT COALESCE(SUM(total_tokens), 0) FROM usage_ledger WHERE username = ? AND created_at >= CURDATE()"
+	var total int64
+	if err := d.db.QueryRow(query, username).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// InsertUploadUsage 记录一次成功上传的字节数，path 为上传接口的路由
+func (d *Database) InsertUploadUsage(username, path string, bytes int64) error {
+	_, err := d.db.Exec("INSERT INTO upload_ledger (username, path, bytes) VALUES (?, ?, ?)", username, path, bytes)
+	if err != nil {
+		return fmt.Errorf("failed to insert upload usage: %w", err)
+	}
+	return nil
+}
+
+// GetDailyUploadBytes 统计用户当天通过各上传接口已上传的字节数
+func (d *Database) GetDailyUploadBytes(username string) (int64, error) {
+	query := "SELECT COALESCE(SUM(bytes), 0) FROM upload_ledger WHERE username = ? AND created_at >= CURDATE()"
+	var total int64
+	if err := d.db.QueryRow(query, username).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
@@ -0,0 +1,131 @@
+// prompt_template.go
+package dbop
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"openapi-cms/models"
+)
+
+const promptTemplateColumns = "id, name, version, kind, COALESCE(description, ''), content, variables, creator, created_at"
+
+// InsertPromptTemplate 保存模板的新版本，版本号为同名模板的最大版本号加一，返回保存后的记录
+func (d *Database) InsertPromptTemplate(tpl models.PromptTemplate) (*models.PromptTemplate, error) {
+	variables, err := json.Marshal(tpl.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal prompt variables: %w", err)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// 锁定同名模板的记录，避免并发修改得到相同的版本号
+	var version int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM prompt_templates WHERE name = ? FOR UPDATE", tpl.Name).Scan(&version); err != nil {
+		return nil, err
+	}
+	tpl.Version = version + 1
+	result, err := tx.Exec(
+		"INSERT INTO prompt_templates (name, version, kind, description, content, variables, creator) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		tpl.Name, tpl.Version, tpl.Kind, tpl.Description, tpl.Content, string(variables), tpl.Creator,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert prompt template: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return d.GetPromptTemplate(id)
+}
+
+// GetPromptTemplate 按ID获取模板的某个版本，不存在时返回 nil
+func (d *Database) GetPromptTemplate(id int64) (*models.PromptTemplate, error) {
+	row := d.db.QueryRow("SELECT "+promptTemplateColumns+" FROM prompt_templates WHERE id = ?", id)
+	return scanPromptTemplate(row)
+}
+
+// GetPromptTemplateByName 获取模板的指定版本，version 为 0 时返回最新版本，不存在时返回 nil
+func (d *Database) GetPromptTemplateByName(name string, version int) (*models.PromptTemplate, error) {
+	if version > 0 {
+		row := d.db.QueryRow("SELECT "+promptTemplateColumns+" FROM prompt_templates WHERE name = ? AND version = ?", name, version)
+		return scanPromptTemplate(row)
+	}
+	row := d.db.QueryRow("SELECT "+promptTemplateColumns+" FROM prompt_templates WHERE name = ? ORDER BY version DESC LIMIT 1", name)
+	return scanPromptTemplate(row)
+}
+
+// ListPromptTemplates 列出每个模板的最新版本，kind 为空时返回全部
+func (d *Database) ListPromptTemplates(kind string) ([]models.PromptTemplate, error) {
+	query := "SELECT " + promptTemplateColumns + ` FROM prompt_templates t
+		WHERE version = (SELECT MAX(version) FROM prompt_templates WHERE name = t.name)
+		AND (? = '' OR kind = ?)
+		ORDER BY name`
+	rows, err := d.db.Query(query, kind, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPromptTemplates(rows)
+}
+
+// ListPromptTemplateVersions 按版本从新到旧列出模板的所有版本
+func (d *Database) ListPromptTemplateVersions(name string) ([]models.PromptTemplate, error) {
+	rows, err := d.db.Query("SELECT "+promptTemplateColumns+" FROM prompt_templates WHERE name = ? ORDER BY version DESC", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPromptTemplates(rows)
+}
+
+// DeletePromptTemplate 删除模板的所有版本
+func (d *Database) DeletePromptTemplate(name string) error {
+	_, err := d.db.Exec("DELETE FROM prompt_templates WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete prompt template: %w", err)
+	}
+	return nil
+}
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPromptTemplate(row rowScanner) (*models.PromptTemplate, error) {
+	var tpl models.PromptTemplate
+	var variables string
+	if err := row.Scan(&tpl.ID, &tpl.Name, &tpl.Version, &tpl.Kind, &tpl.Description, &tpl.Content, &variables, &tpl.Creator, &tpl.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 未找到记录
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(variables), &tpl.Variables); err != nil {
+		return nil, fmt.Errorf("failed to parse variables of prompt template %d: %w", tpl.ID, err)
+	}
+	if tpl.Variables == nil {
+		tpl.Variables = []models.PromptVariable{}
+	}
+	return &tpl, nil
+}
+
+func scanPromptTemplates(rows *sql.Rows) ([]models.PromptTemplate, error) {
+	templates := []models.PromptTemplate{}
+	for rows.Next() {
+		tpl, err := scanPromptTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *tpl)
+	}
+	return templates, rows.Err()
+}
@@ -0,0 +1,97 @@
+// usage.go
+package dbop
+
+import (
+	"fmt"
+	"openapi-cms/models"
+	"strings"
+)
+
+// usageGroupColumns 用量报表允许的聚合维度及对应的 SQL 表达式
+var usageGroupColumns = map[string]string{
+	"user":  "username",
+	"model": "model",
+	"day":   "DATE_FORMAT(created_at, '%Y-%m-%d')",
+}
+
+// InsertUsage 写入一条用量台账记录
+func (d *Database) InsertUsage(record models.UsageRecord) error {
+	query := `
+		INSERT INTO usage_ledger (username, provider, model, conversation_id, prompt_tokens, completion_tokens, total_tokens, cost, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	status := record.Status
+	if status == "" {
+		status = models.UsageStatusCompleted
+	}
+	_, err := d.db.Exec(query, record.Username, record.Provider, record.Model, record.ConversationID,
+		record.PromptTokens, record.CompletionTokens, record.TotalTokens, record.Cost, status)
+	if err != nil {
+		return fmt.Errorf("failed to insert usage: %w", err)
+	}
+	return nil
+}
+
+// GetUsageReport 按 groupBy 中的维度（user、model、day）聚合用量，username 为空时统计所有用户，start/end 为 YYYY-MM-DD
+func (d *Database) GetUsageReport(groupBy []string, username, start, end string) ([]models.UsageSummary, error) {
+	selects := []string{"'' AS username", "'' AS model", "'' AS day"}
+	var groups []string
+	for _, dim := range groupBy {
+		column, ok := usageGroupColumns[dim]
+		if !ok {
+			return nil, fmt.Errorf("invalid group dimension: %s", dim)
+		}
+		switch dim {
+		case "user":
+			selects[0] = column + " AS username"
+		case "model":
+			selects[1] = column + " AS model"
+		case "day":
+			selects[2] = column + " AS day"
+		}
+		groups = append(groups, column)
+	}
+
+	var conditions []string
+	var args []interface{}
+	if username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, username)
+	}
+	if start != "" {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, start)
+	}
+	if end != "" {
+		conditions = append(conditions, "created_at < DATE_ADD(?, INTERVAL 1 DAY)")
+		args = append(args, end)
+	}
+
+	query := fmt.Sprintf("SELECT %s, COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(total_tokens), 0), COALESCE(SUM(cost), 0) FROM usage_ledger",
+		strings.Join(selects, ", "))
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if len(groups) > 0 {
+		query += " GROUP BY " + strings.Join(groups, ", ") + " ORDER BY " + strings.Join(groups, ", ")
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []models.UsageSummary{}
+	for rows.Next() {
+		var s models.UsageSummary
+		if err := rows.Scan(&s.Username, &s.Model, &s.Day, &s.Requests, &s.PromptTokens, &s.CompletionTokens, &s.TotalTokens, &s.Cost); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
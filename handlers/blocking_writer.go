@@ -0,0 +1,213 @@
+// blocking_writer.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"openapi-cms/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 前端请求中的 response_mode
+const (
+	responseModeStreaming = "streaming" // 以 SSE 流式返回，默认值
+	responseModeBlocking  = "blocking"  // 汇总后一次性返回 JSON
+)
+
+// chatWriter 聊天结果的输出方式，sseWriter 流式输出，blockingWriter 汇总后输出 JSON
+type chatWriter interface {
+	// Start 开始输出，headers 为额外的响应头
+	Start(headers map[string]string)
+	// Started 返回是否已经收到上游内容，开始后不再切换候选模型
+	Started() bool
+	// Event 输出一个非增量事件，例如 model
+	Event(event string, data interface{}) error
+	// WriteDelta 输出一个增量
+	WriteDelta(delta *ChatDelta) error
+	// Error 输出开始之后出现的错误
+	Error(message string) error
+	// Close 结束输出
+	Close()
+}
+
+// newChatWriter 根据 response_mode 创建输出方式
+func newChatWriter(c *gin.Context, responseMode string) chatWriter {
+	if responseMode == responseModeBlocking {
+		return &blockingWriter{c: c}
+	}
+	return newSSEWriter(c)
+}
+
+// validResponseMode 判断 response_mode 是否合法，空值按 streaming 处理
+func validResponseMode(responseMode string) bool {
+	return responseMode == "" || responseMode == responseModeStreaming || responseMode == responseModeBlocking
+}
+
+// blockingWriter 汇总上游的增量，在 Close 时一次性返回 models.ChatCompletionResult
+type blockingWriter struct {
+	c         *gin.Context
+	started   bool
+	closed    bool
+	headers   map[string]string
+	content   strings.Builder
+	reasoning strings.Builder
+	result    models.ChatCompletionResult
+
+	// 执行函数调用时有多轮请求，priorUsage 为之前各轮的用量合计，turnUsage 为当前轮的用量
+	priorUsage models.ChatUsage
+	turnUsage  *models.ChatUsage
+}
+
+// Start 记录响应头，在 Close 时统一写入
+func (w *blockingWriter) Start(headers map[string]string) {
+	w.started = true
+	w.headers = headers
+	w.result.ConversationID = headers["X-Conversation-Id"]
+}
+
+// Started 返回是否已经收到上游内容
+func (w *blockingWriter) Started() bool {
+	return w.started
+}
+
+// Event 处理 model、context、sources 和 tool_result 事件：记录实际应答的厂商和模型、历史压缩说明、检索出处，以及服务端执行的函数调用
+func (w *blockingWriter) Event(event string, data interface{}) error {
+	switch info := data.(type) {
+	case models.ChatModelEvent:
+		w.result.Provider = info.Provider
+		w.result.Model = info.Model
+		w.result.Fallback = info.Fallback
+	case *models.ContextReport:
+		w.result.Context = info
+	case models.ChatSourcesEvent:
+		w.result.Sources = info.Sources
+	case models.ChatToolResult:
+		// 已执行的调用记录在 tool_results 中，tool_calls 只保留最后一轮未执行的调用
+		w.result.ToolResults = append(w.result.ToolResults, info)
+		w.result.ToolCalls = nil
+		if w.turnUsage != nil {
+			w.priorUsage = addUsage(w.priorUsage, *w.turnUsage)
+			w.turnUsage = nil
+		}
+	}
+	return nil
+}
+
+// WriteDelta 累加内容、思考过程和工具调用，记录结束原因和用量
+func (w *blockingWriter) WriteDelta(delta *ChatDelta) error {
+	if delta.Event == sseEventError {
+		var frame struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(delta.Frame), &frame); err != nil || frame.Error.Message == "" {
+			return w.Error(delta.Frame)
+		}
+		return w.Error(frame.Error.Message)
+	}
+	w.content.WriteString(delta.Content)
+	w.reasoning.WriteString(delta.Reasoning)
+	w.result.ToolCalls = mergeToolCalls(w.result.ToolCalls, delta.ToolCalls, delta.Complete)
+	if delta.FinishReason != "" {
+		w.result.FinishReason = delta.FinishReason
+	}
+	if delta.Usage != nil {
+		usage := *delta.Usage
+		w.turnUsage = &usage
+		w.result.Usage = addUsage(w.priorUsage, usage)
+	}
+	return nil
+}
+
+// Error 记录错误，保留已收到的内容
+func (w *blockingWriter) Error(message string) error {
+	if w.result.Error == "" {
+		w.result.Error = message
+	}
+	if w.result.FinishReason == "" {
+		w.result.FinishReason = "error"
+	}
+	return nil
+}
+
+// Close 写入汇总后的 JSON
+func (w *blockingWriter) Close() {
+	if !w.started || w.closed {
+		return
+	}
+	w.closed = true
+	for key, value := range w.headers {
+		w.c.Header(key, value)
+	}
+	w.result.Content = w.content.String()
+	w.result.ReasoningContent = w.reasoning.String()
+	status := http.StatusOK
+	if len(w.result.ValidationErrors) > 0 {
+		status = http.StatusUnprocessableEntity
+	} else if w.result.Error != "" && w.result.Content == "" {
+		status = http.StatusBadGateway
+	}
+	if w.c.Writer.Written() {
+		logrus.Warn("响应已写入，跳过 blocking 结果")
+		return
+	}
+	w.c.JSON(status, w.result)
+}
+
+// resetTurn 清空上一轮的内容，开始汇总新一轮（结构化输出的修复轮次）的回复
+func (w *blockingWriter) resetTurn() {
+	w.content.Reset()
+	w.reasoning.Reset()
+	w.result.ToolCalls = nil
+	w.result.FinishReason = ""
+}
+
+// finishStructured 记录结构化输出的最终结果，content 为最后一轮用于校验的回复，usage 为所有轮次的合计
+func (w *blockingWriter) finishStructured(content string, object interface{}, repairs int, validationErrors []string, usage models.ChatUsage) {
+	w.content.Reset()
+	w.content.WriteString(content)
+	w.result.Object = object
+	w.result.RepairTurns = repairs
+	w.result.ValidationErrors = validationErrors
+	w.result.Usage = usage
+	if len(validationErrors) > 0 && w.result.Error == "" {
+		w.result.Error = fmt.Sprintf("回复未通过 JSON Schema 校验（已修复 %d 次）", repairs)
+	}
+}
+
+// mergeToolCalls 合并流式返回的工具调用片段：同一 index 的参数依次拼接。complete 为 true 时 parts 是非流式返回的
+// 完整调用，它们都没有 index，直接追加
+func mergeToolCalls(calls []models.ChatToolCall, parts []models.ChatToolCall, complete bool) []models.ChatToolCall {
+	if complete {
+		return append(calls, parts...)
+	}
+	for _, part := range parts {
+		merged := false
+		for i := range calls {
+			if calls[i].Index != part.Index {
+				continue
+			}
+			if part.ID != "" {
+				calls[i].ID = part.ID
+			}
+			if part.Type != "" {
+				calls[i].Type = part.Type
+			}
+			if part.Function.Name != "" {
+				calls[i].Function.Name = part.Function.Name
+			}
+			calls[i].Function.Arguments += part.Function.Arguments
+			merged = true
+			break
+		}
+		if !merged {
+			calls = append(calls, part)
+		}
+	}
+	return calls
+}
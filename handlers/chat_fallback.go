@@ -0,0 +1,227 @@
+// chat_fallback.go
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"openapi-cms/tool/modelcatalog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// modelSwitcher 由支持在同一厂商内切换模型的厂商实现
+type modelSwitcher interface {
+	// NextModel 切换到下一个候选模型并重新构建请求，没有候选模型时返回 false
+	NextModel() (*http.Request, bool)
+}
+
+// retryPolicy 上游请求的重试策略：网络错误和 429/5xx 按指数退避重试
+type retryPolicy struct {
+	MaxRetries int           // 每个模型的最大重试次数
+	Backoff    time.Duration // 首次重试前的等待时间，之后每次翻倍
+	MaxBackoff time.Duration // 单次等待的上限
+}
+
+// loadRetryPolicy 读取重试配置：CHAT_MAX_RETRIES（默认 2）、CHAT_RETRY_BACKOFF_MS（默认 500）
+func loadRetryPolicy() retryPolicy {
+	policy := retryPolicy{MaxRetries: 2, Backoff: 500 * time.Millisecond, MaxBackoff: 8 * time.Second}
+	if raw := os.Getenv("CHAT_MAX_RETRIES"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			policy.MaxRetries = n
+		} else {
+			logrus.Warnf("CHAT_MAX_RETRIES 格式错误: %s", raw)
+		}
+	}
+	if raw := os.Getenv("CHAT_RETRY_BACKOFF_MS"); raw != "" {
+		if ms, err := strconv.Atoi(raw); err == nil && ms >= 0 {
+			policy.Backoff = time.Duration(ms) * time.Millisecond
+		} else {
+			logrus.Warnf("CHAT_RETRY_BACKOFF_MS 格式错误: %s", raw)
+		}
+	}
+	return policy
+}
+
+// upstreamError 上游调用失败的信息，Status 为 0 表示未收到响应
+type upstreamError struct {
+	Provider string
+	Model    string
+	Status   int
+	Err      error
+}
+
+func (e *upstreamError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("%s(%s) 返回状态 %d: %v", e.Provider, e.Model, e.Status, e.Err)
+	}
+	return fmt.Sprintf("%s(%s) 请求失败: %v", e.Provider, e.Model, e.Err)
+}
+
+// isTransientStatus 判断状态码是否值得重试
+func isTransientStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// sendWithRetry 发送上游请求，对网络错误和 429/5xx 按指数退避重试，返回状态码为 200 的响应。
+// 请求随 ctx 取消，ctx 结束后不再重试
+func sendWithRetry(ctx context.Context, client *http.Client, req *http.Request, policy retryPolicy, provider, model string) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	backoff := policy.Backoff
+	var lastErr *upstreamError
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			logrus.Printf("%s(%s) 第 %d 次重试，等待 %v", provider, model, attempt, backoff)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+			// 请求体已被上一次发送读完，需要重新获取
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, &upstreamError{Provider: provider, Model: model, Err: err}
+				}
+				req.Body = body
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				// 客户端断开或超时，不再重试
+				return nil, ctx.Err()
+			}
+			lastErr = &upstreamError{Provider: provider, Model: model, Err: err}
+		} else if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		} else {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			lastErr = &upstreamError{Provider: provider, Model: model, Status: resp.StatusCode, Err: fmt.Errorf("%s", bodyBytes)}
+			if !isTransientStatus(resp.StatusCode) {
+				return nil, lastErr
+			}
+		}
+		logrus.Printf("调用上游失败: %v", lastErr)
+		if attempt >= policy.MaxRetries {
+			return nil, lastErr
+		}
+	}
+}
+
+// chatFallback 依次提供 当前模型 → 同厂商的候选模型 → 备用厂商 的上游请求
+type chatFallback struct {
+	c        *gin.Context
+	db       *dbop.Database
+	conv     *models.Conversation
+	payload  *models.RequestPayload
+	provider ChatProvider
+	req      *http.Request
+	pending  []string // 尚未尝试的备用厂商
+	fallback bool     // 是否已经离开了最初选择的模型
+}
+
+// newChatFallback 使用前端指定的厂商构建第一个请求，构建失败时直接返回错误
+func newChatFallback(c *gin.Context, db *dbop.Database, conv *models.Conversation, payload *models.RequestPayload, provider ChatProvider) (*chatFallback, error) {
+	f := &chatFallback{
+		c:       c,
+		db:      db,
+		conv:    conv,
+		payload: payload,
+		pending: modelcatalog.Default().FallbackProviders(provider.Name()),
+	}
+	if err := f.use(provider); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// use 切换到指定厂商并构建请求
+func (f *chatFallback) use(provider ChatProvider) error {
+	if binder, ok := provider.(conversationBinder); ok {
+		// 厂商会话ID只对创建会话的厂商有效
+		providerConversationID := ""
+		if provider.Name() == f.conv.Provider {
+			providerConversationID = f.conv.ProviderConversationID
+		}
+		binder.BindConversation(f.conv.ID, providerConversationID)
+	}
+	req, err := provider.BuildRequest(f.c, f.payload)
+	if err != nil {
+		return err
+	}
+	f.provider, f.req = provider, req
+	return nil
+}
+
+// next 切换到下一个候选：先尝试同厂商的其他模型，再尝试备用厂商，没有可用候选时返回 false
+func (f *chatFallback) next() bool {
+	if switcher, ok := f.provider.(modelSwitcher); ok {
+		if req, ok := switcher.NextModel(); ok {
+			f.req, f.fallback = req, true
+			return true
+		}
+	}
+	for len(f.pending) > 0 {
+		name := f.pending[0]
+		f.pending = f.pending[1:]
+		provider, err := newChatProvider(name, f.db)
+		if err != nil {
+			logrus.Errorf("备用厂商配置错误: %v", err)
+			continue
+		}
+		normalizeFallbackPayload(f.payload)
+		if err := f.use(provider); err != nil {
+			logrus.Printf("切换到备用厂商 %s 失败: %v", name, err)
+			continue
+		}
+		f.fallback = true
+		return true
+	}
+	return false
+}
+
+// currentModel 返回当前请求使用的模型
+func (f *chatFallback) currentModel() string {
+	model, _ := f.provider.Usage()
+	return model
+}
+
+// modelFrame 构建告知前端实际应答模型的报文
+func (f *chatFallback) modelFrame() models.ChatModelEvent {
+	return models.ChatModelEvent{
+		Object:   "chat.model",
+		Provider: f.provider.Name(),
+		Model:    f.currentModel(),
+		Fallback: f.fallback,
+	}
+}
+
+// normalizeFallbackPayload 不同厂商读取用户消息的字段不同（StepFun 读 UserPrompt，OpenAI 读 Query），
+// 切换厂商前补齐两者。上传文件的内容已经缓存，切换后不会重复解析
+func normalizeFallbackPayload(payload *models.RequestPayload) {
+	if payload.Query == "" {
+		payload.Query = messageText(payload.UserPrompt)
+	}
+	if payload.UserPrompt.Role == "" && payload.Query != "" {
+		payload.UserPrompt = models.StepFunMessage{Role: "user", Content: payload.Query}
+	}
+}
@@ -0,0 +1,296 @@
+// chat_handler.go
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/middleware"
+	"openapi-cms/models"
+	"openapi-cms/tool/modelcatalog"
+	"openapi-cms/tool/tokenizer"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleChatMessages 统一处理 /api/chat-messages/:provider 的聊天请求
+func HandleChatMessages(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, err := newChatProvider(c.Param("provider"), db)
+		if err != nil {
+			logrus.Printf("创建聊天厂商失败: %v", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		var payload models.RequestPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			logrus.Printf("绑定 JSON 失败: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求负载"})
+			return
+		}
+		if !validResponseMode(payload.ResponseMode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "response_mode 只能是 streaming 或 blocking"})
+			return
+		}
+		if err := validateGenerationRanges(payload.GenerationParams); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 按模板渲染系统提示和 retrieval 模板，需在结构化输出追加说明之前完成
+		if err := applyPromptTemplates(db, &payload); err != nil {
+			respondChatError(c, err)
+			return
+		}
+		userName, ok := middleware.GetUserName(c)
+		if !ok {
+			logrus.Warn("未找到或无效的 userName")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+			return
+		}
+		logrus.Printf("****userName: %s, provider: %s", userName, provider.Name())
+
+		// 本地知识库改为通过函数工具检索，需在校验函数工具之前完成
+		knowledgeBaseID, err := prepareLocalRetrieval(db, userName, middleware.IsAdmin(c), &payload)
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+		structured, err := newStructuredOutput(&payload, provider)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if structured != nil {
+			structured.apply(&payload)
+		}
+		if len(payload.FunctionTools) > 0 {
+			if err := validateFunctionTools(payload.FunctionTools); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if _, ok := provider.(followUpSender); !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s 不支持函数工具", provider.Name())})
+				return
+			}
+		}
+
+		// 找到或创建会话，未提供历史消息时从数据库加载。新会话在保存本轮对话时才写入数据库
+		conv, isNewConversation, err := prepareConversation(db, userName, provider.Name(), &payload)
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+		// 上游调用随客户端断开而取消，并受整体超时限制
+		ctx, cancel := context.WithTimeout(c.Request.Context(), chatTimeout())
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		fallback, err := newChatFallback(c, db, conv, &payload, provider)
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		// 依次尝试候选模型和备用厂商，直到有一个开始返回内容。blocking 模式下同样按流式读取上游，汇总后一次性返回
+		client := &http.Client{}
+		policy := loadRetryPolicy()
+		var transcript chatTranscript
+		stream := newChatWriter(c, payload.ResponseMode)
+		defer stream.Close()
+		for {
+			err = streamChatAttempt(c, client, policy, fallback, &transcript, stream)
+			if err == nil || stream.Started() || ctx.Err() != nil {
+				break
+			}
+			logrus.Printf("%s(%s) 调用失败: %v", fallback.provider.Name(), fallback.currentModel(), err)
+			if !fallback.next() {
+				respondUpstreamError(c, err)
+				return
+			}
+			logrus.Printf("切换到 %s(%s)", fallback.provider.Name(), fallback.currentModel())
+		}
+		provider = fallback.provider
+		model, usage := provider.Usage()
+		// StepFun retrieval 工具命中的片段作为出处发给前端
+		sources := &chatSources{}
+		if err == nil {
+			sources.addRetrievalCalls(db, transcript.toolCalls)
+			err = sources.flush(stream)
+		}
+		if err == nil && ctx.Err() == nil && len(payload.FunctionTools) > 0 {
+			// 模型发起函数调用时，由服务端执行并把结果发回模型，直到给出最终回答
+			env := toolEnv{db: db, userName: userName, isAdmin: middleware.IsAdmin(c), knowledgeBaseID: knowledgeBaseID, sources: sources}
+			usage, err = runFunctionTools(c, client, policy, fallback, &transcript, stream, env, payload.FunctionTools, usage)
+		}
+		if err != nil {
+			logrus.Printf("读取 %s 响应时出错: %v", fallback.provider.Name(), err)
+			// 已经开始流式返回，只能通过 error 事件告知前端
+			if stream.Started() && ctx.Err() == nil {
+				if writeErr := stream.Error(fmt.Sprintf("读取 %s 响应时出错", fallback.provider.Name())); writeErr != nil {
+					logrus.Printf("写入 error 事件失败: %v", writeErr)
+				}
+			}
+		}
+
+		if structured != nil && err == nil && ctx.Err() == nil {
+			// 结构化输出：只校验最后一轮（函数调用之后）的回复，不通过时发起修复轮次
+			final, total := structured.run(c, client, policy, fallback, stream.(*blockingWriter), transcript.round.String(), usage)
+			transcript.reply.Reset()
+			transcript.reply.WriteString(final)
+			usage = total
+		}
+		status := models.UsageStatusCompleted
+		if ctx.Err() != nil {
+			// 客户端断开或超时：厂商通常在最后一个报文才返回用量，按已转发的内容估算
+			status = models.UsageStatusAborted
+			usage = estimateAbortedUsage(provider, &payload, transcript.reply.String(), usage)
+			if stopper, ok := provider.(upstreamStopper); ok {
+				stopCtx, stopCancel := context.WithTimeout(context.Background(), 10*time.Second)
+				if err := stopper.StopUpstream(stopCtx); err != nil {
+					logrus.Errorf("通知 %s 停止生成失败: %v", provider.Name(), err)
+				}
+				stopCancel()
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				logrus.Warnf("聊天超时中止，provider: %s, model: %s, 已返回 %d 字", provider.Name(), model, len([]rune(transcript.reply.String())))
+				if stream.Started() {
+					if writeErr := stream.Error("请求超时"); writeErr != nil {
+						logrus.Printf("写入 error 事件失败: %v", writeErr)
+					}
+				} else {
+					c.JSON(http.StatusGatewayTimeout, gin.H{"error": "请求超时"})
+				}
+			} else {
+				logrus.Warnf("客户端已断开，中止聊天，provider: %s, model: %s, 已返回 %d 字", provider.Name(), model, len([]rune(transcript.reply.String())))
+			}
+		}
+		stream.Close()
+		logrus.Printf("聊天结束，provider: %s, model: %s, status: %s, usage: %+v", provider.Name(), model, status, usage)
+
+		saveConversationTurn(db, conv, isNewConversation, userTurnMessage(&payload), transcript.reply.String(), model, sources.items)
+		recordUsage(db, userName, provider.Name(), model, conv.ID, status, usage)
+		if report := payload.ContextReport; report != nil && report.SummaryUsage.TotalTokens > 0 {
+			// 生成摘要的用量单独计入台账
+			if spec, ok := modelcatalog.Default().Get(report.SummaryModel); ok {
+				recordUsage(db, userName, spec.Provider, spec.Name, conv.ID, models.UsageStatusCompleted, report.SummaryUsage)
+			}
+		}
+		if binder, ok := provider.(conversationBinder); ok && provider.Name() == conv.Provider && binder.ProviderConversationID() != conv.ProviderConversationID {
+			if err := db.UpdateConversationProviderID(conv.ID, binder.ProviderConversationID()); err != nil {
+				logrus.Errorf("保存厂商会话ID失败: %v", err)
+			}
+		}
+	}
+}
+
+// chatTimeout 返回单次聊天请求的整体超时时间，通过 CHAT_TIMEOUT_SECONDS 配置，默认 300 秒
+func chatTimeout() time.Duration {
+	if raw := os.Getenv("CHAT_TIMEOUT_SECONDS"); raw != "" {
+		if seconds, err := strconv.Atoi(raw); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		logrus.Warnf("CHAT_TIMEOUT_SECONDS 格式错误: %s", raw)
+	}
+	return 300 * time.Second
+}
+
+// estimateAbortedUsage 请求中途中止时，在厂商未返回用量的情况下用本地估算补齐
+func estimateAbortedUsage(provider ChatProvider, payload *models.RequestPayload, reply string, usage models.ChatUsage) models.ChatUsage {
+	if usage.TotalTokens > 0 {
+		return usage
+	}
+	if estimator, ok := provider.(promptEstimator); ok {
+		usage.PromptTokens = estimator.EstimatePromptTokens()
+	} else {
+		usage.PromptTokens = tokenizer.EstimateMessage(userTurnMessage(payload))
+	}
+	usage.CompletionTokens = tokenizer.EstimateText(reply)
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// chatTranscript 汇总一次聊天所有轮次的助手回复，以及最近一轮模型发起的函数调用
+type chatTranscript struct {
+	reply     strings.Builder
+	round     strings.Builder // 当前轮次（函数调用之间）的回复
+	toolCalls []models.ChatToolCall
+}
+
+// add 记录一个增量
+func (t *chatTranscript) add(delta *ChatDelta) {
+	t.reply.WriteString(delta.Content)
+	t.round.WriteString(delta.Content)
+	t.toolCalls = mergeToolCalls(t.toolCalls, delta.ToolCalls, delta.Complete)
+}
+
+// nextRound 返回当前轮次的回复并开始新的一轮
+func (t *chatTranscript) nextRound() string {
+	content := t.round.String()
+	t.round.Reset()
+	return content
+}
+
+// streamChatAttempt 发送当前候选的请求并转发响应。收到第一个报文时才开始输出并发送 model 事件，
+// 这样在没有任何内容返回前失败时，仍可以切换到下一个候选
+func streamChatAttempt(c *gin.Context, client *http.Client, policy retryPolicy, fallback *chatFallback, transcript *chatTranscript, stream chatWriter) error {
+	provider := fallback.provider
+	resp, err := sendWithRetry(c.Request.Context(), client, fallback.req, policy, provider.Name(), fallback.currentModel())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return provider.StreamDeltas(resp.Body, func(delta *ChatDelta) error {
+		if !stream.Started() {
+			stream.Start(map[string]string{
+				"X-Conversation-Id": fallback.conv.ID,
+				"X-Chat-Provider":   provider.Name(),
+				"X-Chat-Model":      fallback.currentModel(),
+			})
+			if err := stream.Event(sseEventModel, fallback.modelFrame()); err != nil {
+				return err
+			}
+			if report := fallback.payload.ContextReport; report != nil {
+				if err := stream.Event(sseEventContext, report); err != nil {
+					return err
+				}
+			}
+		}
+		// 转发的同时拼接助手回复，用于保存会话和执行函数调用
+		transcript.add(delta)
+		return stream.WriteDelta(delta)
+	})
+}
+
+// respondUpstreamError 所有候选都失败且尚未返回任何内容时，返回 JSON 错误
+func respondUpstreamError(c *gin.Context, err error) {
+	var ue *upstreamError
+	if errors.As(err, &ue) {
+		if ue.Status != 0 {
+			c.JSON(ue.Status, gin.H{"error": fmt.Sprintf("%s API 错误", ue.Provider)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("无法与 %s API 通信", ue.Provider)})
+		return
+	}
+	c.JSON(http.StatusBadGateway, gin.H{"error": "上游服务不可用"})
+}
+
+// respondChatError 将 BuildRequest 返回的错误转换为 JSON 响应
+func respondChatError(c *gin.Context, err error) {
+	logrus.Printf("构建聊天请求失败: %v", err)
+	var ce *chatError
+	if errors.As(err, &ce) {
+		c.JSON(ce.Status, gin.H{"error": ce.Message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误"})
+}
@@ -0,0 +1,258 @@
+// chat_provider.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ChatProvider 定义聊天模型厂商的统一接口，新增厂商只需实现该接口并注册到 chatProviders
+type ChatProvider interface {
+	// Name 返回厂商标识，与路由 /api/chat-messages/:provider 中的 provider 一致
+	Name() string
+	// BuildRequest 根据前端请求组装消息、选择模型，并构建发往厂商的 HTTP 请求
+	BuildRequest(c *gin.Context, payload *models.RequestPayload) (*http.Request, error)
+	// StreamDeltas 读取厂商的响应体，把每一个增量交给 emit 转发给前端
+	StreamDeltas(body io.Reader, emit func(*ChatDelta) error) error
+	// Usage 返回本次请求实际使用的模型和 token 用量，需在 StreamDeltas 结束后调用
+	Usage() (string, models.ChatUsage)
+}
+
+// conversationBinder 由在厂商侧维护会话的厂商实现（如 Dify），用于关联服务端会话与厂商会话
+type conversationBinder interface {
+	// BindConversation 设置服务端会话ID以及上一轮保存的厂商会话ID
+	BindConversation(conversationID, providerConversationID string)
+	// ProviderConversationID 返回本轮厂商返回的会话ID
+	ProviderConversationID() string
+}
+
+// promptEstimator 由能够本地估算请求 token 数的厂商实现，用于请求中途中止时记录用量
+type promptEstimator interface {
+	EstimatePromptTokens() int
+}
+
+// upstreamStopper 由断开连接后仍会继续生成的厂商实现（如 Dify），请求中止时通知厂商停止生成
+type upstreamStopper interface {
+	StopUpstream(ctx context.Context) error
+}
+
+// ChatDelta 定义厂商返回的一个增量
+type ChatDelta struct {
+	Content      string                // 本次增量的文本内容
+	Reasoning    string                // 本次增量的推理/思考内容
+	FinishReason string                // 结束原因，未结束时为空
+	ToolCalls    []models.ChatToolCall // 本次增量中的工具调用
+	Complete     bool                  // 非流式返回：ToolCalls 是完整的调用，没有 index，不需要合并
+	Usage        *models.ChatUsage     // 厂商在报文中携带的用量信息
+	Event        string                // SSE 事件类型，为空时按内容判断为 delta 或 tool_call
+	Frame        string                // 转发给前端的 data 内容（OpenAI/StepFun 风格 JSON）
+}
+
+// chatProviders 注册所有可用的聊天厂商，每个请求都会创建新的实例
+var chatProviders = map[string]func(db *dbop.Database) ChatProvider{
+	"stepfun": newStepFunProvider,
+	"openai":  newOpenAIProvider,
+	"dify":    newDifyProvider,
+}
+
+// newChatProvider 根据厂商标识创建聊天厂商实例
+func newChatProvider(name string, db *dbop.Database) (ChatProvider, error) {
+	factory, ok := chatProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的聊天厂商: %s", name)
+	}
+	return factory(db), nil
+}
+
+// chatError 携带返回给前端的 HTTP 状态码和提示信息
+type chatError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *chatError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *chatError) Unwrap() error {
+	return e.Err
+}
+
+// newChatError 创建带有 HTTP 状态码的错误
+func newChatError(status int, message string, err error) *chatError {
+	return &chatError{Status: status, Message: message, Err: err}
+}
+
+// buildChatMessages 按 系统提示 → 历史消息 → 文件内容 → 用户消息 的顺序组装消息列表，跳过空消息
+func buildChatMessages(systemPrompt string, history []models.StepFunMessage, fileContents []string, userMessage models.StepFunMessage) []models.StepFunMessage {
+	messages := []models.StepFunMessage{}
+	if strings.TrimSpace(systemPrompt) != "" {
+		messages = append(messages, models.StepFunMessage{Role: "system", Content: systemPrompt})
+	}
+	for _, msg := range history {
+		if msg.Role != "" && msg.Content != nil && msg.Content != "" {
+			messages = append(messages, msg)
+		}
+	}
+	for _, content := range fileContents {
+		messages = append(messages, models.StepFunMessage{
+			Role: "user",
+			Content: []models.StepFunMessageContent{
+				{
+					Type: "text",
+					Text: content,
+				},
+			},
+		})
+	}
+	if userMessage.Role != "" && userMessage.Content != nil && userMessage.Content != "" {
+		messages = append(messages, userMessage)
+	}
+	return messages
+}
+
+// loadFileContents 依次加载 VectorFileIds 对应的文件解析内容，已缓存的内容不再从 StepFun 下载
+func loadFileContents(ctx context.Context, db *dbop.Database, vectorFileIds []string, apiKey string) ([]string, error) {
+	var contents []string
+	for _, vectorFileId := range vectorFileIds {
+		content, err := loadCachedFileContent(ctx, db, vectorFileId, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		if content != "" {
+			contents = append(contents, content)
+		}
+	}
+	return contents, nil
+}
+
+// newJSONRequest 构建带 Bearer 鉴权的 JSON POST 请求
+func newJSONRequest(url, apiKey string, body interface{}) (*http.Request, error) {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, newChatError(http.StatusInternalServerError, "服务器错误", fmt.Errorf("序列化请求负载失败: %w", err))
+	}
+	logrus.Printf("上游请求报文: %s", bodyBytes)
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return nil, newChatError(http.StatusInternalServerError, "服务器错误", fmt.Errorf("创建请求失败: %w", err))
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// streamOpenAICompatible 解析 OpenAI/StepFun 风格的 SSE 响应，逐个 chunk 交给 emit，并记录最后一次出现的用量。
+// 上游的 [DONE] 不再转发，结束事件由 sseWriter 统一发送
+func streamOpenAICompatible(body io.Reader, emit func(*ChatDelta) error, usage *models.ChatUsage) error {
+	return readSSE(body, func(field sseField) error {
+		logrus.Printf("上游返回报文: %s", field.Data)
+		data := strings.TrimSpace(field.Data)
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk models.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			logrus.Printf("解析上游报文失败: %v", err)
+			return nil
+		}
+		delta := &ChatDelta{Frame: data, Usage: chunk.Usage}
+		if len(chunk.Choices) > 0 {
+			delta.Content = chunk.Choices[0].Delta.Content
+			delta.Reasoning = chunk.Choices[0].Delta.ReasoningContent
+			delta.FinishReason = chunk.Choices[0].FinishReason
+			delta.ToolCalls = chunk.Choices[0].Delta.ToolCalls
+		}
+		if chunk.Usage != nil {
+			*usage = *chunk.Usage
+		}
+		return emit(delta)
+	})
+}
+
+// readOpenAICompletion 读取非流式的 OpenAI 风格响应，并转换为一个流式 chunk 交给 emit
+func readOpenAICompletion(body io.Reader, emit func(*ChatDelta) error, usage *models.ChatUsage) error {
+	bodyBytes, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+	logrus.Printf("上游返回报文: %s", bodyBytes)
+
+	var completion models.ChatCompletionChunk
+	if err := json.Unmarshal(bodyBytes, &completion); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	if completion.Usage != nil {
+		*usage = *completion.Usage
+	}
+
+	// 把完整消息放入 delta，前端可按流式报文统一处理
+	for i, choice := range completion.Choices {
+		if choice.Message != nil {
+			completion.Choices[i].Delta = *choice.Message
+			completion.Choices[i].Message = nil
+		}
+	}
+	completion.Object = "chat.completion.chunk"
+	frame, err := json.Marshal(completion)
+	if err != nil {
+		return fmt.Errorf("序列化返回报文失败: %w", err)
+	}
+
+	delta := &ChatDelta{Frame: string(frame), Usage: completion.Usage, Complete: true}
+	if len(completion.Choices) > 0 {
+		delta.Content = completion.Choices[0].Delta.Content
+		delta.Reasoning = completion.Choices[0].Delta.ReasoningContent
+		delta.FinishReason = completion.Choices[0].FinishReason
+		delta.ToolCalls = completion.Choices[0].Delta.ToolCalls
+	}
+	return emit(delta)
+}
+
+// messageText 提取消息中的文本内容，Content 可能是字符串、[]StepFunMessageContent 或 JSON 解码得到的 []interface{}
+func messageText(msg models.StepFunMessage) string {
+	switch content := msg.Content.(type) {
+	case nil:
+		return ""
+	case string:
+		return content
+	case []models.StepFunMessageContent:
+		var texts []string
+		for _, part := range content {
+			if part.Type == "text" && part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	case []interface{}:
+		var texts []string
+		for _, item := range content {
+			part, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := part["text"].(string); ok && part["type"] == "text" && text != "" {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,136 @@
+// chat_sources.go
+package handlers
+
+import (
+	"encoding/json"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxSnippetChars 出处中片段的最大字符数
+const maxSnippetChars = 300
+
+// chatSources 汇总本次回答检索命中的出处，按文件和片段去重。有新的出处时通过 sources 事件发给前端，
+// 回答结束后随助手回复一起保存
+type chatSources struct {
+	items []models.ChatSource
+	seen  map[string]bool
+	dirty bool // 是否有尚未发给前端的出处
+}
+
+// add 记录出处，片段过长时截断
+func (s *chatSources) add(sources ...models.ChatSource) {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	for _, source := range sources {
+		source.Snippet = truncateRunes(strings.TrimSpace(source.Snippet), maxSnippetChars)
+		key := source.FileID + "\x00" + source.Location + "\x00" + source.Snippet
+		if s.seen[key] {
+			continue
+		}
+		s.seen[key] = true
+		s.items = append(s.items, source)
+		s.dirty = true
+	}
+}
+
+// addChunks 记录本地知识库的检索结果
+func (s *chatSources) addChunks(chunks []models.ScoredChunk) {
+	for _, chunk := range chunks {
+		s.add(models.ChatSource{
+			FileID:          chunk.FileID,
+			FileName:        chunk.FileName,
+			KnowledgeBaseID: chunk.VectorStoreID,
+			Location:        chunk.Location,
+			Snippet:         chunk.Content,
+			Score:           chunk.Score,
+		})
+	}
+}
+
+// addRetrievalCalls 从 StepFun retrieval 工具调用中提取命中的片段，片段的文件ID换成本系统的上传文件ID
+func (s *chatSources) addRetrievalCalls(db *dbop.Database, calls []models.ChatToolCall) {
+	for _, call := range calls {
+		if call.Type != "retrieval" {
+			continue
+		}
+		for _, source := range retrievalSources(call.Function.Arguments) {
+			if record, err := db.GetUploadedFileByStepFileID(source.FileID); err != nil {
+				logrus.Errorf("查询文件 %s 对应的上传记录失败: %v", source.FileID, err)
+			} else if record != nil {
+				source.FileID = record.FileID
+				if source.FileName == "" {
+					source.FileName = record.Filename
+				}
+			}
+			s.add(source)
+		}
+	}
+}
+
+// flush 有新的出处时发送 sources 事件，事件中带上到目前为止的全部出处
+func (s *chatSources) flush(stream chatWriter) error {
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	return stream.Event(sseEventSources, models.ChatSourcesEvent{
+		Object:  "chat.sources",
+		Sources: append([]models.ChatSource(nil), s.items...),
+	})
+}
+
+// retrievalSources 解析 retrieval 工具调用中的命中片段。StepFun 没有固定返回字段，
+// 这里兼容顶层数组以及 results、documents、chunks、references、sources 下的数组，解析不了时返回空
+func retrievalSources(arguments string) []models.ChatSource {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(arguments), &raw); err != nil {
+		return nil
+	}
+	var items []interface{}
+	switch v := raw.(type) {
+	case []interface{}:
+		items = v
+	case map[string]interface{}:
+		for _, key := range []string{"results", "documents", "chunks", "references", "sources"} {
+			if list, ok := v[key].([]interface{}); ok {
+				items = list
+				break
+			}
+		}
+	}
+
+	var sources []models.ChatSource
+	for _, item := range items {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		source := models.ChatSource{
+			FileID:   firstString(fields, "file_id", "fileId", "id"),
+			FileName: firstString(fields, "file_name", "filename", "title", "name"),
+			Snippet:  firstString(fields, "snippet", "content", "text", "chunk"),
+		}
+		if score, ok := fields["score"].(float64); ok {
+			source.Score = score
+		}
+		if source.Snippet != "" || source.FileID != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// firstString 返回第一个非空的字符串字段
+func firstString(fields map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := fields[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,289 @@
+// context_window.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"openapi-cms/tool/modelcatalog"
+	"openapi-cms/tool/tokenizer"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// contextSafetyRatio 本地估算有误差，压缩历史时只用到最大输入的 90%
+	contextSafetyRatio = 0.9
+	// summaryMaxTokens 摘要的最大长度，压缩历史时为摘要预留
+	summaryMaxTokens = 1024
+	// defaultSummaryModel 未设置 SUMMARY_MODEL 时生成摘要使用的模型
+	defaultSummaryModel = "step-1-flash"
+	// summaryPrefix 放入请求的摘要开头的说明
+	summaryPrefix = "以下是之前对话的摘要：\n"
+	// summaryAck 模型不支持 system 消息时，摘要以用户消息发送，后面跟一条助手的确认
+	summaryAck = "好的，我会结合之前对话的内容继续回答。"
+)
+
+// fitConversationContext 历史消息放不进该厂商最大的候选模型时，用滚动摘要代替较早的消息，
+// 保留系统提示、文件内容和最近的几轮对话。压缩后的历史写回 payload.ConversationHistory，摘要写入 payload.HistorySummary，
+// 说明写入 payload.ContextReport。摘要失败时直接丢弃较早的消息，保证请求仍能发出
+func fitConversationContext(ctx context.Context, db *dbop.Database, provider string, payload *models.RequestPayload, fileContents []string, userMessage models.StepFunMessage) {
+	// 切换到备用厂商时历史已经压缩过，不再重复压缩
+	if payload.ContextReport != nil || len(payload.ConversationHistory) == 0 {
+		return
+	}
+	budget := contextBudget(provider, payload)
+	if budget == 0 {
+		return
+	}
+	history := payload.ConversationHistory
+	fixed := tokenizer.EstimateMessages(buildChatMessages(payload.SystemPrompt, nil, fileContents, userMessage))
+	original := fixed + tokenizer.EstimateMessages(history)
+	if original <= budget {
+		return
+	}
+
+	// 从最近的消息往前保留，直到放不下为止，并为摘要预留空间
+	available := budget - fixed - summaryMaxTokens
+	cut, used := len(history), 0
+	for cut > 0 {
+		cost := tokenizer.EstimateMessage(history[cut-1])
+		if used+cost > available {
+			break
+		}
+		used += cost
+		cut--
+	}
+	// 保留的部分从用户消息开始，避免助手回复缺少对应的提问
+	for cut < len(history) && history[cut].Role != "user" {
+		cut++
+	}
+
+	report := &models.ContextReport{
+		Object:         "chat.context",
+		OriginalTokens: original,
+		Budget:         budget,
+		KeptMessages:   len(history) - cut,
+	}
+	var olderIDs []int64
+	if len(payload.HistoryMessageIDs) == len(history) {
+		olderIDs = payload.HistoryMessageIDs[:cut]
+		payload.HistoryMessageIDs = payload.HistoryMessageIDs[cut:]
+	}
+	summary, err := rollingSummary(ctx, db, payload.ConversationID, history[:cut], olderIDs, report)
+	if err != nil {
+		logrus.Warnf("生成会话摘要失败，丢弃较早的 %d 条消息: %v", cut, err)
+		report.DroppedMessages = cut
+	} else {
+		report.SummarizedMessages = cut
+		report.Summary = summary
+		payload.HistorySummary = summary
+	}
+	payload.ConversationHistory = append([]models.StepFunMessage{}, history[cut:]...)
+	systemPrompt, kept := historyWithSummary(payload.SystemPrompt, payload.ConversationHistory, payload.HistorySummary, true)
+	report.FinalTokens = tokenizer.EstimateMessages(buildChatMessages(systemPrompt, kept, fileContents, userMessage))
+	payload.ContextReport = report
+	logrus.Printf("会话 %s 的历史已压缩: %+v", payload.ConversationID, *report)
+}
+
+// contextBudget 返回该厂商能处理本次输入类型的最大输入 token 数（已扣除安全余量），厂商不在模型目录中时返回 0
+func contextBudget(provider string, payload *models.RequestPayload) int {
+	largest := 0
+	for _, spec := range modelcatalog.Default().Candidates(provider, payload.PerformanceLevel, payload.FileType) {
+		if spec.MaxInputTokens > largest {
+			largest = spec.MaxInputTokens
+		}
+	}
+	return int(float64(largest) * contextSafetyRatio)
+}
+
+// historyWithSummary 把较早历史的摘要放进请求：模型支持 system 消息时并入系统提示，
+// 否则作为一问一答放在历史开头，不在历史中间插入 system 消息
+func historyWithSummary(systemPrompt string, history []models.StepFunMessage, summary string, supportsSystem bool) (string, []models.StepFunMessage) {
+	if summary == "" {
+		return systemPrompt, history
+	}
+	if supportsSystem {
+		if strings.TrimSpace(systemPrompt) == "" {
+			return summaryPrefix + summary, history
+		}
+		return systemPrompt + "\n\n" + summaryPrefix + summary, history
+	}
+	return systemPrompt, append([]models.StepFunMessage{
+		{Role: "user", Content: summaryPrefix + summary},
+		{Role: "assistant", Content: summaryAck},
+	}, history...)
+}
+
+// rollingSummary 生成覆盖 older 全部消息的摘要。ids 为 older 中每条消息在数据库中的ID，
+// 会话已有摘要且覆盖到的消息仍在 older 中时只对之后的消息做增量摘要，结果保存到数据库；
+// 历史由前端传入时 ids 为空，无法确认与已保存的消息一致，每次重新摘要且不保存
+func rollingSummary(ctx context.Context, db *dbop.Database, conversationID string, older []models.StepFunMessage, ids []int64, report *models.ContextReport) (string, error) {
+	if len(older) == 0 {
+		return "", fmt.Errorf("没有需要摘要的消息")
+	}
+	previous, start := "", 0
+	if len(ids) > 0 {
+		existing, err := db.GetConversationSummary(conversationID)
+		if err != nil {
+			logrus.Errorf("查询会话摘要失败: %v", err)
+		} else if existing != nil {
+			for i, id := range ids {
+				if id == existing.CoveredUntilID {
+					previous, start = existing.Summary, i+1
+					report.SummaryModel = existing.Model
+					break
+				}
+			}
+		}
+	}
+	pending := older[start:]
+	if len(pending) == 0 {
+		return previous, nil
+	}
+
+	s, err := newSummarizer()
+	if err != nil {
+		return "", err
+	}
+	summary := previous
+	for len(pending) > 0 {
+		batch, transcript := s.nextBatch(summary, pending)
+		var usage models.ChatUsage
+		summary, usage, err = s.summarize(ctx, summary, transcript)
+		report.SummaryUsage = addUsage(report.SummaryUsage, usage)
+		if err != nil {
+			return "", err
+		}
+		pending = pending[batch:]
+	}
+	report.SummaryModel = s.spec.Name
+
+	if len(ids) == 0 {
+		return summary, nil
+	}
+	if err := db.SaveConversationSummary(models.ConversationSummary{
+		ConversationID: conversationID,
+		Summary:        summary,
+		CoveredUntilID: ids[len(ids)-1],
+		Model:          s.spec.Name,
+	}); err != nil {
+		logrus.Errorf("保存会话摘要失败: %v", err)
+	}
+	return summary, nil
+}
+
+// summarizer 使用便宜的模型生成对话摘要，模型通过 SUMMARY_MODEL 配置，默认 step-1-flash
+type summarizer struct {
+	spec   models.ModelSpec
+	url    string
+	apiKey string
+}
+
+// newSummarizer 按模型目录中摘要模型的厂商确定接口地址和密钥
+func newSummarizer() (*summarizer, error) {
+	name := os.Getenv("SUMMARY_MODEL")
+	if name == "" {
+		name = defaultSummaryModel
+	}
+	spec, ok := modelcatalog.Default().Get(name)
+	if !ok {
+		return nil, fmt.Errorf("模型目录中没有摘要模型 %s", name)
+	}
+	s := &summarizer{spec: spec}
+	switch spec.Provider {
+	case "stepfun":
+		s.url, s.apiKey = "https://api.stepfun.com/v1/chat/completions", os.Getenv("STEPFUN_API_KEY")
+	case "openai":
+		s.url, s.apiKey = os.Getenv("OPENAI_API_URL")+"/chat/completions", os.Getenv("OPENAI_API_KEY")
+	default:
+		return nil, fmt.Errorf("摘要模型 %s 的厂商 %s 不支持", name, spec.Provider)
+	}
+	if s.apiKey == "" {
+		return nil, fmt.Errorf("摘要模型 %s 的密钥未配置", name)
+	}
+	return s, nil
+}
+
+// nextBatch 从 pending 开头取出能放进摘要模型的消息，返回条数和整理成文本的对话。至少取一条，过长时截断
+func (s *summarizer) nextBatch(previous string, pending []models.StepFunMessage) (int, string) {
+	limit := s.spec.MaxInputTokens - summaryMaxTokens - tokenizer.EstimateText(previous) - 500
+	if limit < 1000 {
+		limit = 1000
+	}
+	var sb strings.Builder
+	count, used := 0, 0
+	for _, msg := range pending {
+		line := fmt.Sprintf("%s: %s\n", summaryRoleName(msg.Role), messageText(msg))
+		cost := tokenizer.EstimateText(line)
+		if count > 0 && used+cost > limit {
+			break
+		}
+		if cost > limit {
+			// 单条消息超过上限时按字符截断，中文一个字约一个 token
+			line = truncateRunes(line, limit) + "\n"
+			cost = limit
+		}
+		sb.WriteString(line)
+		used += cost
+		count++
+	}
+	return count, sb.String()
+}
+
+// summarize 把已有摘要和新增对话合并为新的摘要
+func (s *summarizer) summarize(ctx context.Context, previous, transcript string) (string, models.ChatUsage, error) {
+	var prompt strings.Builder
+	prompt.WriteString("请把下面的对话压缩为简洁的摘要，保留关键事实、结论、数字、用户的要求和偏好以及尚未解决的问题，只输出摘要本身，不超过 500 字。\n\n")
+	if previous != "" {
+		fmt.Fprintf(&prompt, "已有摘要：\n%s\n\n新增对话：\n", previous)
+	}
+	prompt.WriteString(transcript)
+
+	maxTokens := summaryMaxTokens
+	params, _ := generationParamsFor(s.spec, models.GenerationParams{MaxTokens: &maxTokens}, false)
+	req, err := newJSONRequest(s.url, s.apiKey, models.StepFunRequestPayload{
+		Model:            s.spec.Name,
+		Messages:         []models.StepFunMessage{{Role: "user", Content: prompt.String()}},
+		GenerationParams: params,
+	})
+	if err != nil {
+		return "", models.ChatUsage{}, err
+	}
+	resp, err := sendWithRetry(ctx, &http.Client{}, req, loadRetryPolicy(), s.spec.Provider, s.spec.Name)
+	if err != nil {
+		return "", models.ChatUsage{}, err
+	}
+	defer resp.Body.Close()
+
+	var completion models.ChatCompletionChunk
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", models.ChatUsage{}, fmt.Errorf("解析摘要响应失败: %w", err)
+	}
+	var usage models.ChatUsage
+	if completion.Usage != nil {
+		usage = *completion.Usage
+	}
+	if len(completion.Choices) == 0 || completion.Choices[0].Message == nil || strings.TrimSpace(completion.Choices[0].Message.Content) == "" {
+		return "", usage, fmt.Errorf("摘要模型没有返回内容")
+	}
+	return strings.TrimSpace(completion.Choices[0].Message.Content), usage, nil
+}
+
+// summaryRoleName 整理对话文本时使用的角色名称
+func summaryRoleName(role string) string {
+	switch role {
+	case "user":
+		return "用户"
+	case "assistant":
+		return "助手"
+	case "system":
+		return "系统"
+	}
+	return role
+}
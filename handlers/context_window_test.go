@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"openapi-cms/models"
+	"testing"
+)
+
+func TestHistoryWithSummary(t *testing.T) {
+	history := []models.StepFunMessage{
+		{Role: "user", Content: "最近的问题"},
+		{Role: "assistant", Content: "最近的回答"},
+	}
+
+	system, got := historyWithSummary("你是一个助手", history, "", true)
+	if system != "你是一个助手" || len(got) != 2 {
+		t.Errorf("without summary: system %q, history %v", system, got)
+	}
+
+	system, got = historyWithSummary("你是一个助手", history, "之前讨论了报价", true)
+	if want := "你是一个助手\n\n" + summaryPrefix + "之前讨论了报价"; system != want {
+		t.Errorf("system = %q, want %q", system, want)
+	}
+	if len(got) != 2 {
+		t.Errorf("summary folded into the system prompt should not change history: %v", got)
+	}
+
+	system, _ = historyWithSummary("", history, "之前讨论了报价", true)
+	if want := summaryPrefix + "之前讨论了报价"; system != want {
+		t.Errorf("empty system prompt: system = %q, want %q", system, want)
+	}
+
+	// 不支持 system 消息的模型：摘要作为一问一答放在历史开头
+	system, got = historyWithSummary("", history, "之前讨论了报价", false)
+	if system != "" {
+		t.Errorf("system = %q, want empty", system)
+	}
+	if len(got) != 4 || got[0].Role != "user" || got[0].Content != summaryPrefix+"之前讨论了报价" || got[1].Role != "assistant" || got[2].Content != "最近的问题" {
+		t.Fatalf("history = %v", got)
+	}
+	messages := buildChatMessages(system, got, nil, models.StepFunMessage{Role: "user", Content: "新问题"})
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			t.Errorf("request contains a system message: %v", messages)
+		}
+	}
+	if len(history) != 2 {
+		t.Errorf("original history was modified: %v", history)
+	}
+}
@@ -0,0 +1,203 @@
+// conversation_handler.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/middleware"
+	"openapi-cms/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// conversationTitleLength 自动生成会话标题时截取的字符数
+const conversationTitleLength = 30
+
+// HandleListConversations 列出当前用户的会话
+func HandleListConversations(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userName, ok := middleware.GetUserName(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+			return
+		}
+		conversations, err := db.ListConversations(userName)
+		if err != nil {
+			logrus.Errorf("查询会话列表失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, conversations)
+	}
+}
+
+// HandleGetConversation 获取会话详情及全部消息
+func HandleGetConversation(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conv, ok := getOwnedConversation(c, db)
+		if !ok {
+			return
+		}
+		messages, err := db.GetMessages(conv.ID)
+		if err != nil {
+			logrus.Errorf("查询会话消息失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		conv.Messages = messages
+		summary, err := db.GetConversationSummary(conv.ID)
+		if err != nil {
+			logrus.Errorf("查询会话摘要失败: %v", err)
+		}
+		conv.Summary = summary
+		c.JSON(http.StatusOK, conv)
+	}
+}
+
+// HandleRenameConversation 重命名会话
+func HandleRenameConversation(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload struct {
+			Title string `json:"title"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求负载"})
+			return
+		}
+		title := strings.TrimSpace(payload.Title)
+		if title == "" || len([]rune(title)) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "会话标题不能为空且不能超过100个字符"})
+			return
+		}
+
+		conv, ok := getOwnedConversation(c, db)
+		if !ok {
+			return
+		}
+		if err := db.UpdateConversationTitle(conv.ID, title); err != nil {
+			logrus.Errorf("重命名会话失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": conv.ID, "title": title})
+	}
+}
+
+// HandleDeleteConversation 删除会话及其消息
+func HandleDeleteConversation(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conv, ok := getOwnedConversation(c, db)
+		if !ok {
+			return
+		}
+		if err := db.DeleteConversation(conv.ID); err != nil {
+			logrus.Errorf("删除会话失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "会话已删除"})
+	}
+}
+
+// getOwnedConversation 读取路由中的会话ID并校验归属，失败时已写入响应
+func getOwnedConversation(c *gin.Context, db *dbop.Database) (*models.Conversation, bool) {
+	userName, ok := middleware.GetUserName(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return nil, false
+	}
+	conv, err := db.GetConversation(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("查询会话失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+		return nil, false
+	}
+	// 不属于当前用户的会话按不存在处理
+	if conv == nil || conv.Username != userName {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return nil, false
+	}
+	return conv, true
+}
+
+// prepareConversation 找到或创建本次聊天所属的会话；前端未提供历史消息时，从数据库加载历史。
+// 指定的会话不存在时以该ID创建新会话，ID 必须是 UUID。
+// 新会话不立即写入数据库，第二个返回值为 true，由 saveConversationTurn 在保存第一轮对话时创建，
+// 避免参数校验失败或上游全部失败时留下空会话
+func prepareConversation(db *dbop.Database, userName, provider string, payload *models.RequestPayload) (*models.Conversation, bool, error) {
+	conversationID := strings.TrimSpace(payload.ConversationID)
+	if conversationID != "" {
+		conv, err := db.GetConversation(conversationID)
+		if err != nil {
+			return nil, false, newChatError(http.StatusInternalServerError, "数据库错误", fmt.Errorf("查询会话失败: %w", err))
+		}
+		if conv != nil {
+			if conv.Username != userName {
+				return nil, false, newChatError(http.StatusNotFound, "会话不存在", fmt.Errorf("会话 %s 不属于用户 %s", conversationID, userName))
+			}
+			if len(payload.ConversationHistory) == 0 {
+				messages, err := db.GetMessages(conv.ID)
+				if err != nil {
+					return nil, false, newChatError(http.StatusInternalServerError, "数据库错误", fmt.Errorf("加载会话历史失败: %w", err))
+				}
+				for _, msg := range messages {
+					payload.ConversationHistory = append(payload.ConversationHistory, models.StepFunMessage{Role: msg.Role, Content: msg.Content})
+					payload.HistoryMessageIDs = append(payload.HistoryMessageIDs, msg.ID)
+				}
+			}
+			return conv, false, nil
+		}
+		// 客户端指定的新会话ID作为主键保存，只接受 UUID，避免超出列长度或格式混乱导致保存会话失败
+		if parsed, err := uuid.Parse(conversationID); err != nil || parsed.String() != strings.ToLower(conversationID) {
+			return nil, false, newChatError(http.StatusBadRequest, "conversation_id 必须是 UUID", fmt.Errorf("无效的会话ID: %q", conversationID))
+		}
+	} else {
+		conversationID = uuid.New().String()
+	}
+
+	title := []rune(strings.TrimSpace(messageText(userTurnMessage(payload))))
+	if len(title) > conversationTitleLength {
+		title = title[:conversationTitleLength]
+	}
+	payload.ConversationID = conversationID
+	return &models.Conversation{ID: conversationID, Username: userName, Title: string(title), Provider: provider}, true, nil
+}
+
+// saveConversationTurn 保存本轮的用户消息和拼接好的助手回复及其引用的出处，新会话先创建会话记录，失败只记录日志
+func saveConversationTurn(db *dbop.Database, conv *models.Conversation, isNew bool, userMessage models.StepFunMessage, reply, model string, sources []models.ChatSource) {
+	if isNew {
+		if err := db.CreateConversation(conv.ID, conv.Username, conv.Title, conv.Provider); err != nil {
+			logrus.Errorf("创建会话失败: %v", err)
+			return
+		}
+	}
+	if _, err := db.InsertMessage(conv.ID, "user", userMessage.Content, ""); err != nil {
+		logrus.Errorf("保存用户消息失败: %v", err)
+		return
+	}
+	if reply == "" {
+		return
+	}
+	messageID, err := db.InsertMessage(conv.ID, "assistant", reply, model)
+	if err != nil {
+		logrus.Errorf("保存助手回复失败: %v", err)
+		return
+	}
+	if len(sources) > 0 {
+		if err := db.SaveMessageSources(messageID, sources); err != nil {
+			logrus.Errorf("保存回复的出处失败: %v", err)
+		}
+	}
+}
+
+// userTurnMessage 返回本轮的用户消息，StepFun 使用 user_prompt，其余厂商使用 query
+func userTurnMessage(payload *models.RequestPayload) models.StepFunMessage {
+	if payload.UserPrompt.Role != "" && payload.UserPrompt.Content != nil {
+		return payload.UserPrompt
+	}
+	return models.StepFunMessage{Role: "user", Content: payload.Query}
+}
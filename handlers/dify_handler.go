@@ -2,167 +2,218 @@
 package handlers
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/middleware"
 	"openapi-cms/models"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-// handleChatMessagesDify 处理 Dify 聊天消息的请求
-func HandleChatMessagesDify(c *gin.Context) {
-	var payload models.RequestPayload
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		logrus.Printf("Error binding JSON: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
-		return
+// difyProvider 实现 Dify 的聊天接口
+type difyProvider struct {
+	apiKey                 string
+	user                   string
+	taskID                 string // 当前生成任务的ID，中止时用于通知 Dify 停止生成
+	usage                  models.ChatUsage
+	conversationID         string // 服务端会话ID
+	providerConversationID string // Dify 的会话ID，由服务端保存，后续轮次据此延续同一 Dify 会话
+}
+
+// newDifyProvider 创建 Dify 聊天厂商实例
+func newDifyProvider(db *dbop.Database) ChatProvider {
+	return &difyProvider{}
+}
+
+// Name 返回厂商标识
+func (p *difyProvider) Name() string {
+	return "dify"
+}
+
+// BindConversation 关联服务端会话与 Dify 会话
+func (p *difyProvider) BindConversation(conversationID, providerConversationID string) {
+	p.conversationID = conversationID
+	p.providerConversationID = providerConversationID
+}
+
+// ProviderConversationID 返回 Dify 返回的会话ID
+func (p *difyProvider) ProviderConversationID() string {
+	return p.providerConversationID
+}
+
+// BuildRequest 将前端请求转换为 Dify chat-messages 接口的请求
+func (p *difyProvider) BuildRequest(c *gin.Context, payload *models.RequestPayload) (*http.Request, error) {
+	p.apiKey = os.Getenv("DIFY_API_KEY")
+	if p.apiKey == "" {
+		return nil, newChatError(http.StatusInternalServerError, "Server configuration error", fmt.Errorf("DIFY_API_KEY is not set"))
 	}
 
-	// 将请求体编码为 JSON
-	reqBodyBytes, err := json.Marshal(payload)
-	if err != nil {
-		logrus.Printf("Error marshaling JSON: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-		return
+	query := payload.Query
+	if strings.TrimSpace(query) == "" {
+		query = messageText(payload.UserPrompt)
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, newChatError(http.StatusBadRequest, "query 不能为空", nil)
 	}
 
-	// 打印请求报文
-	logrus.Printf("Dify 请求报文: %s", string(reqBodyBytes))
+	// Dify 要求 user 用于区分终端用户，优先使用登录用户名
+	user := payload.User
+	if userName, ok := middleware.GetUserName(c); ok && userName != "" {
+		user = userName
+	}
 
-	apiKey := os.Getenv("DIFY_API_KEY")
-	if apiKey == "" {
-		logrus.Println("DIFY_API_KEY is not set")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server configuration error"})
-		return
+	inputs := payload.Inputs
+	if inputs == nil {
+		inputs = map[string]interface{}{}
 	}
 
-	// 创建向 Dify API 发送的 HTTP 请求
-	difyReq, err := http.NewRequest("POST", "https://api.dify.ai/v1/chat-messages", bytes.NewBuffer(reqBodyBytes))
-	if err != nil {
-		logrus.Printf("Error creating request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-		return
+	difyRequest := models.DifyRequestPayload{
+		Inputs:         inputs,
+		Query:          query,
+		ResponseMode:   "streaming",
+		ConversationID: p.providerConversationID,
+		User:           user,
 	}
+	p.user = user
+	return newJSONRequest(fmt.Sprintf("%s/chat-messages", difyAPIURL()), p.apiKey, difyRequest)
+}
 
-	// 设置请求头
-	difyReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-	difyReq.Header.Set("Content-Type", "application/json")
+// StreamDeltas 逐行读取 Dify 的事件流，并按事件类型转换为 StepFun 风格的报文
+func (p *difyProvider) StreamDeltas(body io.Reader, emit func(*ChatDelta) error) error {
+	return readSSE(body, func(field sseField) error {
+		var event models.DifyStreamEvent
+		if err := json.Unmarshal([]byte(field.Data), &event); err != nil {
+			logrus.Printf("Error decoding Dify response line: %v", err)
+			logrus.Printf("Dify response line: %s", field.Data)
+			return nil
+		}
+		if event.ConversationID != "" {
+			p.providerConversationID = event.ConversationID
+		}
+		if event.TaskID != "" {
+			p.taskID = event.TaskID
+		}
+
+		switch event.Event {
+		case "message", "agent_message":
+			return emit(&ChatDelta{
+				Content: event.Answer,
+				Frame:   p.chunkFrame(event, models.ChatDeltaMessage{Role: "assistant", Content: event.Answer}, "", nil),
+			})
+		case "agent_thought":
+			thought := difyThoughtText(event)
+			if thought == "" {
+				return nil
+			}
+			return emit(&ChatDelta{
+				Reasoning: thought,
+				Frame:     p.chunkFrame(event, models.ChatDeltaMessage{Role: "assistant", ReasoningContent: thought}, "", nil),
+			})
+		case "message_end":
+			p.usage = event.Metadata.Usage
+			return emit(&ChatDelta{
+				FinishReason: "stop",
+				Usage:        &p.usage,
+				Frame:        p.chunkFrame(event, models.ChatDeltaMessage{Role: "assistant"}, "stop", &p.usage),
+			})
+		case "error":
+			logrus.Printf("Dify 返回错误事件: status=%d, code=%s, message=%s", event.Status, event.Code, event.Message)
+			frame, _ := json.Marshal(gin.H{"error": gin.H{"code": event.Code, "message": event.Message}})
+			if err := emit(&ChatDelta{FinishReason: "error", Event: sseEventError, Frame: string(frame)}); err != nil {
+				return err
+			}
+			return fmt.Errorf("Dify 返回错误: %s", event.Message)
+		default:
+			// ping、message_file、tts_message 等事件无需转发
+			return nil
+		}
+	})
+}
 
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(difyReq)
+// StopUpstream 调用 Dify 的停止接口，断开连接后 Dify 仍会继续生成
+func (p *difyProvider) StopUpstream(ctx context.Context) error {
+	if p.taskID == "" {
+		return nil
+	}
+	req, err := newJSONRequest(fmt.Sprintf("%s/chat-messages/%s/stop", difyAPIURL(), p.taskID), p.apiKey, gin.H{"user": p.user})
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
-		logrus.Printf("Error sending request to Dify API: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to communicate with Dify API"})
-		return
+		return err
 	}
 	defer resp.Body.Close()
-
-	// 检查响应状态
 	if resp.StatusCode != http.StatusOK {
-		errorText := ""
-		if resp.Body != nil {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			errorText = string(bodyBytes)
-		}
-		logrus.Printf("Dify API returned status: %s, message: %s", resp.Status, errorText)
-		c.JSON(resp.StatusCode, gin.H{"error": "Dify API error"})
-		return
-	}
-
-	// 设置响应头以支持流式传输
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-
-	// 逐行读取响应体并解析有效负载
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 5 && line[:5] == "data:" {
-			line = line[5:]
-			var difyResponse models.DifyResponse
-			err = json.Unmarshal([]byte(line), &difyResponse)
-			if err != nil {
-				logrus.Printf("Error decoding Dify response line: %v", err)
-				logrus.Printf("Dify response line: %s", line)
-				continue
-			}
-
-			// 构建 StepFun 风格的返回给前端的报文
-			response := struct {
-				ID      string `json:"id"`
-				Created int64  `json:"created"`
-				Model   string `json:"model"`
-				Choices []struct {
-					Index        int    `json:"index"`
-					FinishReason string `json:"finish_reason"`
-					Delta        struct {
-						Role    string `json:"role"`
-						Content string `json:"content"`
-					} `json:"delta"`
-				} `json:"choices"`
-				Usage struct {
-					PromptTokens     int `json:"prompt_tokens"`
-					CompletionTokens int `json:"completion_tokens"`
-					TotalTokens      int `json:"total_tokens"`
-				} `json:"usage"`
-			}{
-				ID:      difyResponse.TaskID,
-				Created: difyResponse.CreatedAt,
-				Model:   "dify",
-				Choices: []struct {
-					Index        int    `json:"index"`
-					FinishReason string `json:"finish_reason"`
-					Delta        struct {
-						Role    string `json:"role"`
-						Content string `json:"content"`
-					} `json:"delta"`
-				}{
-					{
-						Index:        0,
-						FinishReason: "stop",
-						Delta: struct {
-							Role    string `json:"role"`
-							Content string `json:"content"`
-						}{
-							Role:    "assistant",
-							Content: difyResponse.Answer,
-						},
-					},
-				},
-				Usage: struct {
-					PromptTokens     int `json:"prompt_tokens"`
-					CompletionTokens int `json:"completion_tokens"`
-					TotalTokens      int `json:"total_tokens"`
-				}{
-					PromptTokens:     difyResponse.Usage.PromptTokens,
-					CompletionTokens: difyResponse.Usage.CompletionTokens,
-					TotalTokens:      difyResponse.Usage.TotalTokens,
-				},
-			}
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("停止 Dify 任务 %s 失败，状态码: %d, 信息: %s", p.taskID, resp.StatusCode, bodyBytes)
+	}
+	return nil
+}
 
-			// 打印返回给前端的报文
-			logrus.Printf("Dify 返回前端的 StepFun 风格报文: %v", response)
+// Usage 返回本次请求使用的模型和 token 用量
+func (p *difyProvider) Usage() (string, models.ChatUsage) {
+	return "dify", p.usage
+}
 
-			// 转发每一行到前端
-			//fmt.Fprintf(c.Writer, "data: %s\n\n", main.toJSONString(response))
-			// 刷新缓冲区
-			c.Writer.Flush()
-		}
+// chunkFrame 构建 StepFun 风格的返回报文，并携带服务端会话ID
+func (p *difyProvider) chunkFrame(event models.DifyStreamEvent, message models.ChatDeltaMessage, finishReason string, usage *models.ChatUsage) string {
+	id := event.MessageID
+	if id == "" {
+		id = event.TaskID
+	}
+	chunk := models.ChatCompletionChunk{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: event.CreatedAt,
+		Model:   "dify",
+		Choices: []models.ChatChoice{
+			{
+				Index:        0,
+				FinishReason: finishReason,
+				Delta:        message,
+			},
+		},
+		Usage:          usage,
+		ConversationID: p.conversationID,
+	}
+	if chunk.ConversationID == "" {
+		chunk.ConversationID = p.providerConversationID
+	}
+	frame, err := json.Marshal(chunk)
+	if err != nil {
+		logrus.Printf("序列化 Dify 返回报文失败: %v", err)
+		return ""
 	}
+	return string(frame)
+}
 
-	if err := scanner.Err(); err != nil {
-		logrus.Printf("Error reading Dify response body: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read Dify response"})
-		return
+// difyThoughtText 将 agent_thought 事件整理为可展示的思考过程
+func difyThoughtText(event models.DifyStreamEvent) string {
+	var parts []string
+	if event.Thought != "" {
+		parts = append(parts, event.Thought)
+	}
+	if event.Tool != "" {
+		parts = append(parts, fmt.Sprintf("调用工具 %s: %s", event.Tool, event.ToolInput))
+	}
+	if event.Observation != "" {
+		parts = append(parts, fmt.Sprintf("工具返回: %s", event.Observation))
 	}
+	return strings.Join(parts, "\n")
+}
 
-	c.Status(http.StatusOK)
+// difyAPIURL 返回 Dify API 地址，默认为 Dify 云服务
+func difyAPIURL() string {
+	if apiURL := os.Getenv("DIFY_API_URL"); apiURL != "" {
+		return strings.TrimRight(apiURL, "/")
+	}
+	return "https://api.dify.ai/v1"
 }
@@ -0,0 +1,171 @@
+// file_content.go
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"openapi-cms/tool/extractor"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// uploadedFileContent 返回上传文件的文本内容：缓存与本地文件的哈希一致时直接使用缓存，
+// 否则重新解析并写入缓存。本地文件被替换后旧的缓存和 StepFun 解析文件都不再使用
+func uploadedFileContent(ctx context.Context, db *dbop.Database, record *models.UploadedFile, apiKey string) (string, error) {
+	filePath := uploadedFilePath(record)
+	hash, err := fileContentHash(filePath)
+	if err != nil {
+		logrus.Warnf("计算文件 %s 的哈希失败: %v", record.FileID, err)
+	}
+
+	reuse := true
+	cached, err := db.GetFileContent(record.FileID)
+	if err != nil {
+		logrus.Errorf("查询文件 %s 的解析内容缓存失败: %v", record.FileID, err)
+	} else if cached != nil {
+		if hash == "" || cached.ContentHash == hash {
+			logrus.Printf("文件 %s 命中解析内容缓存", record.FileID)
+			return cached.Content, nil
+		}
+		// 本地文件已被替换，历史的解析结果不再可用
+		logrus.Printf("文件 ID %s 的内容已变化，重新解析", record.FileID)
+		if err := db.DeleteFileContent(record.FileID); err != nil {
+			logrus.Errorf("删除文件 %s 的解析内容缓存失败: %v", record.FileID, err)
+		}
+		reuse = false
+	}
+
+	content, stepFileID, err := extractFileContent(ctx, db, record, filePath, apiKey, reuse)
+	if err != nil {
+		return "", err
+	}
+	if hash != "" {
+		saveFileContent(db, record.FileID, hash, stepFileID, content)
+	}
+	return content, nil
+}
+
+// extractFileContent 解析上传文件。默认先在本地解析，本地不支持或解析失败时交给 StepFun；
+// FILE_EXTRACTOR=stepfun 时顺序相反。返回内容和 StepFun 解析文件的 ID（本地解析时为空）
+func extractFileContent(ctx context.Context, db *dbop.Database, record *models.UploadedFile, filePath, apiKey string, reuse bool) (string, string, error) {
+	type extraction struct {
+		name string
+		run  func() (string, string, error)
+	}
+	extractions := []extraction{
+		{"local", func() (string, string, error) {
+			content, err := extractor.ExtractFile(filePath)
+			return content, "", err
+		}},
+		{"stepfun", func() (string, string, error) {
+			return stepFunExtract(ctx, db, record, filePath, apiKey, reuse)
+		}},
+	}
+	if os.Getenv("FILE_EXTRACTOR") == "stepfun" {
+		extractions[0], extractions[1] = extractions[1], extractions[0]
+	}
+
+	content, stepFileID, err := extractions[0].run()
+	if err == nil {
+		return content, stepFileID, nil
+	}
+	logrus.Warnf("%s 解析文件 %s 失败，改用 %s 解析: %v", extractions[0].name, record.FileID, extractions[1].name, err)
+	content, stepFileID, fallbackErr := extractions[1].run()
+	if fallbackErr != nil {
+		if chatErr, ok := fallbackErr.(*chatError); ok {
+			return "", "", chatErr
+		}
+		return "", "", newChatError(http.StatusInternalServerError, "文件解析失败", fmt.Errorf("%s: %v; %s: %w", extractions[0].name, err, extractions[1].name, fallbackErr))
+	}
+	return content, stepFileID, nil
+}
+
+// loadCachedFileContent 加载 StepFun 解析文件的内容：缓存与本地文件的哈希一致时直接使用缓存，未命中时下载并写入缓存。
+// 下载失败且文件是本系统上传的时，改为在本地解析；本地文件已被替换时按上传文件重新解析
+func loadCachedFileContent(ctx context.Context, db *dbop.Database, stepFileID, apiKey string) (string, error) {
+	record, err := db.GetUploadedFileByStepFileID(stepFileID)
+	if err != nil {
+		logrus.Errorf("查询文件 %s 对应的上传记录失败: %v", stepFileID, err)
+	}
+	hash := ""
+	if record != nil {
+		if hash, err = fileContentHash(uploadedFilePath(record)); err != nil {
+			logrus.Warnf("计算文件 %s 的哈希失败: %v", record.FileID, err)
+		}
+	}
+
+	cached, err := db.GetFileContentByStepFileID(stepFileID)
+	if err != nil {
+		logrus.Errorf("查询文件 %s 的解析内容缓存失败: %v", stepFileID, err)
+	} else if cached != nil {
+		if hash == "" || cached.ContentHash == hash {
+			logrus.Printf("文件 %s 命中解析内容缓存", stepFileID)
+			return cached.Content, nil
+		}
+		// 缓存和 StepFun 的解析文件都对应替换前的内容
+		logrus.Printf("文件 ID %s 的内容已变化，不再使用解析文件 %s", record.FileID, stepFileID)
+		return uploadedFileContent(ctx, db, record, apiKey)
+	}
+
+	content, err := loadFileContent(ctx, stepFileID, apiKey)
+	if err != nil {
+		if record == nil {
+			return "", err
+		}
+		text, localErr := extractor.ExtractFile(uploadedFilePath(record))
+		if localErr != nil {
+			logrus.Warnf("本地解析文件 %s 失败: %v", record.FileID, localErr)
+			return "", err
+		}
+		logrus.Warnf("获取 StepFun 文件 %s 的内容失败，使用本地解析结果: %v", stepFileID, err)
+		content = text
+	}
+	if hash != "" {
+		saveFileContent(db, record.FileID, hash, stepFileID, content)
+	}
+	return content, nil
+}
+
+// saveFileContent 把解析内容按上传文件 ID 和本地文件哈希写入缓存，失败时只记录日志
+func saveFileContent(db *dbop.Database, fileID, hash, stepFileID, content string) {
+	if err := db.SaveFileContent(models.FileContent{
+		FileID:      fileID,
+		ContentHash: hash,
+		StepFileID:  stepFileID,
+		Content:     content,
+	}); err != nil {
+		logrus.Errorf("保存文件 %s 的解析内容缓存失败: %v", fileID, err)
+	}
+}
+
+// uploadedFilePath 返回上传文件在本地的完整路径，file_path 保存的是相对 FILE_PATH 的路径
+func uploadedFilePath(record *models.UploadedFile) string {
+	uploadDir := os.Getenv("FILE_PATH")
+	if uploadDir == "" {
+		uploadDir = "./uploads" // 默认值
+	}
+	return filepath.Join(uploadDir, record.FilePath)
+}
+
+// fileContentHash 计算本地文件内容的 SHA-256
+func fileContentHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("读取文件失败: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
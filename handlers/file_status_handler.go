@@ -0,0 +1,59 @@
+// file_status_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"openapi-cms/tool"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleRefreshKnowledgeBaseStatus 立即向 StepFun 查询知识库中文件的向量化状态，返回同步统计和最新的文件列表。
+// 本地知识库在上传时已完成向量化，直接返回文件列表
+func HandleRefreshKnowledgeBaseStatus(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kb, err := db.GetKnowledgeBaseByName(c.Param("name"))
+		if err != nil {
+			logrus.WithError(err).Error("查询知识库记录失败")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询知识库记录失败"})
+			return
+		}
+		if kb == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Knowledge base not found"})
+			return
+		}
+
+		var result tool.FileStatusSyncResult
+		if kb.ModelOwner != models.ModelOwnerLocal {
+			result, err = tool.SyncFileStatuses(c.Request.Context(), db, kb.ID)
+			if errors.Is(err, tool.ErrSyncInProgress) {
+				c.JSON(http.StatusConflict, gin.H{"error": "该知识库的文件状态正在刷新，请稍后再试"})
+				return
+			}
+			if err != nil {
+				logrus.WithError(err).Error("同步文件状态失败")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "同步文件状态失败"})
+				return
+			}
+		}
+
+		files, err := db.ListKnowledgeBaseFiles(kb.ID)
+		if err != nil {
+			logrus.WithError(err).Error("查询知识库文件失败")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询知识库文件失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"knowledge_base": kb.Name,
+			"checked":        result.Checked,
+			"completed":      result.Completed,
+			"failed":         result.Failed,
+			"errors":         result.Errors,
+			"files":          files,
+		})
+	}
+}
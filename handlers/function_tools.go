@@ -0,0 +1,335 @@
+// function_tools.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 函数工具执行的限制
+const (
+	maxToolRounds      = 5                // 一次聊天中最多执行几轮函数调用
+	toolTimeout        = 30 * time.Second // 单个函数调用的超时时间
+	maxToolResultChars = 8000             // 发回给模型的结果最大字符数
+)
+
+// toolEnv 函数工具执行时可以使用的上下文
+type toolEnv struct {
+	db              *dbop.Database
+	userName        string
+	isAdmin         bool         // 管理员可以访问所有用户的知识库
+	knowledgeBaseID string       // 聊天请求选择的本地知识库，本地检索工具默认在其中检索
+	sources         *chatSources // 检索工具把命中的片段记录为回答的出处
+}
+
+// functionTool 服务端注册的函数工具，以 function 类型告知模型，模型返回 tool_calls 后由服务端执行
+type functionTool struct {
+	Description string
+	Parameters  map[string]interface{} // 参数的 JSON Schema
+	Execute     func(ctx context.Context, env toolEnv, arguments json.RawMessage) (interface{}, error)
+}
+
+// functionTools 注册所有服务端函数工具，前端通过 function_tools 按名称启用
+var functionTools = map[string]functionTool{
+	"search_knowledge_bases": {
+		Description: "按关键字搜索当前用户的知识库，返回知识库的标识、名称、描述、标签和归属模型",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"keyword": map[string]interface{}{"type": "string", "description": "搜索关键字，为空时返回最近创建的知识库"},
+				"limit":   map[string]interface{}{"type": "integer", "description": "最多返回几条，默认 10，最大 50"},
+			},
+		},
+		Execute: searchKnowledgeBasesTool,
+	},
+	"list_knowledge_base_files": {
+		Description: "列出指定知识库中的文件及其处理状态",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{"type": "string", "description": "知识库标识（name）"},
+			},
+			"required": []interface{}{"name"},
+		},
+		Execute: listKnowledgeBaseFilesTool,
+	},
+	"read_uploaded_file": {
+		Description: "读取当前用户上传的文件内容，用于回答与该文件有关的问题",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"file_id": map[string]interface{}{"type": "string", "description": "上传文件的 file_id"},
+			},
+			"required": []interface{}{"file_id"},
+		},
+		Execute: readUploadedFileTool,
+	},
+	localSearchTool: {
+		Description: "在本地知识库中检索与问题相关的内容（同时匹配关键词和语义，产品名、型号等可以精确命中），返回最相关的文本片段及其所在的文件和位置",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{"type": "string", "description": "检索的问题或关键词"},
+				"top_k": map[string]interface{}{"type": "integer", "description": "返回几个片段，默认 5，最大 20"},
+				"name":  map[string]interface{}{"type": "string", "description": "本地知识库标识（name），为空时使用当前对话选择的知识库"},
+			},
+			"required": []interface{}{"query"},
+		},
+		Execute: searchLocalKnowledgeBaseTool,
+	},
+}
+
+// validateFunctionTools 校验前端启用的函数工具是否已注册
+func validateFunctionTools(names []string) error {
+	for _, name := range names {
+		if _, ok := functionTools[name]; !ok {
+			return fmt.Errorf("不支持的函数工具: %s", name)
+		}
+	}
+	return nil
+}
+
+// functionToolsFor 按模型能力构建 function 类型的工具列表。模型不支持 tools 时，strict 为 true 返回错误，否则不携带工具
+func functionToolsFor(spec models.ModelSpec, names []string, strict bool) ([]models.StepFunTool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if !spec.SupportsParam("tools") {
+		if strict {
+			return nil, fmt.Errorf("模型 %s 不支持函数工具", spec.Name)
+		}
+		return nil, nil
+	}
+	tools := make([]models.StepFunTool, 0, len(names))
+	for _, name := range names {
+		tool, ok := functionTools[name]
+		if !ok {
+			continue
+		}
+		tools = append(tools, models.StepFunTool{
+			Type: "function",
+			Function: models.StepFunToolFunction{
+				Name:        name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return tools, nil
+}
+
+// executeFunctionTool 执行一个函数调用，错误也作为结果返回给模型，由模型决定如何继续
+func executeFunctionTool(ctx context.Context, env toolEnv, enabled []string, call models.ChatToolCall) models.ChatToolResult {
+	result := models.ChatToolResult{
+		Object:     "chat.tool_result",
+		ToolCallID: call.ID,
+		Name:       call.Function.Name,
+		Arguments:  call.Function.Arguments,
+	}
+	fail := func(err error) models.ChatToolResult {
+		logrus.Printf("执行函数工具 %s 失败: %v", call.Function.Name, err)
+		result.Error = err.Error()
+		content, _ := json.Marshal(gin.H{"error": err.Error()})
+		result.Content = string(content)
+		return result
+	}
+
+	tool, ok := functionTools[call.Function.Name]
+	if !ok || !containsName(enabled, call.Function.Name) {
+		return fail(fmt.Errorf("未启用的函数工具: %s", call.Function.Name))
+	}
+	arguments := json.RawMessage(call.Function.Arguments)
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		arguments = json.RawMessage("{}")
+	}
+	if !json.Valid(arguments) {
+		return fail(fmt.Errorf("参数不是合法的 JSON"))
+	}
+
+	toolCtx, cancel := context.WithTimeout(ctx, toolTimeout)
+	defer cancel()
+	output, err := tool.Execute(toolCtx, env, arguments)
+	if err != nil {
+		return fail(err)
+	}
+	content, err := json.Marshal(output)
+	if err != nil {
+		return fail(fmt.Errorf("序列化结果失败: %w", err))
+	}
+	result.Content = truncateRunes(string(content), maxToolResultChars)
+	return result
+}
+
+// runFunctionTools 执行模型返回的函数调用，把结果发回模型继续生成，直到模型给出最终回答。
+// 每轮的函数调用和结果都以 tool_call、tool_result 事件转发给前端，返回所有轮次合计的用量
+func runFunctionTools(c *gin.Context, client *http.Client, policy retryPolicy, fallback *chatFallback, transcript *chatTranscript, stream chatWriter, env toolEnv, enabled []string, usage models.ChatUsage) (models.ChatUsage, error) {
+	provider := fallback.provider
+	for round := 0; ; round++ {
+		// web_search 等由厂商执行的工具调用只转发给前端，不在服务端执行
+		calls := functionCalls(transcript.toolCalls)
+		transcript.toolCalls = nil
+		if len(calls) == 0 {
+			return usage, nil
+		}
+		if round >= maxToolRounds {
+			return usage, fmt.Errorf("函数调用超过 %d 轮", maxToolRounds)
+		}
+		sender, ok := provider.(followUpSender)
+		if !ok {
+			return usage, fmt.Errorf("%s 不支持函数工具", provider.Name())
+		}
+
+		// 模型在发起调用前输出的文本也要带回，否则后续轮次看不到自己说过的话
+		messages := []models.StepFunMessage{{Role: "assistant", Content: transcript.nextRound(), ToolCalls: calls}}
+		for _, call := range calls {
+			result := executeFunctionTool(c.Request.Context(), env, enabled, call)
+			if err := stream.Event(sseEventToolResult, result); err != nil {
+				return usage, err
+			}
+			messages = append(messages, models.StepFunMessage{Role: "tool", ToolCallID: call.ID, Content: result.Content})
+		}
+		if err := env.sources.flush(stream); err != nil {
+			return usage, err
+		}
+
+		req, err := sender.FollowUp(messages...)
+		if err != nil {
+			return usage, err
+		}
+		resp, err := sendWithRetry(c.Request.Context(), client, req, policy, provider.Name(), fallback.currentModel())
+		if err != nil {
+			return usage, err
+		}
+		err = provider.StreamDeltas(resp.Body, func(delta *ChatDelta) error {
+			transcript.add(delta)
+			return stream.WriteDelta(delta)
+		})
+		resp.Body.Close()
+		_, turnUsage := provider.Usage()
+		usage = addUsage(usage, turnUsage)
+		if err != nil {
+			return usage, err
+		}
+		env.sources.addRetrievalCalls(env.db, transcript.toolCalls)
+		if err := env.sources.flush(stream); err != nil {
+			return usage, err
+		}
+	}
+}
+
+// functionCalls 筛选出需要服务端执行的 function 类型调用
+func functionCalls(calls []models.ChatToolCall) []models.ChatToolCall {
+	var result []models.ChatToolCall
+	for _, call := range calls {
+		if (call.Type == "" || call.Type == "function") && call.Function.Name != "" {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+// searchKnowledgeBasesTool 按关键字搜索知识库
+func searchKnowledgeBasesTool(ctx context.Context, env toolEnv, arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Keyword string `json:"keyword"`
+		Limit   int    `json:"limit"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("参数格式错误: %w", err)
+	}
+	if args.Limit <= 0 {
+		args.Limit = 10
+	} else if args.Limit > 50 {
+		args.Limit = 50
+	}
+	creatorID := env.userName
+	if env.isAdmin {
+		creatorID = ""
+	}
+	return env.db.SearchKnowledgeBases(strings.TrimSpace(args.Keyword), creatorID, args.Limit)
+}
+
+// canAccessKnowledgeBase 判断当前用户能否通过函数工具访问知识库：知识库的创建者和管理员可以访问
+func (env toolEnv) canAccessKnowledgeBase(kb *models.KnowledgeBase) bool {
+	return canAccessKnowledgeBase(kb, env.userName, env.isAdmin)
+}
+
+// canAccessKnowledgeBase 判断用户能否在聊天中使用知识库：知识库的创建者和管理员可以使用
+func canAccessKnowledgeBase(kb *models.KnowledgeBase, userName string, isAdmin bool) bool {
+	return kb != nil && (isAdmin || kb.CreatorID == userName)
+}
+
+// listKnowledgeBaseFilesTool 列出知识库中的文件
+func listKnowledgeBaseFilesTool(ctx context.Context, env toolEnv, arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("参数格式错误: %w", err)
+	}
+	kb, err := env.db.GetKnowledgeBaseByName(args.Name)
+	if err != nil {
+		return nil, err
+	}
+	// 不属于当前用户的知识库按不存在处理
+	if !env.canAccessKnowledgeBase(kb) {
+		return nil, fmt.Errorf("知识库 %s 不存在", args.Name)
+	}
+	files, err := env.db.ListKnowledgeBaseFiles(kb.ID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"knowledge_base": kb.Name, "display_name": kb.DisplayName, "files": files}, nil
+}
+
+// readUploadedFileTool 读取当前用户上传的文件，内容与聊天中携带的文件内容使用同一份解析缓存
+func readUploadedFileTool(ctx context.Context, env toolEnv, arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		FileID string `json:"file_id"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("参数格式错误: %w", err)
+	}
+	file, err := env.db.GetUploadedFileByID(args.FileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil || file.UserName != env.userName {
+		return nil, fmt.Errorf("文件 %s 不存在", args.FileID)
+	}
+
+	content, err := uploadedFileContent(ctx, env.db, file, os.Getenv("STEPFUN_API_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"file_id": file.FileID, "file_name": file.Filename, "content": truncateRunes(content, maxToolResultChars)}, nil
+}
+
+// truncateRunes 按字符截断文本
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
+
+// containsName 判断名称是否在列表中
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// generation_params.go
+package handlers
+
+import (
+	"fmt"
+	"openapi-cms/models"
+)
+
+// maxStopSequences stop 最多允许的停止词数量
+const maxStopSequences = 4
+
+// validateGenerationRanges 校验生成参数的取值范围，与具体模型无关
+func validateGenerationRanges(params models.GenerationParams) error {
+	if params.MaxTokens != nil && *params.MaxTokens <= 0 {
+		return fmt.Errorf("max_tokens 必须大于 0")
+	}
+	if params.MaxCompletionTokens != nil && *params.MaxCompletionTokens <= 0 {
+		return fmt.Errorf("max_completion_tokens 必须大于 0")
+	}
+	if params.Temperature != nil && (*params.Temperature < 0 || *params.Temperature > 2) {
+		return fmt.Errorf("temperature 必须介于 0 和 2 之间")
+	}
+	if params.TopP != nil && (*params.TopP <= 0 || *params.TopP > 1) {
+		return fmt.Errorf("top_p 必须大于 0 且不超过 1")
+	}
+	if params.N != nil && *params.N < 1 {
+		return fmt.Errorf("n 必须大于等于 1")
+	}
+	if params.FrequencyPenalty != nil && (*params.FrequencyPenalty < -2 || *params.FrequencyPenalty > 2) {
+		return fmt.Errorf("frequency_penalty 必须介于 -2 和 2 之间")
+	}
+	if len(params.Stop) > maxStopSequences {
+		return fmt.Errorf("stop 最多 %d 个", maxStopSequences)
+	}
+	if format := params.ResponseFormat; format != nil {
+		switch format.Type {
+		case "text", "json_object":
+		case "json_schema":
+			if format.JSONSchema == nil || format.JSONSchema.Name == "" || len(format.JSONSchema.Schema) == 0 {
+				return fmt.Errorf("response_format 为 json_schema 时必须提供 json_schema.name 和 json_schema.schema")
+			}
+		default:
+			return fmt.Errorf("response_format.type 只能是 text、json_object 或 json_schema")
+		}
+	}
+	return nil
+}
+
+// generationParamsFor 按模型能力整理生成参数。strict 为 true 时，模型不支持的参数返回错误；
+// 为 false 时（切换到备用模型）去掉或降级不支持的参数，尽量完成请求
+func generationParamsFor(spec models.ModelSpec, params models.GenerationParams, strict bool) (models.GenerationParams, error) {
+	// max_completion_tokens 视为 max_tokens 的别名，最后按模型需要的字段名发送
+	if params.MaxTokens == nil {
+		params.MaxTokens = params.MaxCompletionTokens
+	}
+	params.MaxCompletionTokens = nil
+
+	unsupported := func(param string) error {
+		if strict {
+			return fmt.Errorf("模型 %s 不支持参数 %s", spec.Name, param)
+		}
+		return nil
+	}
+	checks := []struct {
+		name string
+		set  bool
+		drop func()
+	}{
+		{"max_tokens", params.MaxTokens != nil, func() { params.MaxTokens = nil }},
+		{"temperature", params.Temperature != nil, func() { params.Temperature = nil }},
+		{"top_p", params.TopP != nil, func() { params.TopP = nil }},
+		{"n", params.N != nil, func() { params.N = nil }},
+		{"stop", len(params.Stop) > 0, func() { params.Stop = nil }},
+		{"frequency_penalty", params.FrequencyPenalty != nil, func() { params.FrequencyPenalty = nil }},
+	}
+	for _, check := range checks {
+		if check.set && !spec.SupportsParam(check.name) {
+			if err := unsupported(check.name); err != nil {
+				return params, err
+			}
+			check.drop()
+		}
+	}
+
+	if params.MaxTokens != nil && spec.MaxOutputTokens > 0 && *params.MaxTokens > spec.MaxOutputTokens {
+		if strict {
+			return params, fmt.Errorf("模型 %s 的 max_tokens 不能超过 %d", spec.Name, spec.MaxOutputTokens)
+		}
+		maxTokens := spec.MaxOutputTokens
+		params.MaxTokens = &maxTokens
+	}
+
+	maxN := spec.MaxN
+	if maxN < 1 {
+		maxN = 1
+	}
+	if params.N != nil && *params.N > maxN {
+		if strict {
+			return params, fmt.Errorf("模型 %s 的 n 不能超过 %d", spec.Name, maxN)
+		}
+		params.N = nil
+	}
+
+	if format := params.ResponseFormat; format != nil && !spec.SupportsResponseFormat(format.Type) {
+		if strict {
+			return params, fmt.Errorf("模型 %s 不支持 response_format %s", spec.Name, format.Type)
+		}
+		// json_schema 降级为 json_object，仍不支持时按 text 处理
+		if format.Type == "json_schema" && spec.SupportsResponseFormat("json_object") {
+			params.ResponseFormat = &models.ResponseFormat{Type: "json_object"}
+		} else {
+			params.ResponseFormat = nil
+		}
+	}
+
+	// 结构化输出：未指定 response_format 时，模型支持就开启 JSON Mode，否则只靠提示词约束
+	if params.PreferJSONObject && params.ResponseFormat == nil && spec.SupportsResponseFormat("json_object") {
+		params.ResponseFormat = &models.ResponseFormat{Type: "json_object"}
+	}
+
+	if spec.MaxTokensField == "max_completion_tokens" {
+		params.MaxCompletionTokens, params.MaxTokens = params.MaxTokens, nil
+	}
+	return params, nil
+}
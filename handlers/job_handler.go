@@ -0,0 +1,80 @@
+// job_handler.go
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/middleware"
+	"openapi-cms/models"
+	"openapi-cms/tool"
+	"os"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	tool.RegisterJobHandler(models.JobFileExtract, runFileExtractJob)
+}
+
+// HandleGetJob 查询后台任务的状态和进度，只有任务的创建者和管理员可以查看
+func HandleGetJob(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userName, ok := middleware.GetUserName(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+			return
+		}
+		job, err := db.GetJob(c.Param("id"))
+		if err != nil {
+			logrus.Errorf("查询任务失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		// 不属于当前用户的任务按不存在处理
+		if job == nil || (job.Username != userName && !middleware.IsAdmin(c)) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// runFileExtractJob 预先解析聊天窗口上传的文件，解析结果写入缓存，发送消息时不再等待解析
+func runFileExtractJob(ctx context.Context, db *dbop.Database, job *models.Job, report func(stage string, progress int)) (interface{}, error) {
+	record, err := db.GetUploadedFileByID(job.FileID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, tool.Permanent(fmt.Errorf("未找到 FileID 为 %s 的上传文件", job.FileID))
+	}
+	report("extract", 30)
+	content, err := uploadedFileContent(ctx, db, record, os.Getenv("STEPFUN_API_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"characters": utf8.RuneCountInString(content)}, nil
+}
+
+// waitFileExtractJob 文件的解析任务尚未结束时等待其完成，避免同一个文件被重复上传解析。
+// 任务失败或等待被取消时不返回错误，由调用方重新解析
+func waitFileExtractJob(ctx context.Context, db *dbop.Database, fileID string) {
+	job, err := db.FindActiveJob(models.JobFileExtract, fileID, "")
+	if err != nil {
+		logrus.Errorf("查询文件 %s 的解析任务失败: %v", fileID, err)
+		return
+	}
+	if job == nil {
+		return
+	}
+	logrus.Infof("文件 %s 正在后台解析，等待任务 %s 完成", fileID, job.ID)
+	if job, err = tool.WaitJob(ctx, db, job.ID); err != nil {
+		logrus.Warnf("等待文件 %s 的解析任务失败: %v", fileID, err)
+	} else if job.Status == models.JobFailed {
+		logrus.Warnf("文件 %s 的解析任务失败: %s", fileID, job.Error)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"openapi-cms/middleware"
 	"openapi-cms/models"
+	"openapi-cms/tool"
 	"os"
 	"regexp"
 	"strings"
@@ -181,13 +182,16 @@ func handleModelOwnerAPI(c *gin.Context, db models.DatabaseInterface, payload st
 		// 记录警告并跳过 ID 更新
 		logrus.WithField("model_owner", payload.ModelOwner).Warn("Model owner API not implemented, skipping ID update")
 		return id, nil
-	//case "local":
-	//	newID, err := localAPI(c, db, payload)
-	//	if err != nil {
-	//		return "", err
-	//	}
-	//	// 本地逻辑已经在数据库插入时处理，不需调用外部 API
-	//	return newID, nil
+	case models.ModelOwnerLocal:
+		// 本地知识库不需调用外部 API，直接使用插入数据库时生成的 ID；历史记录没有 ID 时补上
+		if id == "" {
+			id = fmt.Sprintf("%s%s", payload.Name, time.Now().Format("20060102150405"))
+			if err := db.UpdateKnowledgeBaseIDByName(payload.Name, id); err != nil {
+				logrus.WithError(err).Error("Error updating knowledge base ID in database")
+				return "", err
+			}
+		}
+		return id, nil
 	default:
 		// 无效的 ModelOwner
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid model owner"})
@@ -336,6 +340,160 @@ func HandleUpdateKnowledgeBase(c *gin.Context, db models.DatabaseInterface) {
 	})
 }
 
+// HandleDeleteKnowledgeBase 删除知识库，只有创建者或管理员可以删除。
+// StepFun 知识库先解绑文件并删除远端知识库，远端删除失败时不删除本地记录，便于重试；
+// delete_files=true 时同时删除上传到 StepFun 的文件。本地的 files 记录和切片随知识库删除，
+// uploaded_files 默认保留，purge=true 时删除只属于该知识库的上传文件及磁盘上的文件（隐含 delete_files），
+// StepFun 文件删除失败的上传文件不删除，便于之后清理
+func HandleDeleteKnowledgeBase(c *gin.Context, db models.DatabaseInterface) {
+	kb, ok := getOwnedKnowledgeBase(c, db)
+	if !ok {
+		return
+	}
+	purge := c.Query("purge") == "true"
+	deleteFiles := purge || c.Query("delete_files") == "true"
+
+	files, err := db.ListKnowledgeBaseFiles(kb.ID)
+	if err != nil {
+		logrus.WithError(err).Error("查询知识库文件失败")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询知识库文件失败"})
+		return
+	}
+
+	// 文件解绑和删除失败不影响删除知识库，记录在 warnings 中返回
+	warnings := []string{}
+	var keepFileIDs []string
+	if kb.ModelOwner == "stepfun" && kb.ID != "" {
+		for _, file := range files {
+			if err := tool.UnbindFileFromVectorStore(kb.ID, file.VectorFileID); err != nil {
+				logrus.WithError(err).Warnf("解绑文件 %s 失败", file.VectorFileID)
+				warnings = append(warnings, fmt.Sprintf("解绑文件 %s 失败: %v", file.FileName, err))
+			}
+			// 解绑失败时仍然删除文件，文件删除后也不再属于知识库
+			if deleteFiles {
+				if err := tool.DeleteStepFunFile(file.VectorFileID); err != nil {
+					logrus.WithError(err).Warnf("删除 StepFun 文件 %s 失败", file.VectorFileID)
+					warnings = append(warnings, fmt.Sprintf("删除 StepFun 文件 %s 失败: %v", file.FileName, err))
+					keepFileIDs = append(keepFileIDs, file.FileID)
+				}
+			}
+		}
+		if err := tool.DeleteVectorStore(kb.ID); err != nil {
+			logrus.WithError(err).Errorf("删除 StepFun 知识库 %s 失败", kb.ID)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "删除 StepFun 知识库失败，请稍后重试", "warnings": warnings})
+			return
+		}
+	}
+
+	purged, err := db.DeleteKnowledgeBase(kb.Name, purge, keepFileIDs)
+	if err != nil {
+		logrus.WithError(err).Error("删除知识库记录失败")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除知识库记录失败"})
+		return
+	}
+	for _, record := range purged {
+		if err := os.Remove(uploadedFilePath(record)); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).Warnf("删除本地文件 %s 失败", record.FilePath)
+			warnings = append(warnings, fmt.Sprintf("删除本地文件 %s 失败: %v", record.Filename, err))
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"name": kb.Name, "files": len(files), "purged": len(purged)}).Info("知识库已删除")
+	c.JSON(http.StatusOK, gin.H{
+		"name":          kb.Name,
+		"deleted_files": len(files),
+		"purged_files":  len(purged),
+		"warnings":      warnings,
+	})
+}
+
+// HandleRemoveKnowledgeBaseFile 从知识库中移除一个文件（file_id 为上传文件ID），只有知识库的创建者或管理员可以操作。
+// StepFun 知识库先调用远端接口解除绑定，失败时不修改本地记录；delete_file=true 时同时删除 StepFun 上的文件。
+// 返回移除后知识库的 usage_bytes，StepFun 知识库以远端返回为准
+func HandleRemoveKnowledgeBaseFile(c *gin.Context, db models.DatabaseInterface) {
+	kb, ok := getOwnedKnowledgeBase(c, db)
+	if !ok {
+		return
+	}
+	file, err := db.GetKnowledgeBaseFile(kb.ID, c.Param("file_id"))
+	if err != nil {
+		logrus.WithError(err).Error("查询知识库文件失败")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询知识库文件失败"})
+		return
+	}
+	if file == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "知识库中没有该文件"})
+		return
+	}
+
+	warnings := []string{}
+	isStepFun := kb.ModelOwner == "stepfun" && kb.ID != ""
+	if isStepFun {
+		if err := tool.UnbindFileFromVectorStore(kb.ID, file.VectorFileID); err != nil {
+			logrus.WithError(err).Errorf("解绑文件 %s 失败", file.VectorFileID)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "从 StepFun 知识库移除文件失败，请稍后重试"})
+			return
+		}
+		if c.Query("delete_file") == "true" {
+			if err := tool.DeleteStepFunFile(file.VectorFileID); err != nil {
+				logrus.WithError(err).Warnf("删除 StepFun 文件 %s 失败", file.VectorFileID)
+				warnings = append(warnings, fmt.Sprintf("删除 StepFun 文件失败: %v", err))
+			}
+		}
+	}
+
+	if err := db.DeleteKnowledgeBaseFile(kb.ID, file.FileID); err != nil {
+		logrus.WithError(err).Error("删除知识库文件记录失败")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除知识库文件记录失败"})
+		return
+	}
+
+	usageBytes, err := db.KnowledgeBaseUsageBytes(kb.ID)
+	if err != nil {
+		logrus.WithError(err).Error("统计知识库用量失败")
+	}
+	if isStepFun {
+		if store, err := tool.GetVectorStore(kb.ID); err != nil {
+			logrus.WithError(err).Warnf("查询 StepFun 知识库 %s 的用量失败，使用本地统计", kb.ID)
+		} else {
+			usageBytes = store.UsageBytes
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"name": kb.Name, "file_id": file.FileID}).Info("文件已从知识库移除")
+	c.JSON(http.StatusOK, gin.H{
+		"name":        kb.Name,
+		"file_id":     file.FileID,
+		"file_name":   file.FileName,
+		"usage_bytes": usageBytes,
+		"warnings":    warnings,
+	})
+}
+
+// getOwnedKnowledgeBase 按 URL 中的 name 查询知识库，并校验当前用户是创建者或管理员。失败时已写入响应，返回 false
+func getOwnedKnowledgeBase(c *gin.Context, db models.DatabaseInterface) (*models.KnowledgeBase, bool) {
+	userName, ok := middleware.GetUserName(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+	kb, err := db.GetKnowledgeBaseByName(c.Param("name"))
+	if err != nil {
+		logrus.WithError(err).Error("查询知识库记录失败")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询知识库记录失败"})
+		return nil, false
+	}
+	if kb == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Knowledge base not found"})
+		return nil, false
+	}
+	if kb.CreatorID != userName && !middleware.IsAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有知识库的创建者或管理员可以修改知识库"})
+		return nil, false
+	}
+	return kb, true
+}
+
 // callStepFunAPI 封装了对 StepFun API 的调用逻辑-创建知识库
 func callStepFunAPI(c *gin.Context, payload struct {
 	Name        string `json:"name"`
@@ -406,25 +564,3 @@ func baichuanAPI() error {
 	// 返回尚未实现的提示
 	return fmt.Errorf("This functionality is not yet implemented for the selected model.")
 }
-
-//// localAPI 修改为返回生成的ID和error
-//func localAPI(c *gin.Context, db models.DatabaseInterface, payload struct {
-//	Name        string `json:"name"`
-//	DisplayName string `json:"display_name"`
-//	Description string `json:"description"`
-//	Tags        string `json:"tags"`
-//	ModelOwner  string `json:"model_owner"`
-//}) (string, error) {
-//	// 生成基于 name 和时间的 ID，时间精确到秒
-//	timeNow := time.Now().Format("20060102150405")
-//	id := fmt.Sprintf("%s%s", payload.Name, timeNow)
-//
-//	// 插入数据库
-//	if err := db.InsertVectorStore(id, payload.Name, payload.DisplayName, payload.Description, payload.Tags, payload.ModelOwner, "admin"); err != nil {
-//		logrus.WithError(err).Error("Error inserting vector store into database")
-//		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-//		return "", err
-//	}
-//	// 返回生成的ID
-//	return id, nil
-//}
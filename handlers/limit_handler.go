@@ -0,0 +1,112 @@
+// limit_handler.go
+package handlers
+
+import (
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/middleware"
+	"openapi-cms/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleListUserLimits 列出默认配额及所有单独设置过配额的用户（管理员）
+func HandleListUserLimits(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limits, err := db.ListUserLimits()
+		if err != nil {
+			logrus.Errorf("查询用户配额失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"default": middleware.DefaultUserLimit(""),
+			"users":   limits,
+		})
+	}
+}
+
+// HandleGetUserLimit 查看某个用户当前生效的配额及当日用量（管理员）
+func HandleGetUserLimit(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		limit, err := db.GetUserLimit(username)
+		if err != nil {
+			logrus.Errorf("查询用户配额失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		if limit == nil {
+			defaultLimit := middleware.DefaultUserLimit(username)
+			limit = &defaultLimit
+		}
+		tokens, err := db.GetDailyTokenUsage(username)
+		if err != nil {
+			logrus.Errorf("查询当日 token 用量失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		uploadBytes, err := db.GetDailyUploadBytes(username)
+		if err != nil {
+			logrus.Errorf("查询当日上传量失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"limit":              limit,
+			"today_tokens":       tokens,
+			"today_upload_bytes": uploadBytes,
+		})
+	}
+}
+
+// HandleUpdateUserLimit 设置某个用户的配额（管理员），0 表示不限制
+func HandleUpdateUserLimit(db *dbop.Database, limiter *middleware.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		var payload models.UserLimit
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求负载"})
+			return
+		}
+		if payload.RequestsPerMinute < 0 || payload.DailyTokens < 0 || payload.DailyUploadBytes < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "配额不能为负数"})
+			return
+		}
+
+		user, err := dbop.GetUserByUsername(db, username)
+		if err != nil {
+			logrus.Errorf("查询用户失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		if user == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+
+		payload.Username = username
+		if err := db.UpsertUserLimit(payload); err != nil {
+			logrus.Errorf("更新用户配额失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		limiter.Invalidate(username)
+		c.JSON(http.StatusOK, payload)
+	}
+}
+
+// HandleResetUserLimit 删除用户的单独配置，恢复默认配额（管理员）
+func HandleResetUserLimit(db *dbop.Database, limiter *middleware.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if err := db.DeleteUserLimit(username); err != nil {
+			logrus.Errorf("删除用户配额失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		limiter.Invalidate(username)
+		c.JSON(http.StatusOK, middleware.DefaultUserLimit(username))
+	}
+}
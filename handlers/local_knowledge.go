@@ -0,0 +1,107 @@
+// local_knowledge.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"openapi-cms/tool/rag"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// localSearchTool 本地知识库检索工具的名称
+const localSearchTool = "search_local_knowledge_base"
+
+// prepareLocalRetrieval vector_store_id 指向本地知识库时，不再交给厂商的 retrieval 工具，
+// 改为启用本地检索函数工具并在系统提示中要求模型先检索。返回本地知识库的 ID，不是本地知识库时为空。
+// 只有知识库的创建者和管理员可以使用，其他用户按不存在处理
+func prepareLocalRetrieval(db *dbop.Database, userName string, isAdmin bool, payload *models.RequestPayload) (string, error) {
+	vectorStoreID := strings.TrimSpace(payload.VectorStoreID)
+	if vectorStoreID == "" {
+		return "", nil
+	}
+	kb, err := db.GetKnowledgeBaseByID(vectorStoreID)
+	if err != nil {
+		return "", newChatError(http.StatusInternalServerError, "查询知识库失败", err)
+	}
+	if kb == nil || kb.ModelOwner != models.ModelOwnerLocal {
+		return "", nil
+	}
+	if !canAccessKnowledgeBase(kb, userName, isAdmin) {
+		return "", newChatError(http.StatusNotFound, "知识库不存在", nil)
+	}
+
+	payload.VectorStoreID = ""
+	if !containsName(payload.FunctionTools, localSearchTool) {
+		payload.FunctionTools = append(payload.FunctionTools, localSearchTool)
+	}
+	instruction := fmt.Sprintf("回答前先调用 %s 工具在知识库「%s」中检索相关内容，依据检索结果回答并注明出处（文件名和位置）；检索不到相关内容时如实说明。", localSearchTool, kb.DisplayName)
+	if payload.SystemPrompt == "" {
+		payload.SystemPrompt = instruction
+	} else {
+		payload.SystemPrompt += "\n\n" + instruction
+	}
+	return kb.ID, nil
+}
+
+// searchLocalKnowledgeBaseTool 在本地知识库中混合检索与问题最相关的切片，结果带有文件名和位置，便于回答时注明出处。
+// 未指定知识库时使用聊天请求中的知识库
+func searchLocalKnowledgeBaseTool(ctx context.Context, env toolEnv, arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Query string `json:"query"`
+		TopK  int    `json:"top_k"`
+		Name  string `json:"name"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("参数格式错误: %w", err)
+	}
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, fmt.Errorf("query 不能为空")
+	}
+	if args.TopK <= 0 {
+		args.TopK = 5
+	} else if args.TopK > 20 {
+		args.TopK = 20
+	}
+
+	vectorStoreID := env.knowledgeBaseID
+	if args.Name != "" {
+		kb, err := env.db.GetKnowledgeBaseByName(args.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !env.canAccessKnowledgeBase(kb) || kb.ModelOwner != models.ModelOwnerLocal {
+			return nil, fmt.Errorf("本地知识库 %s 不存在", args.Name)
+		}
+		vectorStoreID = kb.ID
+	}
+	if vectorStoreID == "" {
+		return nil, fmt.Errorf("未指定知识库")
+	}
+
+	embedder, err := rag.NewEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	reranker, err := rag.NewReranker()
+	if err != nil {
+		// 重排序是可选的，配置有误时只按融合排名返回
+		logrus.Warnf("创建重排序服务失败: %v", err)
+		reranker = nil
+	}
+	chunks, err := rag.Search(ctx, env.db, embedder, reranker, vectorStoreID, args.Query, args.TopK)
+	if err != nil {
+		return nil, err
+	}
+	if chunks == nil {
+		chunks = []models.ScoredChunk{}
+	}
+	env.sources.addChunks(chunks)
+	return gin.H{"query": args.Query, "results": chunks}, nil
+}
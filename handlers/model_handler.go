@@ -0,0 +1,33 @@
+// model_handler.go
+package handlers
+
+import (
+	"net/http"
+	"openapi-cms/tool/modelcatalog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleListModels 列出模型目录中的可用模型，可通过 provider 参数筛选厂商
+func HandleListModels() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider := c.Query("provider")
+		if provider != "" {
+			if _, ok := chatProviders[provider]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的厂商: " + provider})
+				return
+			}
+		}
+		catalog := modelcatalog.Default()
+		list := catalog.List(provider)
+		// 返回各厂商未指定 performance_level 时使用的档位
+		defaultTiers := make(map[string]string)
+		for _, spec := range list {
+			defaultTiers[spec.Provider] = catalog.DefaultTier(spec.Provider)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"models":        list,
+			"default_tiers": defaultTiers,
+		})
+	}
+}
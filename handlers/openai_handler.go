@@ -1,260 +1,196 @@
 package handlers
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"openapi-cms/dbop"
-	"openapi-cms/middleware"
 	"openapi-cms/models"
+	"openapi-cms/tool/modelcatalog"
+	"openapi-cms/tool/tokenizer"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
-// handleChatMessagesChatGpt 处理 chatgpt 聊天消息的请求
-func HandleChatMessagesChatGpt(db *dbop.Database) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var payload models.RequestPayload
-		if err := c.ShouldBindJSON(&payload); err != nil {
-			logrus.Printf("Error binding JSON: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
-			return
-		}
-		userName, ok := middleware.GetUserName(c)
-		if !ok {
-			logrus.Warn("userName not found or invalid")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		fmt.Println("****userName:", userName)
-
-		// 打印收到的请求报文
-		reqBodyBytes, err := json.Marshal(payload)
-		if err != nil {
-			logrus.Printf("Error marshaling request payload: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-			return
-		}
-		logrus.Printf("请求报文: %s", reqBodyBytes)
-
-		apiKey := os.Getenv("OPENAI_API_KEY")
-		if apiKey == "" {
-			logrus.Println("OPENAI_API_KEY is not set")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server configuration error"})
-			return
-		}
-		apiURL := os.Getenv("OPENAI_API_URL")
-		if apiURL == "" {
-			logrus.Println("OPENAI_API_URL is not set")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server configuration error"})
-			return
-		}
-
-		// 根据 PerformanceLevel 设置 systemMessage
-		var systemMessage models.StepFunMessage
-		if payload.PerformanceLevel == "fast" {
-			systemMessage = models.StepFunMessage{
-				Role:    "system",
-				Content: payload.SystemPrompt,
-			}
-		}
-
-		var userMessage models.StepFunMessage
+// openAIProvider 实现 OpenAI 兼容接口的聊天厂商
+type openAIProvider struct {
+	db           *dbop.Database
+	apiKey       string
+	apiURL       string
+	model        string
+	stream       bool
+	usage        models.ChatUsage
+	payload      *models.RequestPayload
+	userMessage  models.StepFunMessage
+	fileContents []string
+	fallbacks    []string                // 当前模型失败时依次尝试的候选模型
+	followUps    []models.StepFunMessage // 追加在用户消息之后的消息，例如结构化输出的修复轮次
+}
 
-		// 判断是否为图片消息
-		if payload.FileType == "img" && len(payload.FileIDs) > 0 {
-			// 处理图片消息
-			userMessage, err = processImageMessages(db, payload)
-			if err != nil {
-				logrus.Printf("处理图片消息时出错: %v", err)
-				// 假设 processImageMessages 已经处理了响应
-				return
-			}
-		} else if payload.FileType == "file" && len(payload.FileIDs) > 0 {
-			// 处理文件消息，把 vector_file_id 放到数组里
-			err = processUploadedFiles(db, &payload, apiKey, c)
-			if err != nil {
-				// processUploadedFiles 已经处理了响应
-				return
-			}
-			// 构建用户消息
-			userMessage = models.StepFunMessage{
-				Role:    "user",
-				Content: payload.Query,
-			}
+// newOpenAIProvider 创建 OpenAI 兼容的聊天厂商实例
+func newOpenAIProvider(db *dbop.Database) ChatProvider {
+	return &openAIProvider{db: db}
+}
 
-		} else {
-			// 构建用户消息
-			userMessage = models.StepFunMessage{
-				Role:    "user",
-				Content: payload.Query,
-			}
-		}
+// Name 返回厂商标识
+func (p *openAIProvider) Name() string {
+	return "openai"
+}
 
-		// 构建初始消息列表
-		messages := []models.StepFunMessage{}
+// BuildRequest 组装消息、根据模型目录选择模型，并构建发往 OpenAI 兼容接口的请求
+func (p *openAIProvider) BuildRequest(c *gin.Context, payload *models.RequestPayload) (*http.Request, error) {
+	p.apiKey = os.Getenv("OPENAI_API_KEY")
+	if p.apiKey == "" {
+		return nil, newChatError(http.StatusInternalServerError, "Server configuration error", fmt.Errorf("OPENAI_API_KEY is not set"))
+	}
+	p.apiURL = os.Getenv("OPENAI_API_URL")
+	if p.apiURL == "" {
+		return nil, newChatError(http.StatusInternalServerError, "Server configuration error", fmt.Errorf("OPENAI_API_URL is not set"))
+	}
 
-		// 只有在 PerformanceLevel 为 "fast" 时才添加 systemMessage
-		if payload.PerformanceLevel == "fast" {
-			if systemMessage.Role != "" && systemMessage.Content != "" {
-				messages = append(messages, systemMessage)
-			}
+	// 构建用户消息
+	userMessage := models.StepFunMessage{
+		Role:    "user",
+		Content: payload.Query,
+	}
+	var fileContents []string
+	if payload.FileType == "img" && len(payload.FileIDs) > 0 {
+		// 处理图片消息
+		var err error
+		userMessage, err = processImageMessages(p.db, *payload)
+		if err != nil {
+			return nil, newChatError(http.StatusBadRequest, err.Error(), err)
 		}
-
-		// 如果有 conversation_history，则将其添加到消息列表中
-		if len(payload.ConversationHistory) > 0 {
-			for _, msg := range payload.ConversationHistory {
-				// 只添加有效的消息（role 和 content 不为空）
-				if msg.Role != "" && msg.Content != "" {
-					messages = append(messages, msg)
-				}
-			}
+	} else if payload.FileType == "file" && len(payload.FileIDs) > 0 {
+		// 处理文件消息，默认在本地解析，本地无法解析的格式借用 StepFun 的能力
+		contents, err := processUploadedFiles(c.Request.Context(), p.db, payload, os.Getenv("STEPFUN_API_KEY"))
+		if err != nil {
+			return nil, err
 		}
+		fileContents = append(fileContents, contents...)
+	}
 
-		// 最后添加用户消息
-		if userMessage.Role != "" && userMessage.Content != "" {
-			messages = append(messages, userMessage)
+	// 如果前端传了 vector_file_id，那么将文件内容解析并存入 messages 里
+	if len(payload.VectorFileIds) > 0 {
+		contents, err := loadFileContents(c.Request.Context(), p.db, payload.VectorFileIds, os.Getenv("STEPFUN_API_KEY"))
+		if err != nil {
+			return nil, err
 		}
+		fileContents = append(fileContents, contents...)
+	}
 
-		// 如果前端传了 vector_file_id，那么将文件内容解析并存入 messages 里
-		if len(payload.VectorFileIds) > 0 {
-			insertIndex := 1
-			for _, vectorFileId := range payload.VectorFileIds {
-				fmt.Println("vectorFileId", vectorFileId)
-				fileContent := loadFileContent(c, vectorFileId, apiKey)
-				if fileContent != "" {
-					contentMessage := models.StepFunMessage{
-						Role:    "user",
-						Content: fileContent,
-					}
-					// 在指定位置插入 contentMessage
-					messages = append(messages[:insertIndex], append([]models.StepFunMessage{contentMessage}, messages[insertIndex:]...)...)
-					// 每次插入后，插入位置增加 2
-					insertIndex += 2
-				}
-			}
-		}
+	// 历史过长时用摘要代替较早的消息
+	fitConversationContext(c.Request.Context(), p.db, p.Name(), payload, fileContents, userMessage)
+
+	// 根据模型目录选择模型，o1 系列不支持 system 消息
+	p.payload, p.userMessage, p.fileContents = payload, userMessage, fileContents
+	estimate := p.EstimatePromptTokens()
+	spec, err := modelcatalog.Default().Select(p.Name(), payload.PerformanceLevel, payload.FileType, func(spec models.ModelSpec) bool {
+		return estimate <= spec.MaxInputTokens
+	})
+	if err != nil {
+		return nil, newChatError(http.StatusBadRequest, err.Error(), err)
+	}
+	p.fallbacks = modelcatalog.Default().Fallbacks(p.Name(), spec.Name, payload.PerformanceLevel, payload.FileType, estimate)
+	// 按所选模型校验生成参数和函数工具
+	if _, err := generationParamsFor(spec, payload.GenerationParams, true); err != nil {
+		return nil, newChatError(http.StatusBadRequest, err.Error(), err)
+	}
+	if _, err := functionToolsFor(spec, payload.FunctionTools, true); err != nil {
+		return nil, newChatError(http.StatusBadRequest, err.Error(), err)
+	}
+	return p.buildRequest(spec)
+}
 
-		// 根据 PerformanceLevel 设置模型
-		model := "gpt-4"
-		Stream := false
-		if payload.PerformanceLevel == "fast" {
-			model = "gpt-4o-mini"
-			Stream = true
-		} else if payload.PerformanceLevel == "balanced" {
-			model = "o1-preview"
-		} else {
-			model = "o1-pro"
-			Stream = true
+// NextModel 切换到下一个候选模型并重新构建请求
+func (p *openAIProvider) NextModel() (*http.Request, bool) {
+	for len(p.fallbacks) > 0 {
+		name := p.fallbacks[0]
+		p.fallbacks = p.fallbacks[1:]
+		spec, ok := modelcatalog.Default().Get(name)
+		if !ok {
+			continue
 		}
-
-		stepFunRequest := models.StepFunRequestPayload{
-			Model:    model,
-			Stream:   Stream,
-			Messages: messages,
+		req, err := p.buildRequest(spec)
+		if err != nil {
+			logrus.Errorf("构建 %s 的请求失败: %v", name, err)
+			continue
 		}
+		return req, true
+	}
+	return nil, false
+}
 
-		// 构建工具列表
-		tools := []models.StepFunTool{}
+// buildRequest 按模型能力构建请求：是否流式、是否携带 system 消息，去掉模型不支持的生成参数
+func (p *openAIProvider) buildRequest(spec models.ModelSpec) (*http.Request, error) {
+	p.model, p.stream = spec.Name, spec.SupportsStream
+	systemPrompt := ""
+	if spec.SupportsSystem {
+		systemPrompt = p.payload.SystemPrompt
+	}
+	systemPrompt, history := historyWithSummary(systemPrompt, p.payload.ConversationHistory, p.payload.HistorySummary, spec.SupportsSystem)
+
+	params, _ := generationParamsFor(spec, p.payload.GenerationParams, false)
+	openAIRequest := models.StepFunRequestPayload{
+		Model:            p.model,
+		Stream:           p.stream,
+		Messages:         append(buildChatMessages(systemPrompt, history, p.fileContents, p.userMessage), p.followUps...),
+		GenerationParams: params,
+	}
+	if p.stream {
+		openAIRequest.StreamOptions = &models.StreamOptions{IncludeUsage: true}
+	}
 
-		// 添加 web_search 工具
-		if payload.WebSearch {
-			webSearchTool := models.StepFunTool{
+	// 添加 web_search 工具
+	if p.payload.WebSearch {
+		openAIRequest.Tools = []models.StepFunTool{
+			{
 				Type: "web_search",
 				Function: models.StepFunToolFunction{
 					Description: "这个工具web_search可以用来搜索互联网的信息",
 				},
-			}
-			tools = append(tools, webSearchTool)
-		}
-
-		// 将工具添加到请求中
-		stepFunRequest.Tools = tools
-
-		// 将请求体编码为 JSON
-		stepFunReqBodyBytes, err := json.Marshal(stepFunRequest)
-		if err != nil {
-			logrus.Printf("Error marshaling StepFun request payload: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-			return
-		}
-
-		// 打印发送给 StepFun API 的请求报文
-		fmt.Printf("OPENAI API 请求报文: %s", stepFunReqBodyBytes)
-
-		// 创建向 StepFun API 发送的 HTTP 请求
-		url := fmt.Sprintf("%s/chat/completions", apiURL)
-		stepFunReq, err := http.NewRequest("POST", url, bytes.NewBuffer(stepFunReqBodyBytes))
-		if err != nil {
-			logrus.Printf("Error creating request: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-			return
+			},
 		}
+	}
+	// 添加服务端函数工具，模型不支持时不携带
+	functions, _ := functionToolsFor(spec, p.payload.FunctionTools, false)
+	openAIRequest.Tools = append(openAIRequest.Tools, functions...)
 
-		// 设置请求头
-		stepFunReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-		stepFunReq.Header.Set("Content-Type", "application/json")
-
-		// 发送请求
-		client := &http.Client{}
-		resp, err := client.Do(stepFunReq)
-		if err != nil {
-			logrus.Printf("Error sending request to StepFun API: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to communicate with StepFun API"})
-			return
-		}
-		defer resp.Body.Close()
+	return newJSONRequest(fmt.Sprintf("%s/chat/completions", p.apiURL), p.apiKey, openAIRequest)
+}
 
-		// 检查响应状态
-		if resp.StatusCode != http.StatusOK {
-			errorText := ""
-			if resp.Body != nil {
-				scanner := bufio.NewScanner(resp.Body)
-				for scanner.Scan() {
-					errorText += scanner.Text()
-				}
-			}
-			logrus.Printf("OpenAI returned status: %s, message: %s", resp.Status, errorText)
-			c.JSON(resp.StatusCode, gin.H{"error": "OpenAI error"})
-			return
-		}
+// FollowUp 在已发送的消息之后追加消息，用当前模型重新构建请求
+func (p *openAIProvider) FollowUp(messages ...models.StepFunMessage) (*http.Request, error) {
+	spec, ok := modelcatalog.Default().Get(p.model)
+	if !ok {
+		return nil, fmt.Errorf("模型目录中没有 %s", p.model)
+	}
+	p.followUps = append(p.followUps, messages...)
+	p.usage = models.ChatUsage{}
+	return p.buildRequest(spec)
+}
 
-		// 设置响应头。如果stream==false则使用application/json，否则使用text/event-stream
-		heard_stream := "text/event-stream"
-		if !Stream {
-			heard_stream = "application/json"
-		}
-		//c.Writer.Header().Set("Content-Type", "application/json")
-		c.Writer.Header().Set("Content-Type", heard_stream)
-		c.Writer.Header().Set("Cache-Control", "no-cache")
-		c.Writer.Header().Set("Connection", "keep-alive")
+// StreamDeltas 解析 OpenAI 的响应，非流式模型的 JSON 响应会被转换为单个 chunk
+func (p *openAIProvider) StreamDeltas(body io.Reader, emit func(*ChatDelta) error) error {
+	if !p.stream {
+		return readOpenAICompletion(body, emit, &p.usage)
+	}
+	return streamOpenAICompatible(body, emit, &p.usage)
+}
 
-		// 使用 bufio.Scanner 逐行读取响应体
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.TrimSpace(line) == "" {
-				continue // 跳过空行
-			}
-			// 打印返回的报文
-			logrus.Printf("OPENAI 返回报文: %s", line)
-			// 发送数据到前端，确保 JSON 字符串不会被破坏
-			c.Writer.Write([]byte(line + "\n\n"))
-			c.Writer.Flush()
-		}
+// EstimatePromptTokens 本地估算请求消息的 token 数
+func (p *openAIProvider) EstimatePromptTokens() int {
+	systemPrompt, history := historyWithSummary(p.payload.SystemPrompt, p.payload.ConversationHistory, p.payload.HistorySummary, true)
+	return tokenizer.EstimateMessages(buildChatMessages(systemPrompt, history, p.fileContents, p.userMessage))
+}
 
-		// 处理可能发生的扫描错误
-		if err := scanner.Err(); err != nil {
-			logrus.Printf("Error scanning response body: %v", err)
-		}
-	}
+// Usage 返回本次请求使用的模型和 token 用量
+func (p *openAIProvider) Usage() (string, models.ChatUsage) {
+	return p.model, p.usage
 }
 
 // processImageMessages 处理图片消息，通过读取和编码图片文件来构建消息内容。
@@ -0,0 +1,335 @@
+// prompt_template_handler.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/middleware"
+	"openapi-cms/models"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// promptTemplateNamePattern 模板名称只允许字母、数字、下划线和连字符
+	promptTemplateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,100}$`)
+	// promptVariableNamePattern 变量名的格式
+	promptVariableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// promptVariablePattern 匹配模板中的 {{name}}，允许花括号内有空格
+	promptVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// retrievalPlaceholders retrieval 模板中由厂商填充的占位符，不作为模板变量
+var retrievalPlaceholders = []string{"knowledge", "query"}
+
+// defaultRetrievalPrompt 未指定 retrieval_template_id 时 retrieval 工具使用的 prompt_template
+const defaultRetrievalPrompt = "从文档 {{knowledge}} 中找到问题 {{query}} 的答案。根据文档内容中的语句找到答案，如果文档中没有答案则告诉用户找不到相关信息；"
+
+// promptTemplateRequest 创建或修改模板的请求，修改时忽略 name 和 kind
+type promptTemplateRequest struct {
+	Name        string                  `json:"name"`
+	Kind        string                  `json:"kind"`
+	Description string                  `json:"description"`
+	Content     string                  `json:"content"`
+	Variables   []models.PromptVariable `json:"variables"`
+}
+
+// HandleListPromptTemplates 列出每个模板的最新版本，可按 kind 过滤
+func HandleListPromptTemplates(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templates, err := db.ListPromptTemplates(c.Query("kind"))
+		if err != nil {
+			logrus.Errorf("查询提示词模板失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, templates)
+	}
+}
+
+// HandleGetPromptTemplate 获取模板，默认返回最新版本，?version= 指定版本
+func HandleGetPromptTemplate(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version := 0
+		if raw := c.Query("version"); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "version 必须是正整数"})
+				return
+			}
+			version = v
+		}
+		tpl, err := db.GetPromptTemplateByName(c.Param("name"), version)
+		if err != nil {
+			logrus.Errorf("查询提示词模板失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		if tpl == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+			return
+		}
+		c.JSON(http.StatusOK, tpl)
+	}
+}
+
+// HandleListPromptTemplateVersions 列出模板的所有版本
+func HandleListPromptTemplateVersions(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templates, err := db.ListPromptTemplateVersions(c.Param("name"))
+		if err != nil {
+			logrus.Errorf("查询提示词模板版本失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		if len(templates) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+			return
+		}
+		c.JSON(http.StatusOK, templates)
+	}
+}
+
+// HandleCreatePromptTemplate 创建模板，生成版本 1
+func HandleCreatePromptTemplate(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userName, ok := middleware.GetUserName(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+			return
+		}
+		var req promptTemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求负载"})
+			return
+		}
+		tpl := models.PromptTemplate{
+			Name:        strings.TrimSpace(req.Name),
+			Kind:        req.Kind,
+			Description: req.Description,
+			Content:     req.Content,
+			Variables:   req.Variables,
+			Creator:     userName,
+		}
+		if err := validatePromptTemplate(&tpl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		existing, err := db.GetPromptTemplateByName(tpl.Name, 0)
+		if err != nil {
+			logrus.Errorf("查询提示词模板失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		if existing != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "模板已存在，请使用 PUT 创建新版本"})
+			return
+		}
+		saved, err := db.InsertPromptTemplate(tpl)
+		if err != nil {
+			logrus.Errorf("保存提示词模板失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusCreated, saved)
+	}
+}
+
+// HandleUpdatePromptTemplate 修改模板：保存为新版本，旧版本保留，已引用旧版本 ID 的请求不受影响
+func HandleUpdatePromptTemplate(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		latest, ok := getEditablePromptTemplate(c, db)
+		if !ok {
+			return
+		}
+		var req promptTemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求负载"})
+			return
+		}
+		userName, _ := middleware.GetUserName(c)
+		tpl := models.PromptTemplate{
+			Name:        latest.Name,
+			Kind:        latest.Kind,
+			Description: req.Description,
+			Content:     req.Content,
+			Variables:   req.Variables,
+			Creator:     userName,
+		}
+		if err := validatePromptTemplate(&tpl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		saved, err := db.InsertPromptTemplate(tpl)
+		if err != nil {
+			logrus.Errorf("保存提示词模板失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, saved)
+	}
+}
+
+// HandleDeletePromptTemplate 删除模板的所有版本
+func HandleDeletePromptTemplate(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tpl, ok := getEditablePromptTemplate(c, db)
+		if !ok {
+			return
+		}
+		if err := db.DeletePromptTemplate(tpl.Name); err != nil {
+			logrus.Errorf("删除提示词模板失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "模板已删除"})
+	}
+}
+
+// getEditablePromptTemplate 获取路径中模板的最新版本，并确认当前用户是最新版本的作者或管理员。
+// 失败时已写入响应，返回 false
+func getEditablePromptTemplate(c *gin.Context, db *dbop.Database) (*models.PromptTemplate, bool) {
+	userName, ok := middleware.GetUserName(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return nil, false
+	}
+	tpl, err := db.GetPromptTemplateByName(c.Param("name"), 0)
+	if err != nil {
+		logrus.Errorf("查询提示词模板失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+		return nil, false
+	}
+	if tpl == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return nil, false
+	}
+	if tpl.Creator != userName && !middleware.IsAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有模板作者或管理员可以修改模板"})
+		return nil, false
+	}
+	return tpl, true
+}
+
+// validatePromptTemplate 校验模板：名称和类型合法，变量不重复，正文中的变量都已声明；
+// retrieval 模板必须包含 {{knowledge}} 和 {{query}}
+func validatePromptTemplate(tpl *models.PromptTemplate) error {
+	if !promptTemplateNamePattern.MatchString(tpl.Name) {
+		return fmt.Errorf("模板名称只能包含字母、数字、下划线和连字符，且不超过100个字符")
+	}
+	if tpl.Kind != models.PromptKindSystem && tpl.Kind != models.PromptKindRetrieval {
+		return fmt.Errorf("kind 只能是 system 或 retrieval")
+	}
+	if strings.TrimSpace(tpl.Content) == "" {
+		return fmt.Errorf("模板内容不能为空")
+	}
+	if tpl.Variables == nil {
+		tpl.Variables = []models.PromptVariable{}
+	}
+
+	declared := make(map[string]bool, len(tpl.Variables))
+	for _, variable := range tpl.Variables {
+		if !promptVariableNamePattern.MatchString(variable.Name) {
+			return fmt.Errorf("变量名 %q 不合法", variable.Name)
+		}
+		if declared[variable.Name] {
+			return fmt.Errorf("变量 %s 重复定义", variable.Name)
+		}
+		if tpl.Kind == models.PromptKindRetrieval && containsName(retrievalPlaceholders, variable.Name) {
+			return fmt.Errorf("%s 由厂商填充，不能作为变量", variable.Name)
+		}
+		declared[variable.Name] = true
+	}
+
+	used := make(map[string]bool)
+	for _, match := range promptVariablePattern.FindAllStringSubmatch(tpl.Content, -1) {
+		name := match[1]
+		used[name] = true
+		if tpl.Kind == models.PromptKindRetrieval && containsName(retrievalPlaceholders, name) {
+			continue
+		}
+		if !declared[name] {
+			return fmt.Errorf("变量 %s 未在 variables 中声明", name)
+		}
+	}
+	if tpl.Kind == models.PromptKindRetrieval {
+		for _, name := range retrievalPlaceholders {
+			if !used[name] {
+				return fmt.Errorf("retrieval 模板必须包含 {{%s}}", name)
+			}
+		}
+	}
+	return nil
+}
+
+// renderPromptTemplate 用变量替换模板中的 {{name}}：未提供的变量使用默认值，必填变量缺失时返回错误；
+// retrieval 模板中的 {{knowledge}}、{{query}} 原样保留
+func renderPromptTemplate(tpl *models.PromptTemplate, values map[string]string) (string, error) {
+	resolved := make(map[string]string, len(tpl.Variables))
+	var missing []string
+	for _, variable := range tpl.Variables {
+		if value, ok := values[variable.Name]; ok {
+			resolved[variable.Name] = value
+			continue
+		}
+		if variable.Required {
+			missing = append(missing, variable.Name)
+			continue
+		}
+		resolved[variable.Name] = variable.Default
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("模板 %s 缺少变量: %s", tpl.Name, strings.Join(missing, ", "))
+	}
+	return promptVariablePattern.ReplaceAllStringFunc(tpl.Content, func(match string) string {
+		name := promptVariablePattern.FindStringSubmatch(match)[1]
+		if value, ok := resolved[name]; ok {
+			return value
+		}
+		return match
+	}), nil
+}
+
+// applyPromptTemplates 按请求中的 template_id、retrieval_template_id 渲染系统提示和 retrieval 模板
+func applyPromptTemplates(db *dbop.Database, payload *models.RequestPayload) error {
+	if payload.TemplateID != 0 {
+		rendered, err := loadAndRenderPrompt(db, payload.TemplateID, models.PromptKindSystem, payload.Variables)
+		if err != nil {
+			return err
+		}
+		payload.SystemPrompt = rendered
+	}
+	if payload.RetrievalTemplateID != 0 {
+		rendered, err := loadAndRenderPrompt(db, payload.RetrievalTemplateID, models.PromptKindRetrieval, payload.Variables)
+		if err != nil {
+			return err
+		}
+		payload.RetrievalPrompt = rendered
+	}
+	return nil
+}
+
+// loadAndRenderPrompt 读取指定版本的模板，确认用途后渲染
+func loadAndRenderPrompt(db *dbop.Database, id int64, kind string, values map[string]string) (string, error) {
+	tpl, err := db.GetPromptTemplate(id)
+	if err != nil {
+		return "", newChatError(http.StatusInternalServerError, "数据库错误", err)
+	}
+	if tpl == nil {
+		return "", newChatError(http.StatusNotFound, fmt.Sprintf("模板 %d 不存在", id), nil)
+	}
+	if tpl.Kind != kind {
+		message := fmt.Sprintf("模板 %d 的类型是 %s，不能用作 %s 模板", id, tpl.Kind, kind)
+		return "", newChatError(http.StatusBadRequest, message, nil)
+	}
+	rendered, err := renderPromptTemplate(tpl, values)
+	if err != nil {
+		return "", newChatError(http.StatusBadRequest, err.Error(), err)
+	}
+	return rendered, nil
+}
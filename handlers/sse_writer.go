@@ -0,0 +1,260 @@
+// sse_writer.go
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 发往前端的 SSE 事件类型
+const (
+	sseEventModel      = "model"       // 实际应答的厂商和模型
+	sseEventDelta      = "delta"       // 内容增量，data 为 OpenAI/StepFun 风格的 chunk
+	sseEventToolCall   = "tool_call"   // 工具调用
+	sseEventToolResult = "tool_result" // 服务端执行函数调用后的结果
+	sseEventContext    = "context"     // 历史消息被压缩的说明
+	sseEventSources    = "sources"     // 知识库检索命中的出处
+	sseEventUsage      = "usage"       // token 用量
+	sseEventError      = "error"       // 流式过程中出现的错误
+	sseEventDone       = "done"        // 结束，data 固定为 [DONE]
+)
+
+// maxSSELineSize 读取上游事件流时允许的最大单行长度，bufio.Scanner 默认只有 64KB
+const maxSSELineSize = 4 * 1024 * 1024
+
+// sseWriter 向前端写入带类型的 SSE 事件，并定期发送心跳注释防止代理断开空闲连接。
+// 写入都经过互斥锁，心跳协程与转发可以并发
+type sseWriter struct {
+	c        *gin.Context
+	mu       sync.Mutex
+	started  bool
+	errored  bool
+	done     bool
+	stop     chan struct{}
+	stopped  chan struct{}
+	interval time.Duration
+}
+
+// newSSEWriter 创建 SSE 写入器，心跳间隔通过 SSE_HEARTBEAT_SECONDS 配置，默认 15 秒，0 表示关闭
+func newSSEWriter(c *gin.Context) *sseWriter {
+	interval := 15 * time.Second
+	if raw := os.Getenv("SSE_HEARTBEAT_SECONDS"); raw != "" {
+		if seconds, err := strconv.Atoi(raw); err == nil && seconds >= 0 {
+			interval = time.Duration(seconds) * time.Second
+		} else {
+			logrus.Warnf("SSE_HEARTBEAT_SECONDS 格式错误: %s", raw)
+		}
+	}
+	return &sseWriter{c: c, interval: interval}
+}
+
+// Start 写入响应头并启动心跳，headers 为额外的响应头
+func (w *sseWriter) Start(headers map[string]string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return
+	}
+	w.started = true
+	header := w.c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // 关闭 Nginx 的响应缓冲
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+	w.c.Status(http.StatusOK)
+	w.c.Writer.WriteHeaderNow()
+	w.c.Writer.Flush()
+
+	if w.interval > 0 {
+		w.stop = make(chan struct{})
+		w.stopped = make(chan struct{})
+		go w.heartbeat()
+	}
+}
+
+// Started 返回是否已经开始向前端写入事件
+func (w *sseWriter) Started() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.started
+}
+
+// heartbeat 定期写入注释行，前端解析时会忽略
+func (w *sseWriter) heartbeat() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			_, err := io.WriteString(w.c.Writer, ": ping\n\n")
+			if err == nil {
+				w.c.Writer.Flush()
+			}
+			w.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Event 写入一个事件。data 为字符串时原样写入，其余类型序列化为 JSON；多行内容拆分为多个 data 行
+func (w *sseWriter) Event(event string, data interface{}) error {
+	var payload string
+	switch v := data.(type) {
+	case string:
+		payload = v
+	case []byte:
+		payload = string(v)
+	default:
+		bytes, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("序列化 %s 事件失败: %w", event, err)
+		}
+		payload = string(bytes)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "event: %s\n", event)
+	for _, line := range strings.Split(payload, "\n") {
+		fmt.Fprintf(&sb, "data: %s\n", line)
+	}
+	sb.WriteString("\n")
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := io.WriteString(w.c.Writer, sb.String()); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	switch event {
+	case sseEventError:
+		w.errored = true
+	case sseEventDone:
+		w.done = true
+	}
+	return nil
+}
+
+// Error 写入错误事件，厂商已经转发过错误事件时不再重复写入
+func (w *sseWriter) Error(message string) error {
+	w.mu.Lock()
+	errored := w.errored
+	w.mu.Unlock()
+	if errored {
+		return nil
+	}
+	return w.Event(sseEventError, gin.H{"error": gin.H{"message": message}})
+}
+
+// Close 补发 done 事件（如果还没有发送）并停止心跳
+func (w *sseWriter) Close() {
+	if !w.Started() {
+		return
+	}
+	w.mu.Lock()
+	done := w.done
+	w.mu.Unlock()
+	if !done {
+		if err := w.Event(sseEventDone, "[DONE]"); err != nil {
+			logrus.Printf("写入 done 事件失败: %v", err)
+		}
+	}
+	if w.stop != nil {
+		close(w.stop)
+		<-w.stopped
+		w.stop = nil
+	}
+}
+
+// WriteDelta 按增量内容选择事件类型写入，携带用量时额外写入 usage 事件
+func (w *sseWriter) WriteDelta(delta *ChatDelta) error {
+	if delta.Frame != "" {
+		event := delta.Event
+		if event == "" {
+			event = sseEventDelta
+			if len(delta.ToolCalls) > 0 {
+				event = sseEventToolCall
+			}
+		}
+		if err := w.Event(event, delta.Frame); err != nil {
+			return err
+		}
+	}
+	if delta.Usage != nil {
+		return w.Event(sseEventUsage, delta.Usage)
+	}
+	return nil
+}
+
+// sseField 上游事件流中的一个事件
+type sseField struct {
+	Event string
+	Data  string
+}
+
+// readSSE 按 SSE 规范解析上游事件流：多个 data 行合并为一个事件，空行表示事件结束，注释行被忽略。
+// 个别上游省略了 data: 前缀，以 { 开头的行按单独的事件处理
+func readSSE(body io.Reader, handle func(sseField) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineSize)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		field := sseField{Event: event, Data: strings.Join(data, "\n")}
+		event, data = "", nil
+		return handle(field)
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// 注释或心跳
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(strings.TrimSpace(line), "{"):
+			// 没有前缀的 JSON 行单独成为一个事件
+			if err := dispatch(); err != nil {
+				return err
+			}
+			if err := handle(sseField{Data: strings.TrimSpace(line)}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// 上游在最后一个事件后没有空行时也要处理
+	return dispatch()
+}
@@ -2,16 +2,18 @@
 package handlers
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"openapi-cms/dbop"
-	"openapi-cms/middleware"
 	"openapi-cms/models"
 	"openapi-cms/tool"
+	"openapi-cms/tool/modelcatalog"
+	"openapi-cms/tool/tokenizer"
 	"os"
 	"strings"
 	"time"
@@ -20,318 +22,213 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// HandleChatMessagesStepFun 处理 StepFun 聊天消息的请求
-func HandleChatMessagesStepFun(db *dbop.Database) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var payload models.RequestPayload
-		if err := c.ShouldBindJSON(&payload); err != nil {
-			logrus.Printf("绑定 JSON 失败: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求负载"})
-			return
-		}
-
-		userName, ok := middleware.GetUserName(c)
-		if !ok {
-			logrus.Warn("未找到或无效的 userName")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
-			return
-		}
-		logrus.Printf("****userName: %s", userName)
-
-		// 打印收到的请求报文
-		reqBodyBytes, err := json.Marshal(payload)
-		if err != nil {
-			logrus.Printf("序列化请求负载失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误"})
-			return
-		}
-		logrus.Printf("StepFun 请求报文: %s", reqBodyBytes)
-
-		apiKey := os.Getenv("STEPFUN_API_KEY")
-		if apiKey == "" {
-			logrus.Println("未设置 STEPFUN_API_KEY")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器配置错误"})
-			return
-		}
-
-		// 构建系统消息
-		systemMessage := models.StepFunMessage{
-			Role:    "system",
-			Content: payload.SystemPrompt,
-		}
+// stepFunProvider 实现 StepFun 的聊天接口
+type stepFunProvider struct {
+	db        *dbop.Database
+	apiKey    string
+	model     string
+	usage     models.ChatUsage
+	request   models.StepFunRequestPayload
+	params    models.GenerationParams // 前端传入的生成参数
+	fallbacks []string                // 当前模型失败时依次尝试的候选模型
+
+	vendorTools   []models.StepFunTool // web_search、retrieval 等由 StepFun 执行的工具
+	functionTools []string             // 前端启用的服务端函数工具
+}
 
-		// 构建用户消息
-		userMessage := payload.UserPrompt
+// newStepFunProvider 创建 StepFun 聊天厂商实例
+func newStepFunProvider(db *dbop.Database) ChatProvider {
+	return &stepFunProvider{db: db}
+}
 
-		// 构建初始消息列表
-		messages := []models.StepFunMessage{systemMessage}
+// Name 返回厂商标识
+func (p *stepFunProvider) Name() string {
+	return "stepfun"
+}
 
-		// 如果有 conversation_history，则将其添加到消息列表中
-		if len(payload.ConversationHistory) > 0 {
-			messages = append(messages, payload.ConversationHistory...)
-		}
+// BuildRequest 组装消息、选择模型和工具，并构建发往 StepFun 的请求
+func (p *stepFunProvider) BuildRequest(c *gin.Context, payload *models.RequestPayload) (*http.Request, error) {
+	p.apiKey = os.Getenv("STEPFUN_API_KEY")
+	if p.apiKey == "" {
+		return nil, newChatError(http.StatusInternalServerError, "服务器配置错误", fmt.Errorf("未设置 STEPFUN_API_KEY"))
+	}
 
-		// 如果 file_type 为 "file" 且有 file_ids，则处理上传的文件并添加文件内容
-		if payload.FileType == "file" && len(payload.FileIDs) > 0 {
-			err = processUploadedFiles(db, &payload, apiKey, c)
-			if err != nil {
-				// processUploadedFiles 已经处理了响应
-				return
-			}
-
-			// 遍历 VectorFileIds，加载文件内容并添加到 messages 中
-			for _, vectorFileId := range payload.VectorFileIds {
-				fileContent := loadFileContent(c, vectorFileId, apiKey)
-				if fileContent != "" {
-					contentMessage := models.StepFunMessage{
-						Role: "user",
-						Content: []models.StepFunMessageContent{
-							{
-								Type: "text",
-								Text: fileContent,
-							},
-						},
-					}
-					messages = append(messages, contentMessage)
-				}
-			}
+	// 如果 file_type 为 "file" 且有 file_ids，则处理上传的文件并加载文件内容
+	var fileContents []string
+	if payload.FileType == "file" && len(payload.FileIDs) > 0 {
+		contents, err := processUploadedFiles(c.Request.Context(), p.db, payload, p.apiKey)
+		if err != nil {
+			return nil, err
 		}
-
-		// 最后添加 userMessage
-		messages = append(messages, userMessage)
-
-		// 确认模型
-		model, err := getModelName(apiKey, messages, payload.FileType, payload.PerformanceLevel)
+		fileContents = append(fileContents, contents...)
+	}
+	if payload.FileType == "file" && len(payload.VectorFileIds) > 0 {
+		contents, err := loadFileContents(c.Request.Context(), p.db, payload.VectorFileIds, p.apiKey)
 		if err != nil {
-			logrus.Printf("获取模型名称失败: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+			return nil, err
 		}
+		fileContents = append(fileContents, contents...)
+	}
 
-		stepFunRequest := models.StepFunRequestPayload{
-			Model:      model,
-			Stream:     true,
-			Messages:   messages,
-			ToolChoice: "auto",
-			//ResponseFormat: models.ResponseFormat{Type: "text"}, // 默认值为 "text"
-		}
+	// 历史过长时用摘要代替较早的消息
+	fitConversationContext(c.Request.Context(), p.db, p.Name(), payload, fileContents, payload.UserPrompt)
+	// StepFun 的模型都支持 system 消息，摘要并入系统提示
+	systemPrompt, history := historyWithSummary(payload.SystemPrompt, payload.ConversationHistory, payload.HistorySummary, true)
+	messages := buildChatMessages(systemPrompt, history, fileContents, payload.UserPrompt)
 
-		// 构建工具列表
-		tools := []models.StepFunTool{}
+	// 确认模型
+	model, err := getModelName(c.Request.Context(), p.apiKey, messages, payload.FileType, payload.PerformanceLevel)
+	if err != nil {
+		return nil, newChatError(http.StatusBadRequest, err.Error(), err)
+	}
+	p.model = model
+	p.fallbacks = modelcatalog.Default().Fallbacks(p.Name(), model, payload.PerformanceLevel, payload.FileType, tokenizer.EstimateMessages(messages))
 
-		// 添加 web_search 工具
-		if payload.WebSearch {
-			webSearchTool := models.StepFunTool{
-				Type: "web_search",
-				Function: models.StepFunToolFunction{
-					Description: "这个工具 web_search 可以用来搜索互联网的信息",
-				},
-			}
-			tools = append(tools, webSearchTool)
-		}
+	// 按所选模型校验生成参数
+	p.params = payload.GenerationParams
+	spec, _ := modelcatalog.Default().Get(model)
+	params, err := generationParamsFor(spec, p.params, true)
+	if err != nil {
+		return nil, newChatError(http.StatusBadRequest, err.Error(), err)
+	}
+	p.functionTools = payload.FunctionTools
+	functions, err := functionToolsFor(spec, p.functionTools, true)
+	if err != nil {
+		return nil, newChatError(http.StatusBadRequest, err.Error(), err)
+	}
+	p.vendorTools = buildStepFunTools(payload)
+
+	p.request = models.StepFunRequestPayload{
+		Model:            model,
+		Stream:           true,
+		Messages:         messages,
+		ToolChoice:       "auto",
+		Tools:            append(p.vendorTools, functions...),
+		GenerationParams: params,
+	}
 
-		// 如果前端传递了 vector_store_id，则添加 retrieval 工具
-		if strings.TrimSpace(payload.VectorStoreID) != "" {
-			retrievalTool := models.StepFunTool{
-				Type: "retrieval",
-				Function: models.StepFunToolFunction{
-					Description: payload.Description,
-					Options: map[string]string{
-						"vector_store_id": payload.VectorStoreID,
-						"prompt_template": "从文档 {{knowledge}} 中找到问题 {{query}} 的答案。根据文档内容中的语句找到答案，如果文档中没有答案则告诉用户找不到相关信息；",
-					},
-				},
-			}
-			tools = append(tools, retrievalTool)
-		} else {
-			logrus.Println("未提供 vector_store_id，跳过 retrieval 工具")
-		}
+	return newJSONRequest("https://api.stepfun.com/v1/chat/completions", p.apiKey, p.request)
+}
 
-		// 将工具添加到请求中
-		stepFunRequest.Tools = tools
+// NextModel 切换到下一个候选模型，并用相同的消息重新构建请求
+func (p *stepFunProvider) NextModel() (*http.Request, bool) {
+	if len(p.fallbacks) == 0 {
+		return nil, false
+	}
+	p.model, p.fallbacks = p.fallbacks[0], p.fallbacks[1:]
+	p.request.Model = p.model
+	// 备用模型不支持的参数直接去掉
+	spec, _ := modelcatalog.Default().Get(p.model)
+	p.request.GenerationParams, _ = generationParamsFor(spec, p.params, false)
+	functions, _ := functionToolsFor(spec, p.functionTools, false)
+	p.request.Tools = append(p.vendorTools, functions...)
+	req, err := newJSONRequest("https://api.stepfun.com/v1/chat/completions", p.apiKey, p.request)
+	if err != nil {
+		logrus.Errorf("构建 %s 的请求失败: %v", p.model, err)
+		return nil, false
+	}
+	return req, true
+}
 
-		// 将请求体编码为 JSON
-		stepFunReqBodyBytes, err := json.Marshal(stepFunRequest)
-		if err != nil {
-			logrus.Printf("序列化 StepFun 请求负载失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误"})
-			return
-		}
+// FollowUp 在已发送的消息之后追加消息，用当前模型重新构建请求
+func (p *stepFunProvider) FollowUp(messages ...models.StepFunMessage) (*http.Request, error) {
+	p.request.Messages = append(p.request.Messages, messages...)
+	p.usage = models.ChatUsage{}
+	return newJSONRequest("https://api.stepfun.com/v1/chat/completions", p.apiKey, p.request)
+}
 
-		// 打印发送给 StepFun API 的请求报文
-		logrus.Printf("StepFun API 请求报文: %s", stepFunReqBodyBytes)
+// StreamDeltas 解析 StepFun 的 SSE 响应
+func (p *stepFunProvider) StreamDeltas(body io.Reader, emit func(*ChatDelta) error) error {
+	return streamOpenAICompatible(body, emit, &p.usage)
+}
 
-		// 创建向 StepFun API 发送的 HTTP 请求
-		stepFunReq, err := http.NewRequest("POST", "https://api.stepfun.com/v1/chat/completions", bytes.NewBuffer(stepFunReqBodyBytes))
-		if err != nil {
-			logrus.Printf("创建请求失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误"})
-			return
-		}
+// EstimatePromptTokens 本地估算请求消息的 token 数
+func (p *stepFunProvider) EstimatePromptTokens() int {
+	return tokenizer.EstimateMessages(p.request.Messages)
+}
 
-		// 设置请求头
-		stepFunReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-		stepFunReq.Header.Set("Content-Type", "application/json")
+// Usage 返回本次请求使用的模型和 token 用量
+func (p *stepFunProvider) Usage() (string, models.ChatUsage) {
+	return p.model, p.usage
+}
 
-		// 发送请求
-		client := &http.Client{}
-		resp, err := client.Do(stepFunReq)
-		if err != nil {
-			logrus.Printf("发送请求到 StepFun API 失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法与 StepFun API 通信"})
-			return
-		}
-		defer resp.Body.Close()
-
-		// 检查响应状态
-		if resp.StatusCode != http.StatusOK {
-			errorText := ""
-			if resp.Body != nil {
-				scanner := bufio.NewScanner(resp.Body)
-				for scanner.Scan() {
-					errorText += scanner.Text()
-				}
-			}
-			logrus.Printf("StepFun API 返回状态: %s, 信息: %s", resp.Status, errorText)
-			c.JSON(resp.StatusCode, gin.H{"error": "StepFun API 错误"})
-			return
-		}
+// buildStepFunTools 根据前端请求构建 web_search 和 retrieval 工具列表
+func buildStepFunTools(payload *models.RequestPayload) []models.StepFunTool {
+	tools := []models.StepFunTool{}
 
-		// 设置响应头以支持流式传输
-		c.Writer.Header().Set("Content-Type", "text/event-stream")
-		c.Writer.Header().Set("Cache-Control", "no-cache")
-		c.Writer.Header().Set("Connection", "keep-alive")
-
-		// 使用 bufio.Scanner 逐行读取响应体
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.TrimSpace(line) == "" {
-				continue // 跳过空行
-			}
-			// 打印返回的报文
-			logrus.Printf("StepFun 返回报文: %s", line)
-			// 发送数据到前端，确保 JSON 字符串未被转义
-			fmt.Fprintf(c.Writer, "%s\n\n", line)
-			// 刷新缓冲区
-			c.Writer.Flush()
+	// 添加 web_search 工具
+	if payload.WebSearch {
+		webSearchTool := models.StepFunTool{
+			Type: "web_search",
+			Function: models.StepFunToolFunction{
+				Description: "这个工具 web_search 可以用来搜索互联网的信息",
+			},
 		}
+		tools = append(tools, webSearchTool)
+	}
 
-		if err := scanner.Err(); err != nil {
-			logrus.Printf("读取 StepFun API 响应时出错: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取 StepFun 响应失败"})
-			return
+	// 如果前端传递了 vector_store_id，则添加 retrieval 工具
+	if strings.TrimSpace(payload.VectorStoreID) != "" {
+		promptTemplate := payload.RetrievalPrompt
+		if promptTemplate == "" {
+			promptTemplate = defaultRetrievalPrompt
+		}
+		retrievalTool := models.StepFunTool{
+			Type: "retrieval",
+			Function: models.StepFunToolFunction{
+				Description: payload.Description,
+				Options: map[string]string{
+					"vector_store_id": payload.VectorStoreID,
+					"prompt_template": promptTemplate,
+				},
+			},
 		}
-
-		c.Status(http.StatusOK)
+		tools = append(tools, retrievalTool)
+	} else {
+		logrus.Println("未提供 vector_store_id，跳过 retrieval 工具")
 	}
+	return tools
 }
 
-// getModelName 根据 FileType 和消息内容选择合适的模型
-func getModelName(apiKey string, messages []models.StepFunMessage, fileType, performanceLevel string) (string, error) {
-	if fileType == "img" {
-		// 尝试使用 step-1v-8k
-		tokenCount, err := countTokens(apiKey, messages, "step-1v-8k")
-		if err != nil {
-			return "", fmt.Errorf("计算 step-1v-8k 的 token 数量失败: %w", err)
-		}
-		if tokenCount <= 5000 {
-			return "step-1v-8k", nil
-		} else if tokenCount > 25000 {
-			return "", fmt.Errorf("token 数量 %d 超过了 step-1v-32k 的最大限制 25K", tokenCount)
-		}
+// borderlineMargin 估算值与上限的相对差距在此范围内时，才调用远程 token 计数做最终判断
+const borderlineMargin = 0.15
 
-		// 尝试使用 step-1v-32k
-		tokenCount, err = countTokens(apiKey, messages, "step-1v-32k")
-		if err != nil {
-			return "", fmt.Errorf("计算 step-1v-32k 的 token 数量失败: %w", err)
-		}
-		if tokenCount <= 25000 {
-			return "step-1v-32k", nil
-		}
-		return "", fmt.Errorf("token 数量 %d 超过了 step-1v-32k 的最大限制 25K", tokenCount)
-	}
-	if fileType == "video" {
-		return "step-1.5v-mini", nil
-	} else {
-		tokenCount, err := countTokens(apiKey, messages, "step-1-flash")
-		if err != nil {
-			return "", fmt.Errorf("failed to count tokens with step-1-flash: %w", err)
-		}
-		// 极速模式优先判断是否可以使用 step-1-flash
-		if performanceLevel == "fast" && tokenCount <= 10000 {
-			// 尝试使用 step-1-flash
-			return "step-1-flash", nil
+// tokenCountURL StepFun 的 Token 计数接口，测试中替换为本地服务
+var tokenCountURL = "https://api.stepfun.com/v1/token/count"
 
-		}
-		// 高级模式优先判断是否可以使用 step-2-16k
-		if performanceLevel == "advanced" && tokenCount <= 12000 {
-			tokenCount, err = countTokens(apiKey, messages, "step-2-16k")
-			if err != nil {
-				return "", fmt.Errorf("failed to count tokens with step-1v-32k: %w", err)
-			}
-			if tokenCount <= 12000 {
-				return "step-2-16k", nil
-			}
-		}
-		// 均衡模式优先判断是否可以使用 step-1-8k
-		if performanceLevel == "balanced" {
-			// 使用flash计算的tokenCount初步判断是否可以使用 step-1-8k
-			if tokenCount <= 6000 {
-				//确认基本符号后再精确计算tokens
-				tokenCount, err = countTokens(apiKey, messages, "step-1-8k")
-				if err != nil {
-					return "", fmt.Errorf("failed to count tokens with step-1-8k: %w", err)
-				}
-				//最终判断是否可用step-1-8k
-				if tokenCount <= 6000 {
-					return "step-1-8k", nil
-				}
-			}
-			// 使用flash计算的tokenCount初步判断是否可以使用 step-1-32k
-			if tokenCount <= 25000 {
-				//确认基本符号后再精确计算tokens
-				tokenCount, err = countTokens(apiKey, messages, "step-1-32k")
-				if err != nil {
-					return "", fmt.Errorf("failed to count tokens with step-1-32k: %w", err)
-				}
-				//最终判断是否可用step-1-32k
-				if tokenCount <= 25000 {
-					return "step-1-32k", nil
-				}
-			}
-			// 使用flash计算的tokenCount初步判断是否可以使用 step-1-128k
-			if tokenCount <= 100000 {
-				//确认基本符号后再精确计算tokens
-				tokenCount, err = countTokens(apiKey, messages, "step-1-128k")
-				if err != nil {
-					return "", fmt.Errorf("failed to count tokens with step-1-128k: %w", err)
-				}
-				//最终判断是否可用step-1-128k
-				if tokenCount <= 100000 {
-					return "step-1-128k", nil
-				}
-			}
-			// 使用flash计算的tokenCount初步判断是否可以使用 step-1-256k
-			if tokenCount <= 220000 {
-				//确认基本符号后再精确计算tokens
-				tokenCount, err = countTokens(apiKey, messages, "step-1-256k")
-				if err != nil {
-					return "", fmt.Errorf("failed to count tokens with step-1-256k: %w", err)
-				}
-				//最终判断是否可用step-1-256k
-				if tokenCount <= 220000 {
-					return "step-1-256k", nil
-				}
-			}
+// getModelName 根据模型目录、FileType 和性能档位选择合适的模型。先用本地估算做粗选，只有接近上限时才调用远程计数
+func getModelName(ctx context.Context, apiKey string, messages []models.StepFunMessage, fileType, performanceLevel string) (string, error) {
+	estimate := tokenizer.EstimateMessages(messages)
+	logrus.Printf("本地估算 token 数量: %d", estimate)
 
-		}
-		return "", fmt.Errorf("文本会话会话补全，token count %d  超过了设定的220K的上限", tokenCount)
+	spec, err := modelcatalog.Default().Select("stepfun", performanceLevel, fileType, func(spec models.ModelSpec) bool {
+		return fitsWithin(ctx, apiKey, messages, spec.Name, estimate, spec.MaxInputTokens)
+	})
+	if err != nil {
+		return "", fmt.Errorf("token 数量约 %d，%v", estimate, err)
+	}
+	return spec.Name, nil
+}
+
+// fitsWithin 判断消息能否放入 limit。估算值明显低于或高于上限时直接判断；
+// 接近上限时调用远程 token 计数确认，远程计数失败则按估算值判断
+func fitsWithin(ctx context.Context, apiKey string, messages []models.StepFunMessage, model string, estimate, limit int) bool {
+	if float64(estimate) < float64(limit)*(1-borderlineMargin) {
+		return true
+	}
+	if float64(estimate) > float64(limit)*(1+borderlineMargin) {
+		return false
+	}
+	tokenCount, err := countTokens(ctx, apiKey, messages, model)
+	if err != nil {
+		logrus.Warnf("计算 %s 的 token 数量失败，使用本地估算值 %d: %v", model, estimate, err)
+		return estimate <= limit
 	}
+	return tokenCount <= limit
 }
 
 // countTokens 通过调用 StepFun 的 Token 计数 API 计算消息的 Token 数量
-func countTokens(apiKey string, messages []models.StepFunMessage, model string) (int, error) {
+func countTokens(ctx context.Context, apiKey string, messages []models.StepFunMessage, model string) (int, error) {
 	requestPayload := models.TokenCountRequest{
 		Model:    model,
 		Messages: messages,
@@ -347,7 +244,7 @@ func countTokens(apiKey string, messages []models.StepFunMessage, model string)
 		"Content-Type":  "application/json",
 	}
 
-	resp, err := SendStepFunRequest("POST", "https://api.stepfun.com/v1/token/count", headers, bytes.NewBuffer(reqBodyBytes))
+	resp, err := SendStepFunRequestWithContext(ctx, "POST", tokenCountURL, headers, bytes.NewBuffer(reqBodyBytes))
 	if err != nil {
 		return 0, fmt.Errorf("发送 token 计数请求失败: %w", err)
 	}
@@ -368,112 +265,128 @@ func countTokens(apiKey string, messages []models.StepFunMessage, model string)
 	return tokenCountResp.Data.TotalTokens, nil
 }
 
-// processUploadedFiles 处理 FileType 为 "file" 且提供了 FileIDs 的文件上传逻辑。
-func processUploadedFiles(db *dbop.Database, payload *models.RequestPayload, apiKey string, c *gin.Context) error {
+// processUploadedFiles 处理 FileType 为 "file" 且提供了 FileIDs 的文件，按顺序返回每个文件的文本内容。
+// 文件在后台解析时先等待解析任务完成；内容优先从缓存读取，未缓存时在本地解析，本地无法解析的格式再交给 StepFun
+func processUploadedFiles(ctx context.Context, db *dbop.Database, payload *models.RequestPayload, apiKey string) ([]string, error) {
+	var contents []string
 	for _, fileID := range payload.FileIDs {
 		// 获取上传的文件记录
 		fileRecord, err := db.GetUploadedFileByID(fileID)
 		if err != nil {
-			logrus.Errorf("检索文件记录失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法检索文件信息"})
-			return err
+			return nil, newChatError(http.StatusInternalServerError, "无法检索文件信息", fmt.Errorf("检索文件记录失败: %w", err))
 		}
 		// 在调用外部接口的时候，判断本地文件是否存在，不存在的话，直接报错
 		if fileRecord == nil {
-			logrus.Printf("未找到 FileID 为 %s 的上传文件", fileID)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "上传的文件未找到"})
-			return fmt.Errorf("未找到 FileID 为 %s 的上传文件", fileID)
+			return nil, newChatError(http.StatusBadRequest, "上传的文件未找到", fmt.Errorf("未找到 FileID 为 %s 的上传文件", fileID))
 		}
-		//如果文件已存在，且已发送stepfun进行解析过，则直接取历史文件的解析记录，stepFileID田进来即可
-		if fileRecord.StepFileID != "" {
-			logrus.Printf("文件 ID %s 已存在于已解析过，直接取历史stepfun的记录即可", fileID)
-			// 将 VectorStoreID 添加到 payload 的 VectorFileIds 中
-			payload.VectorFileIds = append(payload.VectorFileIds, fileRecord.StepFileID)
-		}
-		//将文件根目录，文件路径拼接起来
-		filePath := fmt.Sprintf("%s/%s", os.Getenv("FILE_PATH"), fileRecord.FilePath)
-		// 上传文件到 StepFun 并进行提取
-		uploadResp, err := tool.UploadFileToStepFunWithExtract(filePath, fileRecord.Filename, "file-extract")
+		waitFileExtractJob(ctx, db, fileID)
+		content, err := uploadedFileContent(ctx, db, fileRecord, apiKey)
 		if err != nil {
-			logrus.Errorf("上传文件到 StepFun 失败: %v", err)
-			// 更新文件状态为 "failed"
-			//if updateErr := db.UpdateUploadedFileStatus(fileID, "failed"); updateErr != nil {
-			//	logrus.Errorf("更新文件状态为 'failed' 失败: %v", updateErr)
-			//}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "文件上传失败"})
-			return err
+			return nil, err
 		}
-
-		// 轮询文件状态
-		status, err := tool.PollFileStatus(uploadResp.ID, 15*time.Second)
-		if err != nil {
-			logrus.Errorf("查询文件解析状态失败: %v", err)
-			return err
+		if content != "" {
+			contents = append(contents, content)
 		}
-		logrus.Printf("文件解析完成，状态: %s", status)
+	}
+	return contents, nil
+}
 
-		//直到成功后，将插入文件信息到数据库
-		err = db.InsertFile(uploadResp.ID, "local", uploadResp.Bytes, fileID, status, "file-extract")
+// stepFunExtract 通过 StepFun 解析上传文件，返回解析后的内容和解析文件的 ID。reuse 为 true 时复用已有的解析文件
+func stepFunExtract(ctx context.Context, db *dbop.Database, fileRecord *models.UploadedFile, filePath, apiKey string, reuse bool) (string, string, error) {
+	if apiKey == "" {
+		return "", "", fmt.Errorf("未设置 STEPFUN_API_KEY")
+	}
+	stepFileID := ""
+	//如果文件已存在，且已发送stepfun进行解析过，则直接取历史文件的解析记录
+	if reuse && fileRecord.StepFileID != "" {
+		logrus.Printf("文件 ID %s 已存在于已解析过，直接取历史stepfun的记录即可", fileRecord.FileID)
+		stepFileID = fileRecord.StepFileID
+	} else {
+		var err error
+		stepFileID, err = extractUploadedFile(db, fileRecord, filePath)
 		if err != nil {
-			logrus.Errorf("插入文件记录到数据库失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "插入文件记录失败"})
-			return err
+			return "", "", err
 		}
+	}
+	content, err := loadFileContent(ctx, stepFileID, apiKey)
+	if err != nil {
+		return "", "", err
+	}
+	return content, stepFileID, nil
+}
 
-		// 更新文件状态为 "completed"
-		err = db.UpdateUploadedFileStatus(fileID, "completed")
-		if err != nil {
-			logrus.Errorf("更新文件状态为 'completed' 失败: %v", err)
-			// 不返回错误，因为主流程可能仍需继续
-		}
+// extractUploadedFile 上传文件到 StepFun 解析，等待解析完成后记录到 files 表，返回解析文件的 ID
+func extractUploadedFile(db *dbop.Database, fileRecord *models.UploadedFile, filePath string) (string, error) {
+	// 上传文件到 StepFun 并进行提取
+	uploadResp, err := tool.UploadFileToStepFunWithExtract(filePath, fileRecord.Filename, "file-extract")
+	if err != nil {
+		// 更新文件状态为 "failed"
+		//if updateErr := db.UpdateUploadedFileStatus(fileID, "failed"); updateErr != nil {
+		//	logrus.Errorf("更新文件状态为 'failed' 失败: %v", updateErr)
+		//}
+		return "", newChatError(http.StatusInternalServerError, "文件上传失败", fmt.Errorf("上传文件到 StepFun 失败: %w", err))
+	}
+
+	// 轮询文件状态
+	status, err := tool.PollFileStatus(uploadResp.ID, 15*time.Second)
+	if err != nil {
+		return "", newChatError(http.StatusInternalServerError, "文件解析失败", fmt.Errorf("查询文件解析状态失败: %w", err))
+	}
+	logrus.Printf("文件解析完成，状态: %s", status)
 
-		// 将 VectorStoreID 添加到 payload 的 VectorFileIds 中
-		payload.VectorFileIds = append(payload.VectorFileIds, uploadResp.ID)
+	//直到成功后，将插入文件信息到数据库
+	err = db.InsertFile(uploadResp.ID, "local", uploadResp.Bytes, fileRecord.FileID, status, "file-extract")
+	if err != nil {
+		return "", newChatError(http.StatusInternalServerError, "插入文件记录失败", fmt.Errorf("插入文件记录到数据库失败: %w", err))
+	}
+
+	// 更新文件状态为 "completed"
+	err = db.UpdateUploadedFileStatus(fileRecord.FileID, "completed")
+	if err != nil {
+		logrus.Errorf("更新文件状态为 'completed' 失败: %v", err)
+		// 不返回错误，因为主流程可能仍需继续
 	}
-	return nil
+	return uploadResp.ID, nil
 }
 
-// loadFileContent 加载文件内容
-func loadFileContent(c *gin.Context, vectorFileId, apiKey string) string {
+// loadFileContent 加载 StepFun 解析后的文件内容
+func loadFileContent(ctx context.Context, vectorFileId, apiKey string) (string, error) {
 	fileContentURL := fmt.Sprintf("https://api.stepfun.com/v1/files/%s/content", vectorFileId)
-	fmt.Println(fileContentURL)
-	req, err := http.NewRequest("GET", fileContentURL, nil)
+	logrus.Printf("加载文件内容: %s", fileContentURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", fileContentURL, nil)
 	if err != nil {
-		logrus.Printf("创建文件内容请求失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误"})
-		return ""
+		return "", newChatError(http.StatusInternalServerError, "服务器错误", fmt.Errorf("创建文件内容请求失败: %w", err))
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		logrus.Printf("发送文件内容请求失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送文件内容请求失败"})
-		return ""
+		return "", newChatError(http.StatusInternalServerError, "发送文件内容请求失败", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		logrus.Printf("检索文件内容失败，状态码: %d", resp.StatusCode)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取stepfun文件内容失败"})
-		return ""
+		return "", newChatError(http.StatusInternalServerError, "获取stepfun文件内容失败", fmt.Errorf("检索文件内容失败，状态码: %d", resp.StatusCode))
 	}
 
 	fileContentBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logrus.Printf("读取文件内容响应失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取文件内容失败"})
-		return ""
+		return "", newChatError(http.StatusInternalServerError, "读取文件内容失败", err)
 	}
 
-	return string(fileContentBytes)
+	return string(fileContentBytes), nil
 }
 
 // SendStepFunRequest 通用的 HTTP 请求发送函数
 func SendStepFunRequest(method, url string, headers map[string]string, body *bytes.Buffer) (*http.Response, error) {
+	return SendStepFunRequestWithContext(context.Background(), method, url, headers, body)
+}
+
+// SendStepFunRequestWithContext 与 SendStepFunRequest 相同，请求随 ctx 取消
+func SendStepFunRequestWithContext(ctx context.Context, method, url string, headers map[string]string, body *bytes.Buffer) (*http.Response, error) {
 	client := &http.Client{}
-	req, err := http.NewRequest(method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %w", err)
 	}
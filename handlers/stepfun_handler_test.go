@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"openapi-cms/models"
+	"openapi-cms/tool/tokenizer"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tokenCountServer 替换 tokenCountURL 的本地计数服务，按模型返回 counts 中的值，status 非 200 时返回错误
+type tokenCountServer struct {
+	mu     sync.Mutex
+	counts map[string]int
+	status int
+	calls  []string
+}
+
+func startTokenCountServer(t *testing.T, counts map[string]int, status int) *tokenCountServer {
+	t.Helper()
+	s := &tokenCountServer{counts: counts, status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.TokenCountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.calls = append(s.calls, req.Model)
+		s.mu.Unlock()
+		if s.status != http.StatusOK {
+			http.Error(w, "upstream error", s.status)
+			return
+		}
+		json.NewEncoder(w).Encode(models.TokenCountResponse{Data: models.TokenCountResponseData{TotalTokens: s.counts[req.Model]}})
+	}))
+	original := tokenCountURL
+	tokenCountURL = server.URL
+	t.Cleanup(func() {
+		tokenCountURL = original
+		server.Close()
+	})
+	return s
+}
+
+func (s *tokenCountServer) called() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func TestCountTokens(t *testing.T) {
+	messages := []models.StepFunMessage{{Role: "user", Content: "你好"}}
+	var got models.TokenCountRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer test-key" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		switch got.Model {
+		case "error":
+			http.Error(w, "upstream error", http.StatusInternalServerError)
+		case "bad-json":
+			w.Write([]byte("{"))
+		default:
+			w.Write([]byte(`{"data": {"total_tokens": 42}}`))
+		}
+	}))
+	defer server.Close()
+	original := tokenCountURL
+	tokenCountURL = server.URL
+	defer func() { tokenCountURL = original }()
+
+	count, err := countTokens(context.Background(), "test-key", messages, "step-1-8k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 42 {
+		t.Errorf("count = %d, want 42", count)
+	}
+	if got.Model != "step-1-8k" || len(got.Messages) != 1 || got.Messages[0].Content != "你好" {
+		t.Errorf("request = %+v", got)
+	}
+
+	for _, model := range []string{"error", "bad-json"} {
+		if _, err := countTokens(context.Background(), "test-key", messages, model); err == nil {
+			t.Errorf("%s: expected an error", model)
+		}
+	}
+}
+
+// tokenCountCase 用于比对本地估算和 StepFun 计数的消息
+type tokenCountCase struct {
+	Name     string                  `json:"name"`
+	Model    string                  `json:"model"`
+	Messages []models.StepFunMessage `json:"messages"`
+}
+
+// TestEstimateAgainstStepFunCount 本地估算与 StepFun /v1/token/count 的差距不超过 borderlineMargin，
+// 否则 fitsWithin 会在估算明显偏离时跳过远程计数而选错模型。需要访问 StepFun，设置 STEPFUN_API_KEY 时运行
+func TestEstimateAgainstStepFunCount(t *testing.T) {
+	apiKey := os.Getenv("STEPFUN_API_KEY")
+	if apiKey == "" {
+		t.Skip("设置 STEPFUN_API_KEY 后与 StepFun 的计数比对")
+	}
+	data, err := os.ReadFile("testdata/token_count_cases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []tokenCountCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			count, err := countTokens(context.Background(), apiKey, tc.Messages, tc.Model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			estimate := tokenizer.EstimateMessages(tc.Messages)
+			t.Logf("StepFun %d, estimate %d", count, estimate)
+			if math.Abs(float64(estimate-count)) > float64(count)*borderlineMargin {
+				t.Errorf("estimate %d is more than %.0f%% away from StepFun count %d", estimate, borderlineMargin*100, count)
+			}
+		})
+	}
+}
+
+func TestFitsWithin(t *testing.T) {
+	const limit = 1000
+	messages := []models.StepFunMessage{{Role: "user", Content: "hello"}}
+	tests := []struct {
+		name       string
+		estimate   int
+		count      int
+		status     int
+		want       bool
+		wantRemote bool
+	}{
+		{"clearly under", 849, 0, http.StatusOK, true, false},
+		{"clearly over", 1151, 0, http.StatusOK, false, false},
+		{"lower edge of margin", 850, 900, http.StatusOK, true, true},
+		{"upper edge of margin", 1150, 1000, http.StatusOK, true, true},
+		{"borderline, count fits", 990, 1000, http.StatusOK, true, true},
+		{"borderline, count over", 990, 1001, http.StatusOK, false, true},
+		{"estimate over, count fits", 1100, 950, http.StatusOK, true, true},
+		{"remote failure, estimate fits", 1000, 0, http.StatusInternalServerError, true, true},
+		{"remote failure, estimate over", 1001, 0, http.StatusInternalServerError, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startTokenCountServer(t, map[string]int{"step-1-8k": tt.count}, tt.status)
+			got := fitsWithin(context.Background(), "test-key", messages, "step-1-8k", tt.estimate, limit)
+			if got != tt.want {
+				t.Errorf("fitsWithin = %v, want %v", got, tt.want)
+			}
+			if remote := len(server.called()) > 0; remote != tt.wantRemote {
+				t.Errorf("remote count called = %v, want %v", remote, tt.wantRemote)
+			}
+		})
+	}
+}
+
+// TestGetModelNameBorderline 估算值接近 step-1-8k 的上限时，由远程计数决定是否换到更大的窗口
+func TestGetModelNameBorderline(t *testing.T) {
+	messages := []models.StepFunMessage{{Role: "user", Content: strings.Repeat("中文内容", 2000)}}
+	limit := 6000 // 内置目录中 step-1-8k 的 max_input_tokens
+	estimate := tokenizer.EstimateMessages(messages)
+	if float64(estimate) < float64(limit)*(1-borderlineMargin) || float64(estimate) > float64(limit)*(1+borderlineMargin) {
+		t.Fatalf("estimate %d is not borderline for %d, adjust the test message", estimate, limit)
+	}
+
+	tests := []struct {
+		name  string
+		count int
+		want  string
+	}{
+		{"count fits", limit, "step-1-8k"},
+		{"count over", limit + 1, "step-1-32k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startTokenCountServer(t, map[string]int{"step-1-8k": tt.count}, http.StatusOK)
+			got, err := getModelName(context.Background(), "test-key", messages, "", "balanced")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			// step-1-32k 远大于估算值，不应再请求远程计数
+			if calls := server.called(); len(calls) != 1 || calls[0] != "step-1-8k" {
+				t.Errorf("remote count calls = %v, want [step-1-8k]", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// structured_output.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"openapi-cms/models"
+	"openapi-cms/tool/jsonschema"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 结构化输出修复轮次的默认值和上限
+const (
+	defaultStructuredRepairs = 2
+	maxStructuredRepairs     = 5
+)
+
+// followUpSender 由支持在当前请求之后追加消息继续对话的厂商实现，结构化输出用它发起修复轮次
+type followUpSender interface {
+	// FollowUp 在已发送的消息之后追加消息，用当前模型重新构建请求
+	FollowUp(messages ...models.StepFunMessage) (*http.Request, error)
+}
+
+// structuredOutput 结构化输出：要求模型返回 JSON，按 Schema 校验，不通过时把错误发回模型修复
+type structuredOutput struct {
+	schema     map[string]interface{}
+	validator  *jsonschema.Validator
+	maxRepairs int
+}
+
+// newStructuredOutput 校验请求中的 structured_output，未开启时返回 nil。
+// 结构化输出需要拿到完整回复后才能校验，只支持 blocking 模式
+func newStructuredOutput(payload *models.RequestPayload, provider ChatProvider) (*structuredOutput, error) {
+	config := payload.StructuredOutput
+	if config == nil {
+		return nil, nil
+	}
+	if len(config.Schema) == 0 {
+		return nil, fmt.Errorf("structured_output.schema 不能为空")
+	}
+	if payload.ResponseFormat != nil {
+		return nil, fmt.Errorf("structured_output 不能与 response_format 同时使用")
+	}
+	if payload.ResponseMode == responseModeStreaming {
+		return nil, fmt.Errorf("structured_output 只支持 blocking 模式")
+	}
+	if _, ok := provider.(followUpSender); !ok {
+		return nil, fmt.Errorf("%s 不支持 structured_output", provider.Name())
+	}
+	maxRepairs := defaultStructuredRepairs
+	if config.MaxRepairs != nil {
+		if *config.MaxRepairs < 0 || *config.MaxRepairs > maxStructuredRepairs {
+			return nil, fmt.Errorf("structured_output.max_repairs 必须介于 0 和 %d 之间", maxStructuredRepairs)
+		}
+		maxRepairs = *config.MaxRepairs
+	}
+	validator, err := jsonschema.New(config.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("structured_output.schema 无效: %v", err)
+	}
+	return &structuredOutput{schema: config.Schema, validator: validator, maxRepairs: maxRepairs}, nil
+}
+
+// apply 将请求切换为结构化输出：汇总后返回 JSON，模型支持时开启 JSON Mode，并在系统提示中附上 Schema
+func (s *structuredOutput) apply(payload *models.RequestPayload) {
+	payload.ResponseMode = responseModeBlocking
+	payload.PreferJSONObject = true
+	schema, _ := json.Marshal(s.schema)
+	instruction := fmt.Sprintf("请只输出一个符合以下 JSON Schema 的 JSON，不要输出任何解释或 Markdown 代码块。\nJSON Schema:\n%s", schema)
+	if strings.TrimSpace(payload.SystemPrompt) == "" {
+		payload.SystemPrompt = instruction
+	} else {
+		payload.SystemPrompt = payload.SystemPrompt + "\n\n" + instruction
+	}
+}
+
+// validate 从回复中取出 JSON 并校验，返回解析后的对象和错误描述
+func (s *structuredOutput) validate(reply string) (interface{}, []string) {
+	object, errs := s.validator.ValidateJSON([]byte(extractJSON(reply)))
+	if len(errs) == 0 {
+		return object, nil
+	}
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	return nil, messages
+}
+
+// run 校验首轮回复，不通过时追加 助手回复 + 修复要求 再次请求当前模型，最多 maxRepairs 次。
+// 返回最后一轮的回复和所有轮次的用量合计，最终结果写入 writer
+func (s *structuredOutput) run(c *gin.Context, client *http.Client, policy retryPolicy, fallback *chatFallback, writer *blockingWriter, reply string, usage models.ChatUsage) (string, models.ChatUsage) {
+	provider := fallback.provider
+	for repairs := 0; ; repairs++ {
+		object, errs := s.validate(reply)
+		if len(errs) == 0 {
+			writer.finishStructured(reply, object, repairs, nil, usage)
+			return reply, usage
+		}
+		sender, ok := provider.(followUpSender)
+		if repairs >= s.maxRepairs || !ok {
+			logrus.Printf("结构化输出未通过校验，已修复 %d 次: %v", repairs, errs)
+			writer.finishStructured(reply, nil, repairs, errs, usage)
+			return reply, usage
+		}
+
+		logrus.Printf("结构化输出未通过校验，发起第 %d 次修复: %v", repairs+1, errs)
+		req, err := sender.FollowUp(
+			models.StepFunMessage{Role: "assistant", Content: reply},
+			models.StepFunMessage{Role: "user", Content: repairPrompt(errs)},
+		)
+		if err == nil {
+			var next string
+			next, err = s.sendRepair(c, client, policy, fallback, req, writer)
+			_, turnUsage := provider.Usage()
+			usage = addUsage(usage, turnUsage)
+			if err == nil {
+				reply = next
+				continue
+			}
+		}
+		// 修复请求失败时返回上一轮的回复和校验错误
+		logrus.Printf("结构化输出修复请求失败: %v", err)
+		if writeErr := writer.Error(fmt.Sprintf("结构化输出修复请求失败: %v", err)); writeErr != nil {
+			logrus.Printf("记录错误失败: %v", writeErr)
+		}
+		writer.finishStructured(reply, nil, repairs, errs, usage)
+		return reply, usage
+	}
+}
+
+// sendRepair 发送一次修复请求，返回模型的新回复
+func (s *structuredOutput) sendRepair(c *gin.Context, client *http.Client, policy retryPolicy, fallback *chatFallback, req *http.Request, writer *blockingWriter) (string, error) {
+	provider := fallback.provider
+	resp, err := sendWithRetry(c.Request.Context(), client, req, policy, provider.Name(), fallback.currentModel())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	writer.resetTurn()
+	var reply strings.Builder
+	err = provider.StreamDeltas(resp.Body, func(delta *ChatDelta) error {
+		reply.WriteString(delta.Content)
+		return writer.WriteDelta(delta)
+	})
+	return reply.String(), err
+}
+
+// repairPrompt 构建修复轮次的用户消息
+func repairPrompt(errs []string) string {
+	return fmt.Sprintf("上一次的输出不符合 JSON Schema，问题如下：\n- %s\n请修正后重新输出完整的 JSON，只输出 JSON。", strings.Join(errs, "\n- "))
+}
+
+// extractJSON 从回复中取出 JSON 文本：去掉 Markdown 代码块，截取第一个 { 或 [ 到最后一个 } 或 ]
+func extractJSON(reply string) string {
+	text := strings.TrimSpace(reply)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		if newline := strings.Index(text, "\n"); newline >= 0 {
+			text = text[newline+1:] // 去掉 ```json 这样的语言标记
+		}
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+	start := strings.IndexAny(text, "{[")
+	end := strings.LastIndexAny(text, "}]")
+	if start < 0 || end < start {
+		return strings.TrimSpace(text)
+	}
+	return text[start : end+1]
+}
+
+// addUsage 累加多轮请求的 token 用量
+func addUsage(total, turn models.ChatUsage) models.ChatUsage {
+	total.PromptTokens += turn.PromptTokens
+	total.CompletionTokens += turn.CompletionTokens
+	total.TotalTokens += turn.TotalTokens
+	return total
+}
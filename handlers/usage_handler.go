@@ -0,0 +1,123 @@
+// usage_handler.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"openapi-cms/dbop"
+	"openapi-cms/middleware"
+	"openapi-cms/models"
+	"openapi-cms/tool/modelcatalog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	modelPrices     map[string]models.ModelPrice
+	modelPricesOnce sync.Once
+)
+
+// getModelPrices 返回模型目录中的价格表（元/百万 token），并合并环境变量 MODEL_PRICES 的配置。
+// MODEL_PRICES 格式为 {"gpt-4o-mini":{"prompt":1.1,"completion":4.4}}，会覆盖同名模型的价格
+func getModelPrices() map[string]models.ModelPrice {
+	modelPricesOnce.Do(func() {
+		modelPrices = modelcatalog.Default().Prices()
+		raw := strings.TrimSpace(os.Getenv("MODEL_PRICES"))
+		if raw == "" {
+			return
+		}
+		var overrides map[string]models.ModelPrice
+		if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+			logrus.Errorf("解析 MODEL_PRICES 失败，使用默认价格表: %v", err)
+			return
+		}
+		for model, price := range overrides {
+			modelPrices[model] = price
+		}
+	})
+	return modelPrices
+}
+
+// calculateCost 按价格表计算一次调用的费用（元），未配置价格的模型费用为 0
+func calculateCost(model string, usage models.ChatUsage) float64 {
+	price, ok := getModelPrices()[model]
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1000000
+}
+
+// recordUsage 在聊天结束后写入用量台账，status 为 models.UsageStatus*，失败只记录日志
+func recordUsage(db *dbop.Database, userName, provider, model, conversationID, status string, usage models.ChatUsage) {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	record := models.UsageRecord{
+		Username:         userName,
+		Provider:         provider,
+		Model:            model,
+		ConversationID:   conversationID,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Cost:             calculateCost(model, usage),
+		Status:           status,
+	}
+	if err := db.InsertUsage(record); err != nil {
+		logrus.Errorf("写入用量台账失败: %v", err)
+	}
+}
+
+// HandleUsageReport 返回用量报表。参数：group_by（user、model、day 逗号分隔，默认全部）、start、end（YYYY-MM-DD）、username。
+// 非管理员只能查看自己的用量
+func HandleUsageReport(db *dbop.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userName, ok := middleware.GetUserName(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+			return
+		}
+
+		groupBy := []string{"user", "model", "day"}
+		if raw := strings.TrimSpace(c.Query("group_by")); raw != "" {
+			groupBy = nil
+			for _, dim := range strings.Split(raw, ",") {
+				dim = strings.TrimSpace(dim)
+				if dim != "user" && dim != "model" && dim != "day" {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "group_by 只能是 user、model、day 的组合"})
+					return
+				}
+				groupBy = append(groupBy, dim)
+			}
+		}
+
+		start, end := c.Query("start"), c.Query("end")
+		for _, day := range []string{start, end} {
+			if day == "" {
+				continue
+			}
+			if _, err := time.Parse("2006-01-02", day); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式应为 YYYY-MM-DD"})
+				return
+			}
+		}
+
+		username := c.Query("username")
+		if !middleware.IsAdmin(c) {
+			username = userName
+		}
+
+		report, err := db.GetUsageReport(groupBy, username, start, end)
+		if err != nil {
+			logrus.Errorf("查询用量报表失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"openapi-cms/middleware"
 	"openapi-cms/tool"
 	"openapi-cms/tool/filemanager"
+	"openapi-cms/tool/modelcatalog"
 	"os"
 	"strings"
 
@@ -47,6 +48,20 @@ func main() {
 		logrus.Fatalf("Failed to initialize FileManager: %v", err)
 	}
 
+	// 加载模型目录
+	if _, err := modelcatalog.Init(); err != nil {
+		logrus.Fatalf("Failed to load model catalog: %v", err)
+	}
+
+	// 启动后台任务的工作协程，执行文件上传、解析和向量化
+	tool.StartJobWorkers(db, tool.JobWorkerCount())
+
+	// 后台同步 StepFun 文件的向量化状态
+	tool.StartFileStatusSync(db, tool.FileStatusSyncInterval())
+
+	// 初始化按用户的限流器
+	limiter := middleware.NewRateLimiter(db)
+
 	// 初始化 Gin 路由器
 	router := gin.Default()
 
@@ -55,7 +70,7 @@ func main() {
 		AllowOrigins:     origins, // 根据需要修改
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "X-Conversation-Id", "X-Chat-Provider", "X-Chat-Model"},
 		AllowCredentials: true,
 	}))
 
@@ -67,7 +82,7 @@ func main() {
 	{
 		files := api.Group("/files")
 		{
-			files.POST("/upload", fileMgr.UploadFile)
+			files.POST("/upload", limiter.Upload(), fileMgr.UploadFile)
 			files.GET("/download/*filename", fileMgr.DownloadFile)
 			files.DELETE("/delete/*filename", fileMgr.DeleteFile)
 		}
@@ -79,20 +94,46 @@ func main() {
 		api.PUT("/update-vector-store/:name", func(c *gin.Context) {
 			handlers.HandleUpdateKnowledgeBase(c, db)
 		})
+		// 删除知识库，?delete_files=true 同时删除 StepFun 上的文件，?purge=true 同时删除上传文件
+		api.DELETE("/knowledge-bases/:name", func(c *gin.Context) {
+			handlers.HandleDeleteKnowledgeBase(c, db)
+		})
+		// 从知识库移除文件，?delete_file=true 同时删除 StepFun 上的文件
+		api.DELETE("/knowledge-bases/:name/files/:file_id", func(c *gin.Context) {
+			handlers.HandleRemoveKnowledgeBaseFile(c, db)
+		})
+		// 立即刷新知识库中文件的解析状态
+		api.POST("/knowledge-bases/:name/refresh-status", handlers.HandleRefreshKnowledgeBaseStatus(db))
 
 		// 聊天消息处理器
-		api.POST("/chat-messages/dify", handlers.HandleChatMessagesDify)
-		api.POST("/chat-messages/stepfun", handlers.HandleChatMessagesStepFun(db))
-		api.POST("/chat-messages/openai", handlers.HandleChatMessagesChatGpt(db))
+		api.POST("/chat-messages/:provider", limiter.Chat(), handlers.HandleChatMessages(db))
+		// 可用模型列表
+		api.GET("/models", handlers.HandleListModels())
+		// 会话管理
+		api.GET("/conversations", handlers.HandleListConversations(db))
+		api.GET("/conversations/:id", handlers.HandleGetConversation(db))
+		api.PUT("/conversations/:id", handlers.HandleRenameConversation(db))
+		api.DELETE("/conversations/:id", handlers.HandleDeleteConversation(db))
+		// 用量报表
+		api.GET("/usage", handlers.HandleUsageReport(db))
+		// 提示词模板
+		api.GET("/prompt-templates", handlers.HandleListPromptTemplates(db))
+		api.POST("/prompt-templates", handlers.HandleCreatePromptTemplate(db))
+		api.GET("/prompt-templates/:name", handlers.HandleGetPromptTemplate(db))
+		api.GET("/prompt-templates/:name/versions", handlers.HandleListPromptTemplateVersions(db))
+		api.PUT("/prompt-templates/:name", handlers.HandleUpdatePromptTemplate(db))
+		api.DELETE("/prompt-templates/:name", handlers.HandleDeletePromptTemplate(db))
 
 		// 获取数据，使用闭包传递 dbop
 		api.GET("/get-data", dbop.HandleGetData(db))
 		// 获取某个知识库下的文件信息
 		api.GET("/knowledge-bases/:id/files", dbop.GetFilesByKnowledgeBaseID(db))
 		// 上传文件
-		api.POST("/knowledge-uploads-file", func(c *gin.Context) {
+		api.POST("/knowledge-uploads-file", limiter.Upload(), func(c *gin.Context) {
 			tool.HandleUploadFile(c, db)
 		})
+		// 查询后台任务的进度
+		api.GET("/jobs/:id", handlers.HandleGetJob(db))
 		// 触发外部上传（使用各模型厂商知识库）
 		//api.POST("/trigger-external-upload", func(c *gin.Context) {
 		//	tool.HandleTriggerExternalUpload(c, db)
@@ -100,6 +141,15 @@ func main() {
 		// 新增验证并返回用户名的路由
 		api.GET("/validate-user", handlers.HandleValidateUser(db))
 
+		// 管理员接口：查看和调整用户的限流配额
+		admin := api.Group("/admin", middleware.AdminMiddleware())
+		{
+			admin.GET("/limits", handlers.HandleListUserLimits(db))
+			admin.GET("/limits/:username", handlers.HandleGetUserLimit(db))
+			admin.PUT("/limits/:username", handlers.HandleUpdateUserLimit(db, limiter))
+			admin.DELETE("/limits/:username", handlers.HandleResetUserLimit(db, limiter))
+		}
+
 	}
 
 	// 监听端口
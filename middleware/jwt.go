@@ -7,6 +7,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
 	"os"
+	"strings"
 )
 
 func JWTMiddleware() gin.HandlerFunc {
@@ -63,3 +64,17 @@ func GetUserName(c *gin.Context) (string, bool) {
 	}
 	return userNameStr, true
 }
+
+// IsAdmin 判断当前用户是否为管理员，管理员由环境变量 ADMIN_USERS（逗号分隔）配置
+func IsAdmin(c *gin.Context) bool {
+	userName, ok := GetUserName(c)
+	if !ok || userName == "" {
+		return false
+	}
+	for _, admin := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if strings.TrimSpace(admin) == userName {
+			return true
+		}
+	}
+	return false
+}
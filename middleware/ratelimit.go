@@ -0,0 +1,287 @@
+// middleware/ratelimit.go
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"openapi-cms/models"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// limitCacheTTL 用户配额在内存中的缓存时间，管理员修改后会立即失效
+const limitCacheTTL = time.Minute
+
+// bucketIdleTTL 令牌桶空闲多久后删除。令牌桶一分钟补满，空闲一分钟的桶与新建的桶相同
+const bucketIdleTTL = time.Minute
+
+// QuotaStore 定义限流中间件需要的数据访问，由 dbop.Database 实现
+type QuotaStore interface {
+	GetUserLimit(username string) (*models.UserLimit, error)
+	GetDailyTokenUsage(username string) (int64, error)
+	GetDailyUploadBytes(username string) (int64, error)
+	InsertUploadUsage(username, path string, bytes int64) error
+}
+
+// tokenBucket 令牌桶，容量为每分钟请求数，按秒匀速补充
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take 尝试取出一个令牌，失败时返回需要等待的时间
+func (b *tokenBucket) take(perMinute int, now time.Time) (bool, time.Duration) {
+	capacity := float64(perMinute)
+	rate := capacity / 60 // 每秒补充的令牌数
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+type cachedLimit struct {
+	limit    models.UserLimit
+	loadedAt time.Time
+}
+
+// RateLimiter 按用户名限制聊天和上传接口的请求频率及每日配额
+type RateLimiter struct {
+	store     QuotaStore
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	limits    map[string]cachedLimit
+	lastEvict time.Time // 上次清理空闲令牌桶和过期配额缓存的时间
+}
+
+// NewRateLimiter 创建限流器
+func NewRateLimiter(store QuotaStore) *RateLimiter {
+	return &RateLimiter{
+		store:   store,
+		buckets: make(map[string]*tokenBucket),
+		limits:  make(map[string]cachedLimit),
+	}
+}
+
+// DefaultUserLimit 返回环境变量配置的默认配额：RATE_LIMIT_RPM（默认 20）、DAILY_TOKEN_QUOTA、DAILY_UPLOAD_BYTES_QUOTA（默认 0 不限制）
+func DefaultUserLimit(username string) models.UserLimit {
+	return models.UserLimit{
+		Username:          username,
+		RequestsPerMinute: int(envInt("RATE_LIMIT_RPM", 20)),
+		DailyTokens:       envInt("DAILY_TOKEN_QUOTA", 0),
+		DailyUploadBytes:  envInt("DAILY_UPLOAD_BYTES_QUOTA", 0),
+		IsDefault:         true,
+	}
+}
+
+// Invalidate 清除用户配额缓存，管理员修改配额后调用。下次请求时重新读取配额，
+// 令牌桶保留当前的令牌数，按新的每分钟请求数补充，调小配额时超出容量的令牌被截去
+func (l *RateLimiter) Invalidate(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.limits, username)
+}
+
+// Chat 返回聊天接口的限流中间件：限制每分钟请求数和每日 token 配额
+func (l *RateLimiter) Chat() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userName, limit, ok := l.prepare(c, "chat")
+		if !ok {
+			return
+		}
+		if limit.DailyTokens > 0 {
+			used, err := l.store.GetDailyTokenUsage(userName)
+			if err != nil {
+				logrus.Errorf("查询当日 token 用量失败: %v", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+				return
+			}
+			if used >= limit.DailyTokens {
+				abortTooManyRequests(c, untilTomorrow(), fmt.Sprintf("今日 token 配额 %d 已用完", limit.DailyTokens))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// Upload 返回上传接口的限流中间件：限制每分钟请求数和每日上传字节配额。
+// 请求体最多读取剩余的配额，没有 Content-Length 的分块上传超出后读取失败；上传成功后记录实际读取的字节数
+func (l *RateLimiter) Upload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userName, limit, ok := l.prepare(c, "upload")
+		if !ok {
+			return
+		}
+		body := &countingBody{ReadCloser: c.Request.Body}
+		if limit.DailyUploadBytes > 0 {
+			used, err := l.store.GetDailyUploadBytes(userName)
+			if err != nil {
+				logrus.Errorf("查询当日上传量失败: %v", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+				return
+			}
+			// 已知大小的请求提前拒绝
+			remaining := limit.DailyUploadBytes - used
+			if remaining <= 0 || c.Request.ContentLength > remaining {
+				abortTooManyRequests(c, untilTomorrow(), fmt.Sprintf("今日上传配额 %d 字节已用完", limit.DailyUploadBytes))
+				return
+			}
+			body.ReadCloser = http.MaxBytesReader(c.Writer, c.Request.Body, remaining)
+		}
+		c.Request.Body = body
+		c.Next()
+
+		// 只记录上传成功的请求
+		if body.n > 0 && c.Writer.Status() < http.StatusMultipleChoices {
+			if err := l.store.InsertUploadUsage(userName, c.FullPath(), body.n); err != nil {
+				logrus.Errorf("记录上传量失败: %v", err)
+			}
+		}
+	}
+}
+
+// countingBody 统计已读取的请求体字节数
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// prepare 读取用户配额并检查每分钟请求数，失败时已中止请求
+func (l *RateLimiter) prepare(c *gin.Context, kind string) (string, models.UserLimit, bool) {
+	userName, ok := GetUserName(c)
+	if !ok || userName == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return "", models.UserLimit{}, false
+	}
+	limit, err := l.userLimit(userName)
+	if err != nil {
+		logrus.Errorf("查询用户配额失败: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
+		return "", models.UserLimit{}, false
+	}
+	if limit.RequestsPerMinute > 0 {
+		allowed, wait := l.take(userName+":"+kind, limit.RequestsPerMinute)
+		if !allowed {
+			abortTooManyRequests(c, wait, fmt.Sprintf("请求过于频繁，每分钟最多 %d 次", limit.RequestsPerMinute))
+			return "", models.UserLimit{}, false
+		}
+	}
+	return userName, limit, true
+}
+
+// userLimit 获取用户配额，优先使用缓存
+func (l *RateLimiter) userLimit(userName string) (models.UserLimit, error) {
+	l.mu.Lock()
+	cached, ok := l.limits[userName]
+	l.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < limitCacheTTL {
+		return cached.limit, nil
+	}
+
+	stored, err := l.store.GetUserLimit(userName)
+	if err != nil {
+		return models.UserLimit{}, err
+	}
+	limit := DefaultUserLimit(userName)
+	if stored != nil {
+		limit = *stored
+	}
+
+	l.mu.Lock()
+	l.limits[userName] = cachedLimit{limit: limit, loadedAt: time.Now()}
+	l.mu.Unlock()
+	return limit, nil
+}
+
+// take 从指定的令牌桶中取出一个令牌
+func (l *RateLimiter) take(key string, perMinute int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastEvict) >= bucketIdleTTL {
+		l.evictIdle(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(perMinute), last: now}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(perMinute, now)
+}
+
+// evictIdle 删除空闲的令牌桶和过期的配额缓存，避免不再访问的用户一直占用内存。调用方需持有 l.mu
+func (l *RateLimiter) evictIdle(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= bucketIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+	for userName, cached := range l.limits {
+		if now.Sub(cached.loadedAt) >= limitCacheTTL {
+			delete(l.limits, userName)
+		}
+	}
+	l.lastEvict = now
+}
+
+// abortTooManyRequests 返回 429 并携带重试时间
+func abortTooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// untilTomorrow 返回距离次日零点的时间，每日配额在零点重置
+func untilTomorrow() time.Duration {
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	return tomorrow.Sub(now)
+}
+
+// envInt 读取整数类型的环境变量，未设置或格式错误时返回默认值
+func envInt(key string, defaultValue int64) int64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		logrus.Warnf("环境变量 %s 格式错误，使用默认值 %d", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// AdminMiddleware 只允许 ADMIN_USERS 中配置的管理员访问
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+			return
+		}
+		c.Next()
+	}
+}
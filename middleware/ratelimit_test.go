@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"openapi-cms/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestInvalidateKeepsTokens 修改配额后令牌桶不会被重新补满
+func TestInvalidateKeepsTokens(t *testing.T) {
+	l := NewRateLimiter(nil)
+	l.limits["alice"] = cachedLimit{limit: models.UserLimit{Username: "alice", RequestsPerMinute: 2}, loadedAt: time.Now()}
+	for i := 0; i < 2; i++ {
+		if allowed, _ := l.take("alice:chat", 2); !allowed {
+			t.Fatalf("request %d was rejected", i+1)
+		}
+	}
+
+	l.Invalidate("alice")
+	if _, ok := l.limits["alice"]; ok {
+		t.Error("cached limit was not cleared")
+	}
+	if allowed, _ := l.take("alice:chat", 2); allowed {
+		t.Error("invalidation refilled the bucket")
+	}
+	// 调大配额后按新的速率补充，而不是立即补满到新的容量
+	if allowed, wait := l.take("alice:chat", 60); allowed || wait <= 0 || wait > time.Second {
+		t.Errorf("after raising the limit: allowed %v, wait %v", allowed, wait)
+	}
+}
+
+func TestEvictIdle(t *testing.T) {
+	l := NewRateLimiter(nil)
+	now := time.Now()
+	l.buckets["idle:chat"] = &tokenBucket{tokens: 1, last: now.Add(-bucketIdleTTL)}
+	l.buckets["active:chat"] = &tokenBucket{tokens: 1, last: now.Add(-time.Second)}
+	l.limits["idle"] = cachedLimit{loadedAt: now.Add(-limitCacheTTL)}
+	l.limits["active"] = cachedLimit{loadedAt: now.Add(-time.Second)}
+
+	l.evictIdle(now)
+	if _, ok := l.buckets["idle:chat"]; ok {
+		t.Error("idle bucket was not evicted")
+	}
+	if _, ok := l.buckets["active:chat"]; !ok {
+		t.Error("active bucket was evicted")
+	}
+	if _, ok := l.limits["idle"]; ok {
+		t.Error("expired limit was not evicted")
+	}
+	if _, ok := l.limits["active"]; !ok {
+		t.Error("fresh limit was evicted")
+	}
+
+	// take 每隔 bucketIdleTTL 清理一次
+	l.buckets["idle:chat"] = &tokenBucket{tokens: 1, last: now.Add(-2 * bucketIdleTTL)}
+	l.lastEvict = now.Add(-bucketIdleTTL)
+	l.take("active:chat", 60)
+	if _, ok := l.buckets["idle:chat"]; ok {
+		t.Error("take did not evict the idle bucket")
+	}
+}
+
+// fakeQuotaStore 内存中的配额数据
+type fakeQuotaStore struct {
+	limit    models.UserLimit
+	uploaded int64
+	records  []int64
+}
+
+func (s *fakeQuotaStore) GetUserLimit(username string) (*models.UserLimit, error) {
+	return &s.limit, nil
+}
+
+func (s *fakeQuotaStore) GetDailyTokenUsage(username string) (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeQuotaStore) GetDailyUploadBytes(username string) (int64, error) {
+	return s.uploaded, nil
+}
+
+func (s *fakeQuotaStore) InsertUploadUsage(username, path string, bytes int64) error {
+	s.records = append(s.records, bytes)
+	s.uploaded += bytes
+	return nil
+}
+
+// TestUploadQuota 上传量按实际读取的请求体统计，没有 Content-Length 的请求超出剩余配额时读取失败
+func TestUploadQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &fakeQuotaStore{limit: models.UserLimit{Username: "alice", DailyUploadBytes: 100}}
+	router := gin.New()
+	router.POST("/upload", func(c *gin.Context) { c.Set("userName", "alice") }, NewRateLimiter(store).Upload(), func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	upload := func(size int, chunked bool) int {
+		var body io.Reader = strings.NewReader(strings.Repeat("x", size))
+		if chunked {
+			// 隐藏长度，请求以分块方式发送
+			body = io.MultiReader(body)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := upload(60, true); code != http.StatusOK {
+		t.Fatalf("first upload: status %d", code)
+	}
+	if len(store.records) != 1 || store.records[0] != 60 {
+		t.Fatalf("recorded %v, want [60]", store.records)
+	}
+	// 剩余 40 字节：分块上传超出时读取失败，不记录上传量
+	if code := upload(50, true); code != http.StatusBadRequest {
+		t.Errorf("chunked upload over quota: status %d", code)
+	}
+	if code := upload(50, false); code != http.StatusTooManyRequests {
+		t.Errorf("upload with Content-Length over quota: status %d", code)
+	}
+	if len(store.records) != 1 {
+		t.Errorf("failed uploads were recorded: %v", store.records)
+	}
+	if code := upload(40, true); code != http.StatusOK {
+		t.Errorf("upload within quota: status %d", code)
+	}
+	if code := upload(1, false); code != http.StatusTooManyRequests {
+		t.Errorf("upload after quota is used up: status %d", code)
+	}
+}
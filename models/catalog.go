@@ -0,0 +1,51 @@
+// models/catalog.go
+package models
+
+// ModelSpec 定义模型目录中的一个模型
+type ModelSpec struct {
+	Name              string   `json:"name" yaml:"name"`
+	DisplayName       string   `json:"display_name" yaml:"display_name"`
+	Provider          string   `json:"provider" yaml:"provider"`                               // 厂商：stepfun、openai
+	ContextWindow     int      `json:"context_window" yaml:"context_window"`                   // 上下文窗口大小
+	MaxInputTokens    int      `json:"max_input_tokens" yaml:"max_input_tokens"`               // 为回复预留空间后，允许的最大输入 token 数
+	Modalities        []string `json:"modalities" yaml:"modalities"`                           // 支持的输入类型：text、image、video
+	Tiers             []string `json:"tiers" yaml:"tiers"`                                     // 适用的性能档位：fast、balanced、advanced
+	SupportsStream    bool     `json:"supports_stream" yaml:"supports_stream"`                 // 是否支持流式返回
+	SupportsSystem    bool     `json:"supports_system" yaml:"supports_system"`                 // 是否支持 system 消息
+	MaxOutputTokens   int      `json:"max_output_tokens" yaml:"max_output_tokens"`             // max_tokens 的上限，0 表示不限制
+	MaxTokensField    string   `json:"max_tokens_field,omitempty" yaml:"max_tokens_field"`     // 最大输出 token 数的参数名，o1 系列为 max_completion_tokens
+	MaxN              int      `json:"max_n" yaml:"max_n"`                                     // n 的上限，0 表示只支持 1
+	ResponseFormats   []string `json:"response_formats" yaml:"response_formats"`               // 支持的 response_format：text、json_object、json_schema
+	UnsupportedParams []string `json:"unsupported_params,omitempty" yaml:"unsupported_params"` // 不支持的生成参数，例如 o1 系列不支持 temperature；tools 表示不支持函数工具
+	PromptPrice       float64  `json:"prompt_price" yaml:"prompt_price"`                       // 输入价格（元/百万 token）
+	CompletionPrice   float64  `json:"completion_price" yaml:"completion_price"`               // 输出价格（元/百万 token）
+}
+
+// SupportsModality 判断模型是否支持指定的输入类型
+func (m ModelSpec) SupportsModality(modality string) bool {
+	return containsString(m.Modalities, modality)
+}
+
+// SupportsResponseFormat 判断模型是否支持指定的 response_format，text 总是支持
+func (m ModelSpec) SupportsResponseFormat(format string) bool {
+	return format == "" || format == "text" || containsString(m.ResponseFormats, format)
+}
+
+// SupportsParam 判断模型是否支持指定的生成参数
+func (m ModelSpec) SupportsParam(param string) bool {
+	return !containsString(m.UnsupportedParams, param)
+}
+
+// InTier 判断模型是否适用于指定的性能档位
+func (m ModelSpec) InTier(tier string) bool {
+	return containsString(m.Tiers, tier)
+}
+
+func containsString(list []string, target string) bool {
+	for _, item := range list {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
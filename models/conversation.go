@@ -0,0 +1,53 @@
+// models/conversation.go
+package models
+
+import (
+	"time"
+)
+
+// Conversation 定义服务端保存的会话
+type Conversation struct {
+	ID                     string                `json:"id"`
+	Username               string                `json:"username"`
+	Title                  string                `json:"title"`
+	Provider               string                `json:"provider"`                 // 会话使用的厂商：stepfun、openai、dify
+	ProviderConversationID string                `json:"provider_conversation_id"` // 厂商侧的会话ID，如 Dify 的 conversation_id
+	CreatedAt              time.Time             `json:"created_at"`
+	UpdatedAt              time.Time             `json:"updated_at"`
+	Messages               []ConversationMessage `json:"messages,omitempty"`
+	Summary                *ConversationSummary  `json:"summary,omitempty"` // 历史过长时较早消息的滚动摘要
+}
+
+// ConversationSummary 定义会话较早消息的滚动摘要，ID 不大于 CoveredUntilID 的消息由摘要代替
+type ConversationSummary struct {
+	ConversationID string    `json:"conversation_id"`
+	Summary        string    `json:"summary"`
+	CoveredUntilID int64     `json:"covered_until_id"` // 摘要覆盖的最后一条消息的ID
+	Model          string    `json:"model"`            // 生成摘要的模型
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ContextReport 历史消息被压缩时告知前端压缩了哪些内容
+type ContextReport struct {
+	Object             string    `json:"object"`              // 固定为 chat.context
+	OriginalTokens     int       `json:"original_tokens"`     // 压缩前的估算输入 token 数
+	FinalTokens        int       `json:"final_tokens"`        // 压缩后的估算输入 token 数
+	Budget             int       `json:"budget"`              // 可用的输入 token 数
+	SummarizedMessages int       `json:"summarized_messages"` // 以摘要代替的历史消息条数
+	DroppedMessages    int       `json:"dropped_messages"`    // 摘要失败时直接丢弃的历史消息条数
+	KeptMessages       int       `json:"kept_messages"`       // 原样保留的最近消息条数
+	Summary            string    `json:"summary,omitempty"`
+	SummaryModel       string    `json:"summary_model,omitempty"`
+	SummaryUsage       ChatUsage `json:"-"` // 本次生成摘要消耗的用量，计入用量台账
+}
+
+// ConversationMessage 定义会话中的一条消息
+type ConversationMessage struct {
+	ID             int64        `json:"id"`
+	ConversationID string       `json:"conversation_id"`
+	Role           string       `json:"role"`    // "user" 或 "assistant"
+	Content        interface{}  `json:"content"` // 与 StepFunMessage.Content 一致，字符串或内容数组
+	Model          string       `json:"model,omitempty"`
+	Sources        []ChatSource `json:"sources,omitempty"` // 助手回复引用的知识库片段
+	CreatedAt      time.Time    `json:"created_at"`
+}
@@ -0,0 +1,41 @@
+// models/job.go
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 后台任务的状态
+const (
+	JobPending   = "pending"   // 等待执行，包括失败后等待重试
+	JobRunning   = "running"   // 执行中
+	JobSucceeded = "succeeded" // 执行成功
+	JobFailed    = "failed"    // 重试次数用完或遇到无法重试的错误
+)
+
+// 后台任务的类型
+const (
+	JobKnowledgeUpload = "knowledge_upload" // 文件加入知识库：上传 → 解析 → 绑定 → 查询向量化状态
+	JobFileExtract     = "file_extract"     // 聊天上传的文件预先解析，结果写入解析内容缓存
+)
+
+// Job 持久化的后台任务，HTTP 请求只负责创建任务，由进程内的工作协程执行
+type Job struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Status        string          `json:"status"`
+	Stage         string          `json:"stage"`    // 当前所处的步骤，如 upload、extract、bind、poll
+	Progress      int             `json:"progress"` // 0-100
+	FileID        string          `json:"file_id"`  // 上传文件ID
+	VectorStoreID string          `json:"vector_store_id,omitempty"`
+	Username      string          `json:"username"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	Result        json.RawMessage `json:"result,omitempty"`
+	Error         string          `json:"error,omitempty"` // 最近一次失败的原因
+	Attempts      int             `json:"attempts"`
+	MaxAttempts   int             `json:"max_attempts"`
+	RunAt         time.Time       `json:"run_at"` // 最早可以执行（重试）的时间
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
@@ -0,0 +1,27 @@
+// models/knowledge_chunk.go
+package models
+
+// ModelOwnerLocal 本地知识库：文件在本地解析、切片和向量化，检索由服务端完成
+const ModelOwnerLocal = "local"
+
+// KnowledgeChunk 本地知识库中文件的一个切片及其向量
+type KnowledgeChunk struct {
+	ID             int64     `json:"id"`
+	VectorStoreID  string    `json:"vector_store_id"`
+	FileID         string    `json:"file_id"`
+	FileName       string    `json:"file_name"`
+	ChunkIndex     int       `json:"chunk_index"`
+	Location       string    `json:"location,omitempty"` // 切片所在的页、工作表或幻灯片，如 "第 3 页"
+	Content        string    `json:"content"`
+	Embedding      []float32 `json:"-"`
+	EmbeddingModel string    `json:"-"` // 生成向量的模型，更换模型后需要重新索引
+}
+
+// ScoredChunk 检索结果。Score 为最终排序使用的分数：启用重排序时为重排序分数，否则为关键词和向量排名融合后的分数
+type ScoredChunk struct {
+	KnowledgeChunk
+	Score        float64  `json:"score"`
+	VectorScore  float64  `json:"vector_score"`           // 与查询向量的余弦相似度
+	KeywordScore float64  `json:"keyword_score"`          // BM25 关键词匹配分数
+	RerankScore  *float64 `json:"rerank_score,omitempty"` // 重排序分数，未启用重排序时为空
+}
@@ -3,6 +3,9 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
 )
 
 // DatabaseInterface 定义数据库操作接口
@@ -19,6 +22,11 @@ type DatabaseInterface interface {
 	UpdateUploadedFileStatus(fileID, status string) error
 	UpdateFilesStatus(fileID, status string) error
 	InsertFile(id, vectorStoreID string, usageBytes int, fileID, status, purpose string) error
+	ListKnowledgeBaseFiles(vectorStoreID string) ([]KnowledgeBaseFile, error)
+	DeleteKnowledgeBase(name string, purge bool, keepFileIDs []string) ([]*UploadedFile, error)
+	GetKnowledgeBaseFile(vectorStoreID, fileID string) (*KnowledgeBaseFile, error)
+	DeleteKnowledgeBaseFile(vectorStoreID, fileID string) error
+	KnowledgeBaseUsageBytes(vectorStoreID string) (int, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	Close() error
 	// 事务管理方法
@@ -35,24 +43,34 @@ type DatabaseInterface interface {
 
 // RequestPayload 定义了，选择stepfun时，接收自前端的请求结构
 type RequestPayload struct {
-	Inputs              interface{}      `json:"inputs,omitempty"`
-	PerformanceLevel    string           `json:"performance_level,omitempty"`
-	SystemPrompt        string           `json:"system_prompt,omitempty"`
-	UserPrompt          StepFunMessage   `json:"user_prompt,omitempty"`
-	Query               string           `json:"query,omitempty"`
-	ResponseMode        string           `json:"response_mode,omitempty"`
-	ConversationID      string           `json:"conversation_id,omitempty"`
-	User                string           `json:"user,omitempty"`
-	FileIDs             []string         `json:"file_ids,omitempty"`
-	FileType            string           `json:"file_type"`
-	Name                string           `json:"name"`
-	Description         string           `json:"description"`
-	Tags                string           `json:"tags"`            // 标签以逗号分隔的字符串
-	VectorStoreID       string           `json:"vector_store_id"` // 新增字段，用于传递 vector_store_id
-	ModelOwner          string           `json:"model_owner"`     // 新增字段
-	WebSearch           bool             `json:"web_search"`
-	VectorFileIds       []string         `json:"vector_file_ids,omitempty"`
-	ConversationHistory []StepFunMessage `json:"conversation_history,omitempty"` //用于接收来自前端的消息历史
+	Inputs              interface{}       `json:"inputs,omitempty"`
+	PerformanceLevel    string            `json:"performance_level,omitempty"`
+	SystemPrompt        string            `json:"system_prompt,omitempty"`
+	UserPrompt          StepFunMessage    `json:"user_prompt,omitempty"`
+	Query               string            `json:"query,omitempty"`
+	ResponseMode        string            `json:"response_mode,omitempty"`
+	ConversationID      string            `json:"conversation_id,omitempty"`
+	User                string            `json:"user,omitempty"`
+	FileIDs             []string          `json:"file_ids,omitempty"`
+	FileType            string            `json:"file_type"`
+	Name                string            `json:"name"`
+	Description         string            `json:"description"`
+	Tags                string            `json:"tags"`            // 标签以逗号分隔的字符串
+	VectorStoreID       string            `json:"vector_store_id"` // 新增字段，用于传递 vector_store_id
+	ModelOwner          string            `json:"model_owner"`     // 新增字段
+	WebSearch           bool              `json:"web_search"`
+	VectorFileIds       []string          `json:"vector_file_ids,omitempty"`
+	ConversationHistory []StepFunMessage  `json:"conversation_history,omitempty"` //用于接收来自前端的消息历史
+	GenerationParams                      // 生成参数，按所选模型校验后透传给厂商
+	StructuredOutput    *StructuredOutput `json:"structured_output,omitempty"`     // 结构化输出，回复需符合 JSON Schema
+	FunctionTools       []string          `json:"function_tools,omitempty"`        // 启用的服务端函数工具名称
+	TemplateID          int64             `json:"template_id,omitempty"`           // 系统提示模板（某个版本）的ID，由服务端渲染为 system_prompt
+	RetrievalTemplateID int64             `json:"retrieval_template_id,omitempty"` // retrieval 模板的ID，由服务端渲染为 retrieval 工具的 prompt_template
+	Variables           map[string]string `json:"variables,omitempty"`             // 渲染模板使用的变量
+	RetrievalPrompt     string            `json:"-"`                               // 渲染后的 retrieval prompt_template，为空时使用默认模板
+	ContextReport       *ContextReport    `json:"-"`                               // 历史消息被压缩时的说明，由厂商在构建请求时填写
+	HistoryMessageIDs   []int64           `json:"-"`                               // 历史从数据库加载时每条消息的ID，与 ConversationHistory 一一对应；由前端传入时为空
+	HistorySummary      string            `json:"-"`                               // 较早历史的摘要，构建请求时按模型能力并入系统提示或放在历史开头
 }
 
 // StepFunMessageContent 定义消息内容结构
@@ -76,14 +94,18 @@ type StepFunMessageVideoURL struct {
 
 // StepFunMessage 定义消息结构
 type StepFunMessage struct {
-	Role    string      `json:"role"`    // "system" "assistant"或 "user"
-	Content interface{} `json:"content"` // 对于 "system" 是字符串，对于 "user" 是 []StepFunMessageContent
+	Role       string         `json:"role"`                   // "system" "assistant" "user" 或 "tool"
+	Content    interface{}    `json:"content"`                // 对于 "system" 是字符串，对于 "user" 是 []StepFunMessageContent
+	ToolCalls  []ChatToolCall `json:"tool_calls,omitempty"`   // assistant 消息中模型发起的函数调用
+	ToolCallID string         `json:"tool_call_id,omitempty"` // tool 消息对应的函数调用ID
 }
 
 // StepFunToolFunction 定义工具的功能描述及可选参数
 type StepFunToolFunction struct {
+	Name           string            `json:"name,omitempty"` // function 类型工具的函数名
 	Description    string            `json:"description"`
-	Options        map[string]string `json:"options,omitempty"` // 可选项是map类型，存储工具的配置参数
+	Parameters     interface{}       `json:"parameters,omitempty"` // function 类型工具的参数 JSON Schema
+	Options        map[string]string `json:"options,omitempty"`    // 可选项是map类型，存储工具的配置参数
 	PromptTemplate string            `json:"prompt_template,omitempty"`
 }
 
@@ -95,23 +117,184 @@ type StepFunTool struct {
 
 // ResponseFormat 定义响应格式的结构
 type ResponseFormat struct {
-	Type string `json:"type"` // "text" 或 "json_object"
+	Type       string            `json:"type"`                  // "text"、"json_object" 或 "json_schema"
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"` // type 为 json_schema 时必填
 }
 
-// StepFunRequestPayload 定义发送到 StepFun API 的请求结构
+// StructuredOutput 定义结构化输出：服务端要求模型返回 JSON 并按 Schema 校验，不通过时自动发起修复轮次
+type StructuredOutput struct {
+	Schema     map[string]interface{} `json:"schema"`
+	MaxRepairs *int                   `json:"max_repairs,omitempty"` // 最多修复几次，默认 2，上限 5
+}
+
+// JSONSchemaFormat 定义 json_schema 响应格式
+type JSONSchemaFormat struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema"`
+	Strict      bool                   `json:"strict,omitempty"`
+}
+
+// StopSequences 定义停止词，前端可以传字符串或字符串数组；只有一个时按字符串发送（StepFun 只接受字符串）
+type StopSequences []string
+
+// UnmarshalJSON 同时支持 "stop": "xx" 和 "stop": ["xx", "yy"]
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if single == "" {
+			*s = nil
+		} else {
+			*s = StopSequences{single}
+		}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("stop 必须是字符串或字符串数组")
+	}
+	*s = list
+	return nil
+}
+
+// MarshalJSON 只有一个停止词时输出字符串
+func (s StopSequences) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+	return json.Marshal([]string(s))
+}
+
+// GenerationParams 定义生成参数，前端请求和发往厂商的请求共用。使用指针以区分未设置和 0
+type GenerationParams struct {
+	MaxTokens           *int            `json:"max_tokens,omitempty"`            // 生成的最大 token 数
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"` // o1 系列使用该字段代替 max_tokens，由服务端转换
+	Temperature         *float64        `json:"temperature,omitempty"`           // 采样温度，介于 0.0 和 2.0 之间，越高越随机
+	TopP                *float64        `json:"top_p,omitempty"`                 // 核心采样，介于 0.0 和 1.0 之间
+	N                   *int            `json:"n,omitempty"`                     // 每个输入生成的结果条数，默认 1
+	Stop                StopSequences   `json:"stop,omitempty"`                  // 遇到停止词时中断生成
+	FrequencyPenalty    *float64        `json:"frequency_penalty,omitempty"`     // 频率惩罚，值越高越不容易重复
+	ResponseFormat      *ResponseFormat `json:"response_format,omitempty"`       // 默认 {"type":"text"}，json_object 开启 JSON Mode
+	PreferJSONObject    bool            `json:"-"`                               // 结构化输出时由服务端设置，模型支持时使用 json_object
+}
+
+// StepFunRequestPayload 定义发送到 StepFun API 的请求结构，OpenAI 兼容接口共用
 type StepFunRequestPayload struct {
-	Model            string           `json:"model"`  // "step-1v-8k"
-	Stream           bool             `json:"stream"` // true
-	Messages         []StepFunMessage `json:"messages"`
-	Tools            []StepFunTool    `json:"tools,omitempty"` // 聊天需要生成的标记最大数量，默认值为INF（不作限制，由模型自动决定）。输入标记和生成标记的总数量受限于指定模型的最大上下文长度。
-	ToolChoice       string           `json:"tool_choice,omitempty"`
-	MaxTokens        int              `json:"max_tokens,omitempty"`        // 新增字段，用于存储最大token数
-	Temperature      float64          `json:"temperature,omitempty"`       //采样温度，介于0.0和2.0之间的数字。较高值（如0.8）会使生成更随机，较低值（如0.2）会使其生成结果更集中且确定。默认值为0.5
-	TopP             float64          `json:"top_p,omitempty"`             //核心采样，该值会使模型生成具有top_p概率质量的标记并输出到结果。默认值为0.9
-	N                int              `json:"n,omitempty"`                 //控制模型为每个输入消息生成的响应消息结果条数，默认值为1，最大不限，建议不超过5
-	Stop             string           `json:"stop,omitempty"`              //用于指导模型生成聊天响应过程中，是否遇到stop中的内容，进行生成中断，默认为空
-	FrequencyPenalty float64          `json:"frequency_penalty,omitempty"` //默认为0。介于0.0和1.0之间的数字。值较高会使模型生成某token时，根据其过往在生成文本中出现的频度，进行后续降频惩罚，从而降低模型重复生成相同内容的可能性
-	//ResponseFormat   ResponseFormat   `json:"response_format,omitempty"`   //用于指导模型输出特定格式的内容。默认为 {"type":"text"}，表示输出文本。设置为 { "type": "json_object" } 可以开启 JSON Mode，输出可解析的 JSON 结构。
+	Model      string           `json:"model"`  // "step-1v-8k"
+	Stream     bool             `json:"stream"` // true
+	Messages   []StepFunMessage `json:"messages"`
+	Tools      []StepFunTool    `json:"tools,omitempty"`
+	ToolChoice string           `json:"tool_choice,omitempty"`
+	GenerationParams
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"` // OpenAI 流式返回时需开启 include_usage 才会返回用量
+}
+
+// StreamOptions 定义流式返回的选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ChatUsage 定义一次聊天补全的 token 用量
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatDeltaMessage 定义流式返回中的增量消息（非流式返回时为完整消息）
+type ChatDeltaMessage struct {
+	Role             string         `json:"role,omitempty"`
+	Content          string         `json:"content"`
+	ReasoningContent string         `json:"reasoning_content,omitempty"` // 推理/思考过程，例如 Dify 的 agent_thought
+	ToolCalls        []ChatToolCall `json:"tool_calls,omitempty"`
+}
+
+// ChatToolCall 定义模型发起的一次工具调用，流式返回时 Function.Arguments 会分段出现
+type ChatToolCall struct {
+	Index    int                  `json:"index"`
+	ID       string               `json:"id,omitempty"`
+	Type     string               `json:"type,omitempty"`
+	Function ChatToolCallFunction `json:"function"`
+}
+
+// ChatToolCallFunction 定义工具调用的函数名和参数
+type ChatToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ChatChoice 定义聊天补全返回中的单个候选结果
+type ChatChoice struct {
+	Index        int               `json:"index"`
+	FinishReason string            `json:"finish_reason,omitempty"`
+	Delta        ChatDeltaMessage  `json:"delta"`
+	Message      *ChatDeltaMessage `json:"message,omitempty"` // 非流式返回时使用
+}
+
+// ChatCompletionChunk 定义 OpenAI/StepFun 风格的聊天补全报文，流式和非流式共用
+type ChatCompletionChunk struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object,omitempty"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   *ChatUsage   `json:"usage,omitempty"`
+	// ConversationID 会话ID，前端在后续对话中回传即可延续同一会话
+	ConversationID string `json:"conversation_id,omitempty"`
+}
+
+// ChatCompletionResult 定义 response_mode 为 blocking 时返回的统一结果
+type ChatCompletionResult struct {
+	ConversationID   string           `json:"conversation_id"`
+	Provider         string           `json:"provider"`
+	Model            string           `json:"model"`
+	Content          string           `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []ChatToolCall   `json:"tool_calls,omitempty"`
+	FinishReason     string           `json:"finish_reason"`
+	Usage            ChatUsage        `json:"usage"`
+	Fallback         bool             `json:"fallback"`
+	Error            string           `json:"error,omitempty"`             // 返回部分内容后出错时的错误信息
+	Object           interface{}      `json:"object,omitempty"`            // 结构化输出校验通过后的 JSON 对象
+	RepairTurns      int              `json:"repair_turns,omitempty"`      // 结构化输出发起的修复轮次
+	ValidationErrors []string         `json:"validation_errors,omitempty"` // 结构化输出最终仍未通过校验的原因
+	ToolResults      []ChatToolResult `json:"tool_results,omitempty"`      // 服务端执行的函数调用及结果
+	Context          *ContextReport   `json:"context,omitempty"`           // 历史消息被压缩时的说明
+	Sources          []ChatSource     `json:"sources,omitempty"`           // 知识库检索命中的出处
+}
+
+// ChatToolResult 服务端执行函数调用后发送，告知前端调用的工具和结果
+type ChatToolResult struct {
+	Object     string `json:"object"` // 固定为 chat.tool_result
+	ToolCallID string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+	Content    string `json:"content"`         // 发回给模型的结果，通常为 JSON
+	Error      string `json:"error,omitempty"` // 执行失败的原因
+}
+
+// ChatModelEvent 流式响应开始时发送，告知前端实际应答的厂商和模型
+type ChatModelEvent struct {
+	Object   string `json:"object"` // 固定为 chat.model
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Fallback bool   `json:"fallback"` // 是否因上游失败切换了模型或厂商
+}
+
+// ChatSource 回答引用的知识库片段
+type ChatSource struct {
+	FileID          string  `json:"file_id"` // 上传文件ID，对应不到上传记录时为厂商的文件ID
+	FileName        string  `json:"file_name"`
+	KnowledgeBaseID string  `json:"knowledge_base_id,omitempty"`
+	Location        string  `json:"location,omitempty"` // 片段所在的页、工作表或幻灯片
+	Snippet         string  `json:"snippet"`
+	Score           float64 `json:"score,omitempty"`
+}
+
+// ChatSourcesEvent 检索到新的出处时发送，Sources 为本次回答到目前为止的全部出处
+type ChatSourcesEvent struct {
+	Object  string       `json:"object"` // 固定为 chat.sources
+	Sources []ChatSource `json:"sources"`
 }
 
 // StepFunResponse 定义 StepFun API 的响应结构-创建知识库
@@ -135,6 +318,38 @@ type DifyResponse struct {
 	} `json:"usage"`
 }
 
+// DifyRequestPayload 定义发送到 Dify API 的请求结构
+type DifyRequestPayload struct {
+	Inputs         interface{} `json:"inputs"`
+	Query          string      `json:"query"`
+	ResponseMode   string      `json:"response_mode"`
+	ConversationID string      `json:"conversation_id,omitempty"`
+	User           string      `json:"user"`
+}
+
+// DifyStreamEvent 定义 Dify 流式返回中每个 data 行的结构，不同 event 使用不同字段
+type DifyStreamEvent struct {
+	Event          string `json:"event"` // message、agent_message、agent_thought、message_end、error、ping 等
+	TaskID         string `json:"task_id"`
+	MessageID      string `json:"message_id"`
+	ConversationID string `json:"conversation_id"`
+	Answer         string `json:"answer"`
+	CreatedAt      int64  `json:"created_at"`
+	// agent_thought 事件字段
+	Thought     string `json:"thought"`
+	Observation string `json:"observation"`
+	Tool        string `json:"tool"`
+	ToolInput   string `json:"tool_input"`
+	// message_end 事件字段
+	Metadata struct {
+		Usage ChatUsage `json:"usage"`
+	} `json:"metadata"`
+	// error 事件字段
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
 // TokenCountRequest represents the request payload for token counting
 type TokenCountRequest struct {
 	Model    string           `json:"model"`
@@ -159,10 +374,21 @@ type KnowledgeBase struct {
 	Description string `json:"description"`
 	Tags        string `json:"tags"`
 	CreatedAt   string `json:"created_at"`
-	ModelOwner  string `json:"model_owner"` // 归属模型：stepfun，zhipu, moonshot, baichuan
+	ModelOwner  string `json:"model_owner"` // 归属模型：stepfun，zhipu, moonshot, baichuan，local（本地检索）
 	CreatorID   string `json:"creator_id"`
 }
 
+// KnowledgeBaseFile 知识库中的文件及其向量化状态
+type KnowledgeBaseFile struct {
+	FileID        string `json:"file_id"`
+	FileName      string `json:"file_name"`
+	FileType      string `json:"file_type"`
+	VectorFileID  string `json:"vector_file_id"`
+	UsageBytes    int    `json:"usage_bytes"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"` // 向量化失败的原因
+}
+
 // UploadedFile 接收：前端请求，上传文件到后台
 type UploadedFile struct {
 	FileID      string `json:"file_id"`
@@ -181,15 +407,25 @@ type UploadedFile struct {
 	StepFileStatus  string `json:"step_file_status"`
 }
 
+// FileContent 上传文件解析后的文本缓存，按上传文件 ID 保存，文件内容的哈希变化后缓存失效
+type FileContent struct {
+	FileID      string    `json:"file_id"`      // uploaded_files 中的文件 ID
+	ContentHash string    `json:"content_hash"` // 解析时本地文件的 SHA-256
+	StepFileID  string    `json:"step_file_id"` // StepFun 解析文件的 ID
+	Content     string    `json:"content"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 // FileStatusResponse 请求： StepFun API ,获取：doc parser上传文件的响应，和获取文件状态响应
 type FileStatusResponse struct {
-	ID        string `json:"id"`
-	Object    string `json:"object"`
-	Bytes     int    `json:"bytes"`
-	CreatedAt int64  `json:"created_at"`
-	Filename  string `json:"filename"`
-	Purpose   string `json:"purpose"`
-	Status    string `json:"status"`
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Bytes         int    `json:"bytes"`
+	CreatedAt     int64  `json:"created_at"`
+	Filename      string `json:"filename"`
+	Purpose       string `json:"purpose"`
+	Status        string `json:"status"`
+	StatusDetails string `json:"status_details,omitempty"` // 解析失败时的原因
 }
 
 // TriggerUploadRequest 接收：前端请求，上传文件到stepfun.
@@ -207,3 +443,40 @@ type UploadResponse struct {
 	UsageBytes    int    `json:"usage_bytes"`
 	VectorStoreID string `json:"vector_store_id"`
 }
+
+// UsageRecord 定义一次聊天补全写入用量台账的记录
+type UsageRecord struct {
+	Username         string  `json:"username"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	ConversationID   string  `json:"conversation_id"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+	Status           string  `json:"status"`
+}
+
+// 用量台账记录的状态
+const (
+	UsageStatusCompleted = "completed" // 正常完成
+	UsageStatusAborted   = "aborted"   // 客户端断开或超时，token 数为本地估算
+)
+
+// UsageSummary 定义用量报表中按用户、模型、日期聚合后的一行
+type UsageSummary struct {
+	Username         string  `json:"username,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Day              string  `json:"day,omitempty"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// ModelPrice 定义模型每百万 token 的价格（元）
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
@@ -0,0 +1,33 @@
+// models/prompt_template.go
+package models
+
+import (
+	"time"
+)
+
+// 提示词模板的用途
+const (
+	PromptKindSystem    = "system"    // 渲染为系统提示
+	PromptKindRetrieval = "retrieval" // 渲染为 retrieval 工具的 prompt_template，{{knowledge}} 和 {{query}} 由厂商填充
+)
+
+// PromptTemplate 定义一个版本的提示词模板，同名模板每次修改生成新版本，ID 唯一标识某个版本
+type PromptTemplate struct {
+	ID          int64            `json:"id"`
+	Name        string           `json:"name"`
+	Version     int              `json:"version"`
+	Kind        string           `json:"kind"` // system 或 retrieval
+	Description string           `json:"description"`
+	Content     string           `json:"content"` // 模板正文，变量写作 {{name}}
+	Variables   []PromptVariable `json:"variables"`
+	Creator     string           `json:"creator"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// PromptVariable 定义模板中的变量
+type PromptVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Required    bool   `json:"required,omitempty"` // 为 true 时请求必须提供，不使用默认值
+}
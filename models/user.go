@@ -13,3 +13,13 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// UserLimit 定义用户的限流与配额设置，0 表示不限制
+type UserLimit struct {
+	Username          string    `json:"username"`
+	RequestsPerMinute int       `json:"requests_per_minute"` // 聊天、上传接口每分钟请求数
+	DailyTokens       int64     `json:"daily_tokens"`        // 每日聊天 token 配额
+	DailyUploadBytes  int64     `json:"daily_upload_bytes"`  // 每日上传字节配额
+	IsDefault         bool      `json:"is_default"`          // 未单独设置，使用默认配置
+	UpdatedAt         time.Time `json:"updated_at"`
+}
@@ -0,0 +1,147 @@
+// tool/extractor/extractor.go
+
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ErrUnsupported 文件格式没有本地解析器（如 doc、xls、ppt 等旧版二进制格式）
+	ErrUnsupported = errors.New("不支持本地解析的文件格式")
+	// ErrNoText 文件中没有可提取的文本（如扫描版 PDF）
+	ErrNoText = errors.New("文件中没有可提取的文本")
+)
+
+// extractors 按扩展名注册本地解析器，输入文件内容，输出未规范化的文本
+var extractors = map[string]func(data []byte) (string, error){
+	".txt":  extractPlainText,
+	".md":   extractPlainText,
+	".csv":  extractCSV,
+	".html": extractHTML,
+	".htm":  extractHTML,
+	".xml":  extractHTML,
+	".docx": extractDocx,
+	".xlsx": extractXlsx,
+	".pptx": extractPptx,
+	".pdf":  extractPDF,
+}
+
+// Supported 判断文件是否可以在本地解析
+func Supported(name string) bool {
+	_, ok := extractors[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// ExtractFile 读取本地文件并提取文本
+func ExtractFile(path string) (string, error) {
+	if !Supported(path) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, filepath.Ext(path))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取文件失败: %w", err)
+	}
+	return Extract(filepath.Base(path), data)
+}
+
+// Extract 按文件扩展名提取文本并规范化：统一换行、去掉控制字符和行尾空白、合并多余空行。
+// PDF 按页、xlsx 按工作表、pptx 按幻灯片插入标记，docx 在分页处插入页标记
+func Extract(name string, data []byte) (string, error) {
+	extract, ok := extractors[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, filepath.Ext(name))
+	}
+	text, err := extract(data)
+	if err != nil {
+		return "", fmt.Errorf("解析 %s 失败: %w", name, err)
+	}
+	text = normalize(text)
+	if strings.TrimSpace(stripMarkers(text)) == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
+
+// pageMarker 页标记，页码从 1 开始
+func pageMarker(page int) string {
+	return fmt.Sprintf("=== 第 %d 页 ===", page)
+}
+
+// sheetMarker 工作表标记
+func sheetMarker(name string) string {
+	return fmt.Sprintf("=== 工作表：%s ===", name)
+}
+
+// slideMarker 幻灯片标记，编号从 1 开始
+func slideMarker(slide int) string {
+	return fmt.Sprintf("=== 第 %d 张幻灯片 ===", slide)
+}
+
+var (
+	markerPattern     = regexp.MustCompile(`(?m)^=== .* ===$`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// stripMarkers 去掉标记行，用于判断是否提取到了正文
+func stripMarkers(text string) string {
+	return markerPattern.ReplaceAllString(text, "")
+}
+
+// Section 按标记切分出的一段文本
+type Section struct {
+	Location string // 标记中的位置，如 "第 3 页"、"工作表：Sheet1"；第一个标记之前的内容为空
+	Text     string
+}
+
+// Sections 按页、工作表、幻灯片标记把 Extract 的结果切分为多段，去掉空段
+func Sections(text string) []Section {
+	var sections []Section
+	add := func(location, body string) {
+		if body = strings.TrimSpace(body); body != "" {
+			sections = append(sections, Section{Location: location, Text: body})
+		}
+	}
+	location, start := "", 0
+	for _, loc := range markerPattern.FindAllStringIndex(text, -1) {
+		add(location, text[start:loc[0]])
+		marker := text[loc[0]:loc[1]]
+		location = strings.TrimSuffix(strings.TrimPrefix(marker, "=== "), " ===")
+		start = loc[1]
+	}
+	add(location, text[start:])
+	return sections
+}
+
+// normalize 统一换行和空白，去掉不可见的控制字符和非法的 UTF-8 字节
+func normalize(text string) string {
+	text = strings.ToValidUTF8(text, "")
+	text = strings.TrimPrefix(text, "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r == '\u00A0' || r == '\u3000':
+			return ' '
+		case unicode.IsControl(r) || r == '\uFEFF' || r == '\u200B':
+			return -1
+		}
+		return r
+	}, text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
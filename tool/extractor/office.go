@@ -0,0 +1,411 @@
+// tool/extractor/office.go
+
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxZipEntryBytes zip 包中单个文件解压后的最大字节数，防止压缩炸弹
+const maxZipEntryBytes = 64 << 20
+
+// openZip 打开 Office Open XML 文档（docx、xlsx、pptx 都是 zip 包）
+func openZip(data []byte) (*zip.Reader, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("不是有效的 Office 文档: %w", err)
+	}
+	return reader, nil
+}
+
+// readZipEntry 读取 zip 包中的文件，不存在时返回 nil
+func readZipEntry(reader *zip.Reader, name string) ([]byte, error) {
+	for _, f := range reader.File {
+		if f.Name == name {
+			return readZipFile(f)
+		}
+	}
+	return nil, nil
+}
+
+// readZipFile 读取 zip 包中的一个文件，解压后超过 maxZipEntryBytes 时返回错误
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxZipEntryBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxZipEntryBytes {
+		return nil, fmt.Errorf("%s 解压后超过 %d MB", f.Name, maxZipEntryBytes>>20)
+	}
+	return data, nil
+}
+
+// extractDocx 提取 word/document.xml 的段落和表格。文档保存过分页信息时按页插入标记，否则只在手动分页符处插入
+func extractDocx(data []byte) (string, error) {
+	reader, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	document, err := readZipEntry(reader, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+	if document == nil {
+		return "", fmt.Errorf("缺少 word/document.xml")
+	}
+	renderedBreaks := bytes.Contains(document, []byte("lastRenderedPageBreak"))
+
+	var sb strings.Builder
+	page := 1
+	sb.WriteString(pageMarker(page) + "\n")
+	newPage := func() {
+		page++
+		sb.WriteString("\n" + pageMarker(page) + "\n")
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	inText, cells := false, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteString("\t")
+			case "cr":
+				sb.WriteString("\n")
+			case "br":
+				if attr(t, "type") == "page" && !renderedBreaks {
+					newPage()
+				} else if attr(t, "type") != "page" {
+					sb.WriteString("\n")
+				}
+			case "lastRenderedPageBreak":
+				newPage()
+			case "tc":
+				cells++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				// 表格单元格中的段落之间用空格分隔，保持一行一个表格行
+				if cells > 0 {
+					sb.WriteString(" ")
+				} else {
+					sb.WriteString("\n")
+				}
+			case "tc":
+				cells--
+				sb.WriteString("\t")
+			case "tr":
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	if page == 1 {
+		// 没有分页信息时不输出页标记
+		return strings.TrimPrefix(sb.String(), pageMarker(1)+"\n"), nil
+	}
+	return sb.String(), nil
+}
+
+// xlsxRelationship workbook.xml.rels 中的一条关系
+type xlsxRelationship struct {
+	ID     string `xml:"Id,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+// extractXlsx 按工作表输出单元格内容，每个工作表前插入标记，同一行的单元格之间用制表符分隔
+func extractXlsx(data []byte) (string, error) {
+	reader, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+
+	workbookData, err := readZipEntry(reader, "xl/workbook.xml")
+	if err != nil {
+		return "", err
+	}
+	if workbookData == nil {
+		return "", fmt.Errorf("缺少 xl/workbook.xml")
+	}
+	var workbook struct {
+		Sheets []struct {
+			Name string     `xml:"name,attr"`
+			Attr []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(workbookData, &workbook); err != nil {
+		return "", fmt.Errorf("解析 workbook.xml 失败: %w", err)
+	}
+
+	relsData, err := readZipEntry(reader, "xl/_rels/workbook.xml.rels")
+	if err != nil {
+		return "", err
+	}
+	var rels struct {
+		Relationships []xlsxRelationship `xml:"Relationship"`
+	}
+	if relsData != nil {
+		if err := xml.Unmarshal(relsData, &rels); err != nil {
+			return "", fmt.Errorf("解析 workbook.xml.rels 失败: %w", err)
+		}
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		target := strings.TrimPrefix(rel.Target, "/")
+		if !strings.HasPrefix(target, "xl/") {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+	}
+
+	sharedStrings, err := readSharedStrings(reader)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for i, sheet := range workbook.Sheets {
+		target := ""
+		for _, a := range sheet.Attr {
+			if a.Name.Local == "id" {
+				target = targets[a.Value]
+			}
+		}
+		if target == "" {
+			// 缺少关系文件时按默认的命名规则查找
+			target = fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		}
+		sheetData, err := readZipEntry(reader, target)
+		if err != nil {
+			return "", err
+		}
+		if sheetData == nil {
+			continue
+		}
+		rows, err := readSheetRows(sheetData, sharedStrings)
+		if err != nil {
+			return "", fmt.Errorf("解析工作表 %s 失败: %w", sheet.Name, err)
+		}
+		sb.WriteString(sheetMarker(sheet.Name) + "\n")
+		for _, row := range rows {
+			sb.WriteString(strings.Join(row, "\t") + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// readSharedStrings 读取共享字符串表，富文本的多个片段拼接为一个字符串
+func readSharedStrings(reader *zip.Reader) ([]string, error) {
+	data, err := readZipEntry(reader, "xl/sharedStrings.xml")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var table struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("解析 sharedStrings.xml 失败: %w", err)
+	}
+	strs := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		strs[i] = text
+	}
+	return strs, nil
+}
+
+// readSheetRows 读取工作表的所有行，按单元格引用（如 C3）补齐中间的空单元格，去掉全空的行
+func readSheetRows(data []byte, sharedStrings []string) ([][]string, error) {
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline struct {
+					Text string `xml:"t"`
+					Runs []struct {
+						Text string `xml:"t"`
+					} `xml:"r"`
+				} `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(data, &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		cells := map[int]string{}
+		maxColumn, next := -1, 0
+		for _, cell := range row.Cells {
+			column := next
+			if cell.Ref != "" {
+				column = columnIndex(cell.Ref)
+			}
+			next = column + 1
+
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				if index, err := strconv.Atoi(cell.Value); err == nil && index >= 0 && index < len(sharedStrings) {
+					value = sharedStrings[index]
+				}
+			case "inlineStr":
+				value = cell.Inline.Text
+				for _, run := range cell.Inline.Runs {
+					value += run.Text
+				}
+			case "b":
+				if cell.Value == "1" {
+					value = "TRUE"
+				} else {
+					value = "FALSE"
+				}
+			}
+			value = strings.TrimSpace(strings.ReplaceAll(value, "\n", " "))
+			if value == "" {
+				continue
+			}
+			cells[column] = value
+			if column > maxColumn {
+				maxColumn = column
+			}
+		}
+		if maxColumn < 0 {
+			continue
+		}
+		values := make([]string, maxColumn+1)
+		for column, value := range cells {
+			values[column] = value
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
+
+// columnIndex 把单元格引用中的列字母转为从 0 开始的列号，如 A1 → 0、AB3 → 27
+func columnIndex(ref string) int {
+	index := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		index = index*26 + int(r-'A'+1)
+	}
+	return index - 1
+}
+
+// extractPptx 按幻灯片顺序输出文本，每张幻灯片前插入标记
+func extractPptx(data []byte) (string, error) {
+	reader, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	type slide struct {
+		number int
+		file   *zip.File
+	}
+	var slides []slide
+	for _, f := range reader.File {
+		name := strings.TrimPrefix(f.Name, "ppt/slides/slide")
+		if name == f.Name || !strings.HasSuffix(name, ".xml") {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(name, ".xml"))
+		if err != nil {
+			continue
+		}
+		slides = append(slides, slide{number: number, file: f})
+	}
+	sort.Slice(slides, func(i, j int) bool { return slides[i].number < slides[j].number })
+
+	var sb strings.Builder
+	for i, s := range slides {
+		content, err := readZipFile(s.file)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(slideMarker(i+1) + "\n")
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		inText := false
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "t" {
+					inText = true
+				} else if t.Name.Local == "br" {
+					sb.WriteString("\n")
+				}
+			case xml.EndElement:
+				if t.Name.Local == "t" {
+					inText = false
+				} else if t.Name.Local == "p" {
+					sb.WriteString("\n")
+				}
+			case xml.CharData:
+				if inText {
+					sb.Write(t)
+				}
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// attr 读取元素的属性值，忽略命名空间
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
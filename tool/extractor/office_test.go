@@ -0,0 +1,120 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// buildZip 按 name → 内容生成 zip 包
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+const wordNamespace = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+
+func TestExtractDocx(t *testing.T) {
+	document := `<w:document ` + wordNamespace + `><w:body>` +
+		`<w:p><w:r><w:t>第一段</w:t><w:tab/><w:t>续写</w:t></w:r></w:p>` +
+		`<w:tbl><w:tr>` +
+		`<w:tc><w:p><w:r><w:t>A1</w:t></w:r></w:p></w:tc>` +
+		`<w:tc><w:p><w:r><w:t>B1</w:t></w:r></w:p></w:tc>` +
+		`</w:tr></w:tbl>` +
+		`<w:p><w:r><w:br w:type="page"/><w:t>第二页</w:t></w:r></w:p>` +
+		`</w:body></w:document>`
+	text, err := Extract("a.docx", buildZip(t, map[string]string{"word/document.xml": document}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pageMarker(1) + "\n第一段\t续写\nA1 \tB1\n\n" + pageMarker(2) + "\n第二页"
+	if text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+
+	// 没有分页时不输出页标记
+	document = `<w:document ` + wordNamespace + `><w:body><w:p><w:r><w:t>只有一页</w:t></w:r></w:p></w:body></w:document>`
+	text, err = Extract("a.docx", buildZip(t, map[string]string{"word/document.xml": document}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "只有一页" {
+		t.Errorf("text = %q, want %q", text, "只有一页")
+	}
+
+	if _, err := Extract("a.docx", buildZip(t, map[string]string{"other.xml": "<a/>"})); err == nil {
+		t.Error("expected an error for a docx without word/document.xml")
+	}
+	if _, err := Extract("a.docx", []byte("not a zip")); err == nil {
+		t.Error("expected an error for a file that is not a zip")
+	}
+}
+
+func TestExtractXlsx(t *testing.T) {
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			`<sheet name="数据" sheetId="1" r:id="rId1"/><sheet name="空表" sheetId="2" r:id="rId2"/>` +
+			`</sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships>` +
+			`<Relationship Id="rId1" Target="worksheets/data.xml"/><Relationship Id="rId2" Target="/xl/worksheets/empty.xml"/>` +
+			`</Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>名称</t></si><si><r><t>富</t></r><r><t>文本</t></r></si></sst>`,
+		"xl/worksheets/data.xml": `<worksheet><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="inlineStr"><is><t>备注</t></is></c></row>` +
+			`<row r="2"><c r="A2"><v>42</v></c><c r="B2" t="b"><v>1</v></c><c r="C2" t="s"><v>1</v></c></row>` +
+			`<row r="3"><c r="A3" t="inlineStr"><is><t> </t></is></c></row>` +
+			`</sheetData></worksheet>`,
+		"xl/worksheets/empty.xml": `<worksheet><sheetData/></worksheet>`,
+	}
+	text, err := Extract("a.xlsx", buildZip(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sheetMarker("数据") + "\n名称\t\t备注\n42\tTRUE\t富文本\n\n" + sheetMarker("空表")
+	if text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	for ref, want := range map[string]int{"A1": 0, "Z9": 25, "AA1": 26, "AB3": 27} {
+		if got := columnIndex(ref); got != want {
+			t.Errorf("columnIndex(%s) = %d, want %d", ref, got, want)
+		}
+	}
+}
+
+// TestReadZipEntryLimit 解压后超过 maxZipEntryBytes 的文件返回错误，而不是全部读入内存
+func TestReadZipEntryLimit(t *testing.T) {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	f, err := w.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make([]byte, 1<<20)
+	for written := 0; written <= maxZipEntryBytes; written += len(block) {
+		f.Write(block)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Extract("bomb.docx", b.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "解压后超过") {
+		t.Errorf("err = %v, want a size limit error", err)
+	}
+}
@@ -0,0 +1,1031 @@
+// tool/extractor/pdf.go
+
+package extractor
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// 只实现提取文本需要的 PDF 子集：对象（包括对象流中的对象）、FlateDecode 压缩、页面树、
+// 内容流中的文本操作符、字体的 ToUnicode 映射和 Form XObject。扫描件、加密文档和没有 ToUnicode 的 CID 字体提取不到文本
+
+const (
+	maxPDFStreamBytes = 64 << 20 // 单个流解压后的最大字节数，防止压缩炸弹
+	maxPDFDepth       = 32       // 页面树、Form XObject 的最大嵌套深度
+)
+
+// PDF 对象的 Go 表示：数字为 float64，字典为 pdfDict，数组为 []interface{}
+type (
+	pdfName    string
+	pdfString  []byte
+	pdfKeyword string
+	pdfRef     int
+	pdfDict    map[string]interface{}
+)
+
+// pdfObject 间接对象，stream 为未解码的流数据
+type pdfObject struct {
+	value  interface{}
+	stream []byte
+}
+
+// pdfDoc 解析后的 PDF 文档
+type pdfDoc struct {
+	objects map[int]*pdfObject
+	fonts   map[int]*pdfFont
+}
+
+var pdfObjectPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// extractPDF 按页面顺序提取文本，每页前插入页标记
+func extractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF")) {
+		return "", fmt.Errorf("不是有效的 PDF 文件")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", fmt.Errorf("不支持加密的 PDF")
+	}
+	doc := &pdfDoc{objects: map[int]*pdfObject{}, fonts: map[int]*pdfFont{}}
+	doc.parseObjects(data)
+	doc.expandObjectStreams()
+
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return "", fmt.Errorf("没有找到页面")
+	}
+	var sb strings.Builder
+	for i, page := range pages {
+		sb.WriteString(pageMarker(i+1) + "\n")
+		w := newPDFTextWriter()
+		doc.runContent(w, doc.pageContent(page.dict), page.resources, 0)
+		sb.WriteString(w.String())
+		sb.WriteString("\n\n")
+	}
+	return sb.String(), nil
+}
+
+// parseObjects 顺序扫描文件中的间接对象，增量更新时后出现的对象覆盖先出现的
+func (d *pdfDoc) parseObjects(data []byte) {
+	pos := 0
+	for pos < len(data) {
+		loc := pdfObjectPattern.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			return
+		}
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		start := pos + loc[1]
+		lexer := &pdfLexer{data: data, pos: start}
+		obj := &pdfObject{value: lexer.value()}
+		lexer.skipSpace()
+		lexer.clamp()
+		if bytes.HasPrefix(data[lexer.pos:], []byte("stream")) {
+			obj.stream, lexer.pos = readPDFStream(data, lexer.pos+len("stream"), obj.value)
+		}
+		d.objects[num] = obj
+
+		end := bytes.Index(data[lexer.pos:], []byte("endobj"))
+		if end < 0 {
+			pos = lexer.pos
+		} else {
+			pos = lexer.pos + end + len("endobj")
+		}
+	}
+}
+
+// readPDFStream 读取 stream 关键字之后的流数据，优先使用直接给出的 /Length，否则查找 endstream
+func readPDFStream(data []byte, pos int, value interface{}) ([]byte, int) {
+	if pos > len(data) {
+		pos = len(data)
+	}
+	if pos < len(data) && data[pos] == '\r' {
+		pos++
+	}
+	if pos < len(data) && data[pos] == '\n' {
+		pos++
+	}
+	if dict, ok := value.(pdfDict); ok {
+		if length, ok := dict["Length"].(float64); ok {
+			// 先比较浮点数，避免过大的 /Length 转换为 int 时溢出
+			if length >= 0 && length <= float64(len(data)-pos) {
+				end := pos + int(length)
+				if bytes.HasPrefix(bytes.TrimLeft(data[end:], " \t\r\n"), []byte("endstream")) {
+					return data[pos:end], end
+				}
+			}
+		}
+	}
+	end := bytes.Index(data[pos:], []byte("endstream"))
+	if end < 0 {
+		return data[pos:], len(data)
+	}
+	return bytes.TrimRight(data[pos:pos+end], "\r\n"), pos + end
+}
+
+// expandObjectStreams 把对象流（/Type /ObjStm）中压缩保存的对象展开
+func (d *pdfDoc) expandObjectStreams() {
+	for _, obj := range d.objects {
+		dict, ok := obj.value.(pdfDict)
+		if !ok || dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		content, err := d.decodeStream(obj)
+		if err != nil {
+			continue
+		}
+		count, _ := dict["N"].(float64)
+		first, _ := dict["First"].(float64)
+		header := &pdfLexer{data: content}
+		for i := 0; i < int(count); i++ {
+			num, ok1 := header.value().(float64)
+			offset, ok2 := header.value().(float64)
+			if !ok1 || !ok2 {
+				break
+			}
+			at := int(first) + int(offset)
+			if at < 0 || at >= len(content) {
+				continue
+			}
+			if _, exists := d.objects[int(num)]; exists {
+				continue
+			}
+			d.objects[int(num)] = &pdfObject{value: (&pdfLexer{data: content, pos: at}).value()}
+		}
+	}
+}
+
+// resolve 解析间接引用，返回引用指向的对象的值
+func (d *pdfDoc) resolve(v interface{}) interface{} {
+	for i := 0; i < maxPDFDepth; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		obj := d.objects[int(ref)]
+		if obj == nil {
+			return nil
+		}
+		v = obj.value
+	}
+	return nil
+}
+
+// dict 解析引用后返回字典，不是字典时返回 nil
+func (d *pdfDoc) dict(v interface{}) pdfDict {
+	dict, _ := d.resolve(v).(pdfDict)
+	return dict
+}
+
+// decodeStream 按 /Filter 解码流数据，只支持 FlateDecode
+func (d *pdfDoc) decodeStream(obj *pdfObject) ([]byte, error) {
+	dict, _ := obj.value.(pdfDict)
+	var filters []interface{}
+	switch f := d.resolve(dict["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{f}
+	case []interface{}:
+		filters = f
+	}
+	data := obj.stream
+	for _, filter := range filters {
+		switch d.resolve(filter) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			decoded, err := inflate(data)
+			if err != nil {
+				return nil, err
+			}
+			data = decoded
+		default:
+			return nil, fmt.Errorf("不支持的流编码: %v", filter)
+		}
+	}
+	return data, nil
+}
+
+// inflate 解压 zlib 数据，数据被截断时返回已解压的部分
+func inflate(data []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		if len(data) < 2 {
+			return nil, err
+		}
+		// 部分生成器写出的数据缺少或写错了 zlib 头，按裸 deflate 再试一次
+		reader = flate.NewReader(bytes.NewReader(data[2:]))
+	}
+	defer reader.Close()
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxPDFStreamBytes))
+	if err != nil && len(decoded) == 0 {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// pdfPage 页面及其（含继承的）资源字典
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages 按页面树的顺序返回所有页面，找不到页面树时按对象编号返回所有 /Type /Page 对象
+func (d *pdfDoc) pages() []pdfPage {
+	var catalogs []int
+	for num, obj := range d.objects {
+		if dict, ok := obj.value.(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+			catalogs = append(catalogs, num)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(catalogs)))
+	for _, num := range catalogs {
+		var pages []pdfPage
+		d.walkPages(d.objects[num].value.(pdfDict)["Pages"], nil, &pages, 0)
+		if len(pages) > 0 {
+			return pages
+		}
+	}
+
+	var nums []int
+	for num, obj := range d.objects {
+		if dict, ok := obj.value.(pdfDict); ok && dict["Type"] == pdfName("Page") {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	pages := make([]pdfPage, 0, len(nums))
+	for _, num := range nums {
+		dict := d.objects[num].value.(pdfDict)
+		pages = append(pages, pdfPage{dict: dict, resources: d.dict(dict["Resources"])})
+	}
+	return pages
+}
+
+// walkPages 深度优先遍历页面树，子节点没有 /Resources 时继承父节点的
+func (d *pdfDoc) walkPages(node interface{}, resources pdfDict, pages *[]pdfPage, depth int) {
+	dict := d.dict(node)
+	if dict == nil || depth > maxPDFDepth {
+		return
+	}
+	if own := d.dict(dict["Resources"]); own != nil {
+		resources = own
+	}
+	kids, ok := d.resolve(dict["Kids"]).([]interface{})
+	if !ok || dict["Type"] == pdfName("Page") {
+		*pages = append(*pages, pdfPage{dict: dict, resources: resources})
+		return
+	}
+	for _, kid := range kids {
+		d.walkPages(kid, resources, pages, depth+1)
+	}
+}
+
+// pageContent 拼接页面的所有内容流
+func (d *pdfDoc) pageContent(page pdfDict) []byte {
+	var refs []interface{}
+	switch contents := page["Contents"].(type) {
+	case pdfRef:
+		if array, ok := d.resolve(contents).([]interface{}); ok {
+			refs = array
+		} else {
+			refs = []interface{}{contents}
+		}
+	case []interface{}:
+		refs = contents
+	}
+	var content []byte
+	for _, ref := range refs {
+		num, ok := ref.(pdfRef)
+		if !ok || d.objects[int(num)] == nil {
+			continue
+		}
+		data, err := d.decodeStream(d.objects[int(num)])
+		if err != nil {
+			continue
+		}
+		content = append(content, data...)
+		content = append(content, '\n')
+	}
+	return content
+}
+
+// runContent 解释内容流中的文本操作符，把文本写入 w。Do 引用的 Form XObject 递归解释
+func (d *pdfDoc) runContent(w *pdfTextWriter, content []byte, resources pdfDict, depth int) {
+	if depth > maxPDFDepth {
+		return
+	}
+	lexer := &pdfLexer{data: content}
+	var operands []interface{}
+	var font *pdfFont
+	for {
+		v, ok := lexer.next()
+		if !ok {
+			return
+		}
+		op, isOp := v.(pdfKeyword)
+		if !isOp {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "BI":
+			lexer.skipInlineImage()
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					font = d.font(resources, name)
+				}
+				size, _ := operands[1].(float64)
+				w.setFontSize(size)
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				w.show(font, operands[0])
+			}
+		case "'", "\"":
+			w.newline()
+			if len(operands) >= 1 {
+				w.show(font, operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				array, _ := operands[0].([]interface{})
+				for _, item := range array {
+					if kern, ok := item.(float64); ok {
+						w.kern(kern)
+						continue
+					}
+					w.show(font, item)
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				tx, _ := operands[0].(float64)
+				ty, _ := operands[1].(float64)
+				w.move(tx, ty)
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				a, _ := operands[0].(float64)
+				b, _ := operands[1].(float64)
+				e, _ := operands[4].(float64)
+				f, _ := operands[5].(float64)
+				w.setMatrix(a, b, e, f)
+			}
+		case "T*":
+			w.newline()
+		case "Do":
+			if len(operands) >= 1 {
+				if name, ok := operands[0].(pdfName); ok {
+					d.runForm(w, resources, name, depth)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// runForm 解释 Form XObject 中的文本
+func (d *pdfDoc) runForm(w *pdfTextWriter, resources pdfDict, name pdfName, depth int) {
+	xobjects := d.dict(resources["XObject"])
+	ref, ok := xobjects[string(name)].(pdfRef)
+	if !ok || d.objects[int(ref)] == nil {
+		return
+	}
+	obj := d.objects[int(ref)]
+	dict, _ := obj.value.(pdfDict)
+	if dict["Subtype"] != pdfName("Form") {
+		return
+	}
+	content, err := d.decodeStream(obj)
+	if err != nil {
+		return
+	}
+	formResources := d.dict(dict["Resources"])
+	if formResources == nil {
+		formResources = resources
+	}
+	d.runContent(w, content, formResources, depth+1)
+}
+
+// pdfFont 把字符串中的字符编码转换为 Unicode 需要的信息
+type pdfFont struct {
+	toUnicode map[string]string // ToUnicode 映射：编码字节 → 文本
+	lengths   []int             // 映射中出现过的编码长度，从长到短
+	composite bool              // Type0 字体，没有 ToUnicode 时无法转换
+	widths    map[int]float64   // 字符编码 → 字形宽度（千分之一字号）
+	dw        float64           // Type0 字体的默认宽度
+}
+
+// font 查找资源字典中的字体，同一个字体对象只解析一次
+func (d *pdfDoc) font(resources pdfDict, name pdfName) *pdfFont {
+	fonts := d.dict(resources["Font"])
+	ref := fonts[string(name)]
+	if num, ok := ref.(pdfRef); ok {
+		if font, cached := d.fonts[int(num)]; cached {
+			return font
+		}
+		font := d.loadFont(d.dict(ref))
+		d.fonts[int(num)] = font
+		return font
+	}
+	return d.loadFont(d.dict(ref))
+}
+
+// loadFont 读取字体的类型和 ToUnicode 映射
+func (d *pdfDoc) loadFont(dict pdfDict) *pdfFont {
+	font := &pdfFont{composite: dict["Subtype"] == pdfName("Type0")}
+	if ref, ok := dict["ToUnicode"].(pdfRef); ok && d.objects[int(ref)] != nil {
+		if data, err := d.decodeStream(d.objects[int(ref)]); err == nil {
+			font.toUnicode, font.lengths = parseCMap(data)
+		}
+	}
+	font.widths = map[int]float64{}
+	if !font.composite {
+		first, _ := d.resolve(dict["FirstChar"]).(float64)
+		widths, _ := d.resolve(dict["Widths"]).([]interface{})
+		for i, w := range widths {
+			if width, ok := d.resolve(w).(float64); ok {
+				font.widths[int(first)+i] = width
+			}
+		}
+		return font
+	}
+
+	// Type0 字体的宽度在 DescendantFonts 的 W 数组中：c [w1 w2 …] 或 c1 c2 w
+	descendants, _ := d.resolve(dict["DescendantFonts"]).([]interface{})
+	if len(descendants) == 0 {
+		return font
+	}
+	cidFont := d.dict(descendants[0])
+	font.dw = 1000
+	if dw, ok := d.resolve(cidFont["DW"]).(float64); ok {
+		font.dw = dw
+	}
+	w, _ := d.resolve(cidFont["W"]).([]interface{})
+	for i := 0; i+1 < len(w); {
+		start, ok := w[i].(float64)
+		if !ok {
+			break
+		}
+		if array, ok := d.resolve(w[i+1]).([]interface{}); ok {
+			for j, v := range array {
+				if width, ok := v.(float64); ok {
+					font.widths[int(start)+j] = width
+				}
+			}
+			i += 2
+			continue
+		}
+		end, ok1 := w[i+1].(float64)
+		if i+2 >= len(w) || !ok1 {
+			break
+		}
+		width, _ := w[i+2].(float64)
+		for c := int(start); c <= int(end) && c-int(start) <= 0xFFFF; c++ {
+			font.widths[c] = width
+		}
+		i += 3
+	}
+	return font
+}
+
+// advance 按字形宽度计算字符串的宽度（以字号为单位），字体没有宽度信息时返回 false
+func (f *pdfFont) advance(s []byte) (float64, bool) {
+	if f == nil || (len(f.widths) == 0 && f.dw == 0) {
+		return 0, false
+	}
+	total := 0.0
+	if f.composite {
+		// 常见的 Identity-H 编码每个字符两个字节
+		for i := 0; i+1 < len(s); i += 2 {
+			width, ok := f.widths[int(s[i])<<8|int(s[i+1])]
+			if !ok {
+				width = f.dw
+			}
+			total += width
+		}
+	} else {
+		for _, b := range s {
+			width, ok := f.widths[int(b)]
+			if !ok {
+				width = 500
+			}
+			total += width
+		}
+	}
+	return total / 1000, true
+}
+
+// decode 把字符串按字体编码转换为文本。没有 ToUnicode 的简单字体按 Latin-1 处理
+func (f *pdfFont) decode(s []byte) string {
+	if f == nil || len(f.toUnicode) == 0 {
+		if f != nil && f.composite {
+			return ""
+		}
+		runes := make([]rune, 0, len(s))
+		for _, b := range s {
+			runes = append(runes, rune(b))
+		}
+		return string(runes)
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		matched := false
+		for _, n := range f.lengths {
+			if i+n > len(s) {
+				continue
+			}
+			if text, ok := f.toUnicode[string(s[i:i+n])]; ok {
+				sb.WriteString(text)
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			i += f.lengths[len(f.lengths)-1]
+		}
+	}
+	return sb.String()
+}
+
+// parseCMap 解析 ToUnicode CMap 中的 bfchar 和 bfrange
+func parseCMap(data []byte) (map[string]string, []int) {
+	mapping := map[string]string{}
+	seen := map[int]bool{}
+	lexer := &pdfLexer{data: data}
+	var operands []interface{}
+	section := ""
+	for {
+		v, ok := lexer.next()
+		if !ok {
+			break
+		}
+		keyword, isKeyword := v.(pdfKeyword)
+		if !isKeyword {
+			operands = append(operands, v)
+			continue
+		}
+		switch keyword {
+		case "beginbfchar", "beginbfrange":
+			section = string(keyword)
+			operands = operands[:0]
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 && len(src) > 0 {
+					mapping[string(src)] = utf16Text(dst)
+					seen[len(src)] = true
+				}
+			}
+			section = ""
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) == 0 || len(lo) != len(hi) || len(lo) > 4 {
+					continue
+				}
+				seen[len(lo)] = true
+				start, end := bytesToInt(lo), bytesToInt(hi)
+				if end < start || end-start > 0xFFFF {
+					continue
+				}
+				for code := start; code <= end; code++ {
+					key := string(intToBytes(code, len(lo)))
+					switch dst := operands[i+2].(type) {
+					case pdfString:
+						// 目标编码的最后一个码元随源编码递增
+						text := append([]byte{}, dst...)
+						if len(text) >= 2 {
+							last := int(text[len(text)-2])<<8 | int(text[len(text)-1])
+							last += code - start
+							text[len(text)-2], text[len(text)-1] = byte(last>>8), byte(last)
+						}
+						mapping[key] = utf16Text(text)
+					case []interface{}:
+						if index := code - start; index < len(dst) {
+							if s, ok := dst[index].(pdfString); ok {
+								mapping[key] = utf16Text(s)
+							}
+						}
+					}
+				}
+			}
+			section = ""
+		}
+		if section == "" {
+			operands = operands[:0]
+		}
+	}
+	lengths := make([]int, 0, len(seen))
+	for n := range seen {
+		lengths = append(lengths, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(lengths)))
+	return mapping, lengths
+}
+
+// utf16Text 把 UTF-16BE 编码的字节转为文本
+func utf16Text(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func bytesToInt(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+func intToBytes(n, size int) []byte {
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(n)
+		n >>= 8
+	}
+	return b
+}
+
+// pdfTextWriter 按文本位置的变化插入换行和空格。字体没有宽度信息时按每个字符半个字号（中文一个字号）估算文本的宽度
+type pdfTextWriter struct {
+	sb           strings.Builder
+	fontSize     float64 // Tf 设置的字号
+	scale        float64 // Tm 的缩放
+	x, y, lineX  float64 // 当前位置和当前行的起点
+	hasPos       bool
+	pendingSpace bool
+	last         rune
+}
+
+func newPDFTextWriter() *pdfTextWriter {
+	return &pdfTextWriter{fontSize: 12, scale: 1}
+}
+
+// size 当前的实际字号
+func (w *pdfTextWriter) size() float64 {
+	return w.fontSize * w.scale
+}
+
+// setFontSize 处理 Tf
+func (w *pdfTextWriter) setFontSize(size float64) {
+	if size != 0 {
+		w.fontSize = math.Abs(size)
+	}
+}
+
+// show 输出一段字符串
+func (w *pdfTextWriter) show(font *pdfFont, v interface{}) {
+	s, ok := v.(pdfString)
+	if !ok {
+		return
+	}
+	runes := []rune(font.decode(s))
+	if len(runes) == 0 {
+		return
+	}
+	// 中文之间不插入空格
+	if w.pendingSpace && w.last != 0 && w.last != '\n' && w.last != ' ' && !isCJK(w.last) && !isCJK(runes[0]) && runes[0] != ' ' {
+		w.sb.WriteByte(' ')
+	}
+	w.pendingSpace = false
+	w.sb.WriteString(string(runes))
+	w.last = runes[len(runes)-1]
+	if width, ok := font.advance(s); ok {
+		w.x += width * w.size()
+		return
+	}
+	for _, r := range runes {
+		if isCJK(r) {
+			w.x += w.size()
+		} else {
+			w.x += w.size() / 2
+		}
+	}
+}
+
+// kern 处理 TJ 数组中的字距调整，较大的负字距通常表示单词间的空格
+func (w *pdfTextWriter) kern(amount float64) {
+	w.x -= amount / 1000 * w.size()
+	if amount < -200 {
+		w.space()
+	}
+}
+
+// space 标记下一段文本前可能需要空格
+func (w *pdfTextWriter) space() {
+	w.pendingSpace = true
+}
+
+// newline 换行，已在行首时不重复换行
+func (w *pdfTextWriter) newline() {
+	if w.last != 0 && w.last != '\n' {
+		w.sb.WriteByte('\n')
+		w.last = '\n'
+	}
+	w.pendingSpace = false
+	w.x = w.lineX
+}
+
+// move 处理相对移动（Td、TD）
+func (w *pdfTextWriter) move(tx, ty float64) {
+	w.moveTo(w.lineX+tx*w.scale, w.y+ty*w.scale)
+}
+
+// setMatrix 处理 Tm
+func (w *pdfTextWriter) setMatrix(a, b, e, f float64) {
+	if scale := math.Hypot(a, b); scale > 0 {
+		w.scale = scale
+	}
+	w.moveTo(e, f)
+}
+
+// moveTo 移动到新的行起点：纵坐标变化超过半个字号视为换行，同一行上与文本末尾有明显间隔时插入空格
+func (w *pdfTextWriter) moveTo(x, y float64) {
+	if w.hasPos {
+		if math.Abs(y-w.y) > w.size()/2 {
+			w.newline()
+		} else if x-w.x > w.size()/5 {
+			w.space()
+		}
+	}
+	w.x, w.y, w.lineX, w.hasPos = x, y, x, true
+}
+
+func (w *pdfTextWriter) String() string {
+	return w.sb.String()
+}
+
+// isCJK 判断字符是否属于中日韩文字或全角标点
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) || (r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// pdfLexer PDF 语法的词法分析，同时用于对象、内容流和 CMap
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+// isPDFSpace 判断是否为 PDF 空白字符
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+// isPDFDelimiter 判断是否为 PDF 分隔符
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// clamp 保证读取位置不超过数据末尾，之后可以安全地对 data[pos:] 切片
+func (l *pdfLexer) clamp() {
+	if l.pos > len(l.data) {
+		l.pos = len(l.data)
+	}
+}
+
+// skipSpace 跳过空白和注释
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPDFSpace(c) {
+			l.pos++
+		} else if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+// value 读取一个完整的值，读不到时返回 nil
+func (l *pdfLexer) value() interface{} {
+	v, _ := l.next()
+	return v
+}
+
+// next 读取下一个值：数组和字典读取完整，"n g R" 读取为引用，操作符返回 pdfKeyword
+func (l *pdfLexer) next() (interface{}, bool) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, false
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		return l.literalString(), true
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		dict := pdfDict{}
+		for {
+			key, ok := l.next()
+			if !ok || key == pdfKeyword(">>") {
+				return dict, true
+			}
+			name, isName := key.(pdfName)
+			if !isName {
+				continue
+			}
+			value, ok := l.next()
+			if !ok || value == pdfKeyword(">>") {
+				return dict, true
+			}
+			dict[string(name)] = value
+		}
+	case c == '<':
+		return l.hexString(), true
+	case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+		l.pos += 2
+		return pdfKeyword(">>"), true
+	case c == '[':
+		l.pos++
+		var array []interface{}
+		for {
+			v, ok := l.next()
+			if !ok || v == pdfKeyword("]") {
+				return array, true
+			}
+			array = append(array, v)
+		}
+	case c == ']' || c == '{' || c == '}' || c == '>' || c == ')':
+		l.pos++
+		return pdfKeyword(string(c)), true
+	case c == '/':
+		l.pos++
+		return pdfName(l.regular()), true
+	}
+
+	token := l.regular()
+	if token == "" {
+		l.pos++
+		return pdfKeyword(string(c)), true
+	}
+	n, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return pdfKeyword(token), true
+	}
+	// 整数后面跟着 "g R" 时是间接引用
+	if n == math.Trunc(n) && n >= 0 {
+		save := l.pos
+		l.skipSpace()
+		if gen := l.regular(); gen != "" {
+			if _, err := strconv.Atoi(gen); err == nil {
+				l.skipSpace()
+				if l.regular() == "R" {
+					return pdfRef(int(n)), true
+				}
+			}
+		}
+		l.pos = save
+	}
+	return n, true
+}
+
+// regular 读取一串普通字符（名称、数字、操作符），名称中的 #xx 转义按十六进制解码
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	token := string(l.data[start:l.pos])
+	if strings.IndexByte(token, '#') < 0 {
+		return token
+	}
+	var sb strings.Builder
+	for i := 0; i < len(token); i++ {
+		if token[i] == '#' && i+2 < len(token) {
+			if b, err := strconv.ParseUint(token[i+1:i+3], 16, 8); err == nil {
+				sb.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(token[i])
+	}
+	return sb.String()
+}
+
+// literalString 读取括号字符串，处理嵌套括号和转义
+func (l *pdfLexer) literalString() pdfString {
+	l.pos++ // (
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				// 行尾的反斜杠表示续行
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(n))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// hexString 读取十六进制字符串，奇数位时末尾补 0
+func (l *pdfLexer) hexString() pdfString {
+	l.pos++ // <
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		c := l.data[l.pos]
+		l.pos++
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if l.pos < len(l.data) {
+		l.pos++ // >
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		b, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(b)
+	}
+	return out
+}
+
+// skipInlineImage 跳过内联图片（BI … ID 数据 EI）
+func (l *pdfLexer) skipInlineImage() {
+	for {
+		v, ok := l.next()
+		if !ok {
+			return
+		}
+		if v == pdfKeyword("ID") {
+			break
+		}
+	}
+	for l.pos < len(l.data) {
+		i := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if i < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		at := l.pos + i
+		l.pos = at + 2
+		if at > 0 && isPDFSpace(l.data[at-1]) && (l.pos >= len(l.data) || isPDFSpace(l.data[l.pos])) {
+			return
+		}
+	}
+}
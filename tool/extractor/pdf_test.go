@@ -0,0 +1,144 @@
+package extractor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF 按顺序拼接对象生成 PDF，对象编号从 1 开始。解析器不读取 xref，这里也不生成
+func buildPDF(objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+// streamObject 生成带 /Length 的流对象，extra 为字典中的其它条目
+func streamObject(extra string, data []byte) string {
+	return fmt.Sprintf("<< /Length %d %s >>\nstream\n%s\nendstream", len(data), extra, data)
+}
+
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// simplePDF 单页、使用 Helvetica 的文档
+func simplePDF(content string) []byte {
+	return buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+		streamObject("", []byte(content)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+}
+
+func TestExtractPDF(t *testing.T) {
+	cidFont := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+		streamObject("", []byte("BT /F1 12 Tf 72 720 Td <00010002> Tj ET")),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /SimSun /Encoding /Identity-H /ToUnicode 6 0 R >>",
+		streamObject("", []byte("begincmap\n2 beginbfchar\n<0001> <4E2D>\n<0002> <6587>\nendbfchar\nendcmap")),
+	)
+	twoPages := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 7 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+		streamObject("/Filter /FlateDecode", deflate([]byte("BT /F1 12 Tf (First page) Tj ET"))),
+		streamObject("", []byte("BT /F1 12 Tf (Second page) Tj ET")),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{"literal string", simplePDF("BT /F1 12 Tf 72 720 Td (Hello World) Tj ET"), []string{"Hello World"}},
+		{"escapes", simplePDF(`BT /F1 12 Tf (a\(b\) \101) Tj ET`), []string{"a(b) A"}},
+		{"lines", simplePDF("BT /F1 12 Tf 72 720 Td (line one) Tj 0 -14 Td (line two) Tj ET"), []string{"line one\nline two"}},
+		{"TJ kerning space", simplePDF("BT /F1 12 Tf [(Hello) -500 (World)] TJ ET"), []string{"Hello World"}},
+		{"inline image skipped", simplePDF("BT /F1 12 Tf (before) Tj ET BI /W 1 /H 1 ID \x00\xff EI BT (after) Tj ET"), []string{"before", "after"}},
+		{"ToUnicode CID font", cidFont, []string{"中文"}},
+		{"page tree and flate", twoPages, []string{pageMarker(1) + "\nFirst page", pageMarker(2) + "\nSecond page"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := extractPDF(tt.data)
+			if err != nil {
+				t.Fatalf("extractPDF: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text %q does not contain %q", text, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractPDFErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a pdf", []byte("hello")},
+		{"encrypted", []byte("%PDF-1.4\n1 0 obj << /Encrypt 2 0 R >> endobj")},
+		{"no pages", buildPDF("<< /Type /Catalog >>")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := extractPDF(tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestExtractPDFMalformed 截断、越界的输入不能导致 panic
+func TestExtractPDFMalformed(t *testing.T) {
+	valid := simplePDF("BT /F1 12 Tf <48656c6c6f> Tj (World) Tj ET")
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"ends in <", []byte("%PDF-1.4\n1 0 obj\n<")},
+		{"ends in <<", []byte("%PDF-1.4\n1 0 obj\n<<")},
+		{"ends in (", []byte("%PDF-1.4\n1 0 obj\n(abc\\")},
+		{"ends after stream", []byte("%PDF-1.4\n1 0 obj\n<< /Length 10 >>\nstream")},
+		{"huge length", []byte("%PDF-1.4\n1 0 obj\n<< /Length 1e300 >>\nstream\nabc\nendstream\nendobj")},
+		{"negative length", []byte("%PDF-1.4\n1 0 obj\n<< /Length -5 >>\nstream\nabc\nendstream\nendobj")},
+		{"bad object stream", buildPDF(streamObject("/Type /ObjStm /N 1e300 /First -1e300", []byte("1 99999999999")))},
+		{"hex string in content", simplePDF("BT /F1 12 Tf <48656c")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extractPDF(tt.data)
+		})
+	}
+	for i := range valid {
+		extractPDF(valid[:i])
+	}
+}
+
+func FuzzExtractPDF(f *testing.F) {
+	f.Add(simplePDF("BT /F1 12 Tf 72 720 Td (Hello World) Tj ET"))
+	f.Add(simplePDF("BT /F1 12 Tf [(a) -300 <6263>] TJ T* (d) ' ET"))
+	f.Add([]byte("%PDF-1.4\n1 0 obj\n<"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		extractPDF(data)
+	})
+}
@@ -0,0 +1,119 @@
+// tool/extractor/text.go
+
+package extractor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// extractPlainText txt、md 原样返回
+func extractPlainText(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// extractCSV 按行输出，单元格之间用制表符分隔。格式不规范时退回原文
+func extractCSV(data []byte) (string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\uFEFF"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return string(data), nil
+	}
+	var sb strings.Builder
+	for _, record := range records {
+		sb.WriteString(strings.Join(record, "\t"))
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// htmlSkipped 不输出内容的 HTML 元素
+var htmlSkipped = map[string]bool{"script": true, "style": true, "head": true, "noscript": true, "template": true, "svg": true}
+
+// htmlBlocks 前后需要换行的 HTML 块级元素
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "table": true, "section": true, "article": true,
+	"header": true, "footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "pre": true, "blockquote": true, "hr": true, "title": true, "dt": true, "dd": true,
+}
+
+var (
+	// htmlScriptPattern 脚本、样式和注释中可能出现未转义的 <，解析前先去掉
+	htmlScriptPattern = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>|<!--.*?-->`)
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// extractHTML 提取 HTML/XML 中的文本，忽略脚本和样式，块级元素之间换行，表格单元格之间用制表符分隔。
+// 文档不规范导致无法解析时退回为直接去掉标签
+func extractHTML(data []byte) (string, error) {
+	data = htmlScriptPattern.ReplaceAll(data, nil)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var sb strings.Builder
+	skip := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return html.UnescapeString(htmlTagPattern.ReplaceAllString(string(data), "\n")), nil
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if htmlSkipped[name] {
+				skip++
+			} else if htmlBlocks[name] {
+				sb.WriteString("\n")
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case htmlSkipped[name]:
+				if skip > 0 {
+					skip--
+				}
+			case name == "td" || name == "th":
+				sb.WriteString("\t")
+			case htmlBlocks[name]:
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if skip == 0 {
+				sb.WriteString(collapseSpaces(string(t)))
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// collapseSpaces 把连续空白合并为一个空格，与浏览器的显示一致
+func collapseSpaces(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		if text != "" {
+			return " "
+		}
+		return ""
+	}
+	result := strings.Join(fields, " ")
+	if strings.TrimLeftFunc(text, unicode.IsSpace) != text {
+		result = " " + result
+	}
+	if strings.TrimRightFunc(text, unicode.IsSpace) != text {
+		result += " "
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package extractor
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtractCSV(t *testing.T) {
+	text, err := Extract("a.csv", []byte("\uFEFF名称,数量\n\"苹果,红色\",3\n梨\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "名称\t数量\n苹果,红色\t3\n梨"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+}
+
+func TestExtractHTML(t *testing.T) {
+	page := `<html><head><title>标题栏</title><style>p { color: red }</style></head><body>` +
+		`<h1>标题</h1><p>第一   段&nbsp;内容 &amp; 说明</p>` +
+		`<script>if (a < b) { alert("x") }</script><!-- 注释 -->` +
+		`<table><tr><td>A</td><td>B</td></tr><tr><td>C</td><td>D</td></tr></table>` +
+		`</body></html>`
+	text, err := Extract("a.html", []byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "标题\n\n第一 段 内容 & 说明\n\nA\tB\n\nC\tD"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+
+	// 无法解析时退回为直接去掉标签
+	text, err = Extract("a.html", []byte("<p>未闭合 <b 属性 <i>文本</p>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text == "" {
+		t.Error("malformed html returned no text")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	input := "\uFEFF第一行  \r\n\r\n\r\n\r\n第二行\u3000\u200B内容\x00\r 末行\t\xff"
+	if got, want := normalize(input), "第一行\n\n第二行 内容\n 末行"; got != want {
+		t.Errorf("normalize = %q, want %q", got, want)
+	}
+}
+
+func TestExtractErrors(t *testing.T) {
+	if _, err := Extract("a.doc", []byte("x")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("doc: err = %v, want ErrUnsupported", err)
+	}
+	if _, err := Extract("a.txt", []byte(" \n\t\r\n")); !errors.Is(err, ErrNoText) {
+		t.Errorf("blank text: err = %v, want ErrNoText", err)
+	}
+	if !Supported("A.DOCX") || Supported("a.doc") {
+		t.Error("Supported should match extensions case-insensitively")
+	}
+}
@@ -0,0 +1,135 @@
+// file_status_sync.go
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileStatusSyncResult 一次状态同步的统计
+type FileStatusSyncResult struct {
+	Checked   int `json:"checked"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Errors    int `json:"errors"` // 查询 StepFun 失败的文件数
+}
+
+// ErrSyncInProgress 同一范围（全部处理中的文件或同一个知识库）的状态同步正在进行
+var ErrSyncInProgress = errors.New("文件状态同步正在进行")
+
+var (
+	syncMu  sync.Mutex
+	syncing = map[string]bool{} // 正在同步的范围，键为知识库ID，后台同步所有文件时为空字符串
+)
+
+// SyncFileStatuses 向 StepFun 查询文件的向量化状态并更新 files 和 uploaded_files。
+// vectorStoreID 为空时同步所有处理中的文件，否则强制刷新该知识库的全部文件。
+// 同一范围同时只进行一次同步，已在进行时返回 ErrSyncInProgress；不同知识库的手动刷新和后台同步互不等待
+func SyncFileStatuses(ctx context.Context, db *dbop.Database, vectorStoreID string) (FileStatusSyncResult, error) {
+	var result FileStatusSyncResult
+	syncMu.Lock()
+	if syncing[vectorStoreID] {
+		syncMu.Unlock()
+		return result, ErrSyncInProgress
+	}
+	syncing[vectorStoreID] = true
+	syncMu.Unlock()
+	defer func() {
+		syncMu.Lock()
+		delete(syncing, vectorStoreID)
+		syncMu.Unlock()
+	}()
+
+	files, err := db.ListFilesToSync(vectorStoreID)
+	if err != nil {
+		return result, fmt.Errorf("查询待同步的文件失败: %w", err)
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		result.Checked++
+		statusResp, err := GetStepFunFile(ctx, file.VectorFileID)
+		if err != nil {
+			// 查询失败不改变文件状态，只记录原因，下次继续查询
+			logrus.Warnf("查询文件 %s 的状态失败: %v", file.VectorFileID, err)
+			result.Errors++
+			if err := db.SaveFileStatusCheck(file.VectorFileID, file.FileID, "", "", "", "查询状态失败: "+err.Error()); err != nil {
+				logrus.Errorf("保存文件 %s 的状态查询记录失败: %v", file.VectorFileID, err)
+			}
+			continue
+		}
+
+		status, uploadedStatus, reason := classifyFileStatus(statusResp)
+		switch status {
+		case "completed":
+			result.Completed++
+		case "failed":
+			result.Failed++
+		}
+		if err := db.SaveFileStatusCheck(file.VectorFileID, file.FileID, statusResp.Status, status, uploadedStatus, reason); err != nil {
+			logrus.Errorf("更新文件 %s 的状态失败: %v", file.VectorFileID, err)
+			result.Errors++
+		}
+	}
+	return result, nil
+}
+
+// classifyFileStatus 把 StepFun 返回的文件状态换算成 files 和 uploaded_files 的状态，仍在处理中时都为空
+func classifyFileStatus(statusResp *models.FileStatusResponse) (status, uploadedStatus, reason string) {
+	switch strings.ToLower(statusResp.Status) {
+	case "success", "completed", "processed":
+		return "completed", "success", ""
+	case "failed", "error", "cancelled":
+		reason = statusResp.StatusDetails
+		if reason == "" {
+			reason = fmt.Sprintf("StepFun 返回状态 %s", statusResp.Status)
+		}
+		return "failed", "failed", reason
+	}
+	return "", "", ""
+}
+
+// StartFileStatusSync 启动后台协程，每隔 interval 同步一次处理中文件的状态
+func StartFileStatusSync(db *dbop.Database, interval time.Duration) {
+	if interval <= 0 {
+		logrus.Info("文件状态后台同步已关闭")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			result, err := SyncFileStatuses(context.Background(), db, "")
+			if err != nil {
+				logrus.Errorf("同步文件状态失败: %v", err)
+				continue
+			}
+			if result.Checked > 0 {
+				logrus.Infof("同步文件状态: 查询 %d 个，完成 %d 个，失败 %d 个，出错 %d 个",
+					result.Checked, result.Completed, result.Failed, result.Errors)
+			}
+		}
+	}()
+}
+
+// FileStatusSyncInterval 读取 FILE_STATUS_SYNC_SECONDS，默认 60 秒，设为 0 关闭后台同步
+func FileStatusSyncInterval() time.Duration {
+	if raw := os.Getenv("FILE_STATUS_SYNC_SECONDS"); raw != "" {
+		if seconds, err := strconv.Atoi(raw); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		logrus.Warnf("FILE_STATUS_SYNC_SECONDS 格式错误: %s", raw)
+	}
+	return 60 * time.Second
+}
@@ -0,0 +1,277 @@
+// jobs.go
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// 任务队列参数
+const (
+	jobMaxAttempts  = 3                // 每个任务最多执行的次数
+	jobRetryDelay   = 15               // 第 n 次失败后等待 n*jobRetryDelay 秒再重试
+	jobTimeout      = 10 * time.Minute // 单次执行的超时时间
+	jobPollInterval = 2 * time.Second  // 没有新任务通知时检查到期任务的间隔
+	jobLease        = 60               // 执行中任务的租约秒数，执行期间每 jobLease/3 秒续期一次，过期后由任意实例放回队列
+)
+
+// JobHandler 执行一种类型的任务。report 更新任务当前的步骤和进度，返回值序列化后作为任务结果保存。
+// 返回的错误默认可以重试，用 Permanent 包装的错误直接使任务失败
+type JobHandler func(ctx context.Context, db *dbop.Database, job *models.Job, report func(stage string, progress int)) (interface{}, error)
+
+// jobHandlers 按任务类型注册的执行函数
+var jobHandlers = map[string]JobHandler{
+	models.JobKnowledgeUpload: runKnowledgeUploadJob,
+}
+
+// RegisterJobHandler 注册任务类型的执行函数，需要在 StartJobWorkers 之前调用
+func RegisterJobHandler(jobType string, handler JobHandler) {
+	jobHandlers[jobType] = handler
+}
+
+// jobWake 有新任务时通知空闲的工作协程
+var jobWake = make(chan struct{}, 1)
+
+// permanentError 重试也无法成功的错误，如文件格式不支持
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent 标记错误不需要重试
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// EnqueueJob 创建任务并通知工作协程。文件已有同类未结束的任务时直接返回该任务，created 为 false。
+// 同一文件并发上传时由数据库保证只创建一个任务
+func EnqueueJob(db *dbop.Database, jobType, fileID, vectorStoreID, userName string, payload interface{}) (job *models.Job, created bool, err error) {
+	if _, ok := jobHandlers[jobType]; !ok {
+		return nil, false, fmt.Errorf("不支持的任务类型: %s", jobType)
+	}
+
+	job = &models.Job{
+		ID:            uuid.New().String(),
+		Type:          jobType,
+		Stage:         "queued",
+		FileID:        fileID,
+		VectorStoreID: vectorStoreID,
+		Username:      userName,
+		MaxAttempts:   jobMaxAttempts,
+	}
+	if payload != nil {
+		if job.Payload, err = json.Marshal(payload); err != nil {
+			return nil, false, fmt.Errorf("序列化任务参数失败: %w", err)
+		}
+	}
+	// 没有插入说明并发的请求已创建了任务，重新查询并返回该任务；该任务恰好已结束时重新插入
+	for attempt := 0; ; attempt++ {
+		existing, err := db.FindActiveJob(jobType, fileID, vectorStoreID)
+		if err != nil {
+			return nil, false, fmt.Errorf("查询任务失败: %w", err)
+		}
+		if existing != nil {
+			return existing, false, nil
+		}
+		if attempt == 3 {
+			return nil, false, fmt.Errorf("创建任务失败: 文件 %s 的任务状态频繁变化", fileID)
+		}
+		inserted, err := db.InsertJob(*job)
+		if err != nil {
+			return nil, false, err
+		}
+		if inserted {
+			break
+		}
+	}
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	job, err = db.GetJob(job.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	return job, true, nil
+}
+
+// WaitJob 等待任务结束（成功或失败）后返回任务，ctx 取消时返回 ctx 的错误
+func WaitJob(ctx context.Context, db *dbop.Database, jobID string) (*models.Job, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		job, err := db.GetJob(jobID)
+		if err != nil {
+			return nil, err
+		}
+		if job == nil {
+			return nil, fmt.Errorf("任务 %s 不存在", jobID)
+		}
+		if job.Status == models.JobSucceeded || job.Status == models.JobFailed {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// StartJobWorkers 启动 workers 个工作协程执行任务，并定期把租约过期（执行的实例已退出）的任务放回队列
+func StartJobWorkers(db *dbop.Database, workers int) {
+	go func() {
+		for {
+			requeueExpiredJobs(db)
+			time.Sleep(jobLease * time.Second)
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go jobWorker(db)
+	}
+	logrus.Infof("后台任务工作协程已启动，共 %d 个", workers)
+}
+
+// requeueExpiredJobs 把租约过期的执行中任务放回队列
+func requeueExpiredJobs(db *dbop.Database) {
+	requeued, err := db.RequeueExpiredJobs()
+	if err != nil {
+		logrus.Errorf("恢复中断的任务失败: %v", err)
+		return
+	}
+	if requeued > 0 {
+		logrus.Infof("恢复了 %d 个中断的任务", requeued)
+		select {
+		case jobWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// JobWorkerCount 读取 JOB_WORKERS，默认 2 个工作协程
+func JobWorkerCount() int {
+	if raw := os.Getenv("JOB_WORKERS"); raw != "" {
+		if workers, err := strconv.Atoi(raw); err == nil && workers > 0 {
+			return workers
+		}
+		logrus.Warnf("JOB_WORKERS 格式错误: %s", raw)
+	}
+	return 2
+}
+
+// jobWorker 循环领取并执行任务，没有任务时等待新任务通知或到期的重试
+func jobWorker(db *dbop.Database) {
+	for {
+		job, err := db.ClaimJob(jobLease)
+		if err != nil {
+			logrus.Errorf("领取任务失败: %v", err)
+			time.Sleep(jobPollInterval)
+			continue
+		}
+		if job == nil {
+			select {
+			case <-jobWake:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+		runJob(db, job)
+	}
+}
+
+// runJob 执行任务并保存结果，可重试的错误在次数用完之前推迟重试
+func runJob(db *dbop.Database, job *models.Job) {
+	log := logrus.WithFields(logrus.Fields{"job_id": job.ID, "type": job.Type, "attempt": job.Attempts})
+	handler, ok := jobHandlers[job.Type]
+	if !ok {
+		if err := db.FailJob(job.ID, job.Attempts, fmt.Sprintf("不支持的任务类型: %s", job.Type)); err != nil {
+			log.Errorf("保存任务状态失败: %v", err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+	go renewJobLease(ctx, db, job, log)
+	report := func(stage string, progress int) {
+		if err := db.UpdateJobProgress(job.ID, job.Attempts, stage, progress, jobLease); err != nil {
+			log.Errorf("更新任务进度失败: %v", err)
+		}
+	}
+	result, err := callJobHandler(ctx, handler, db, job, report)
+	cancel()
+	if err == nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			log.Errorf("序列化任务结果失败: %v", marshalErr)
+			data = nil
+		}
+		if err := db.CompleteJob(job.ID, job.Attempts, data); err != nil {
+			logSaveJobError(log, err)
+			return
+		}
+		log.Info("任务执行成功")
+		return
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		log.Errorf("任务执行失败: %v", err)
+		if err := db.FailJob(job.ID, job.Attempts, err.Error()); err != nil {
+			logSaveJobError(log, err)
+		}
+		return
+	}
+	delay := job.Attempts * jobRetryDelay
+	log.Warnf("任务执行失败，%d 秒后重试: %v", delay, err)
+	if err := db.RetryJob(job.ID, job.Attempts, err.Error(), delay); err != nil {
+		logSaveJobError(log, err)
+	}
+}
+
+// logSaveJobError 记录保存任务状态失败的原因，租约丢失时本次执行的结果被丢弃
+func logSaveJobError(log *logrus.Entry, err error) {
+	if errors.Is(err, dbop.ErrJobLeaseLost) {
+		log.Warn("任务租约已过期并被重新放回队列，丢弃本次执行的结果")
+		return
+	}
+	log.Errorf("保存任务状态失败: %v", err)
+}
+
+// renewJobLease 在任务执行期间定期续期租约，ctx 结束时退出
+func renewJobLease(ctx context.Context, db *dbop.Database, job *models.Job, log *logrus.Entry) {
+	ticker := time.NewTicker(jobLease * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := db.RenewJobLease(job.ID, job.Attempts, jobLease); err != nil {
+				log.Errorf("续期任务租约失败: %v", err)
+			}
+		}
+	}
+}
+
+// callJobHandler 执行任务，执行函数 panic 时转换为错误，避免工作协程退出
+func callJobHandler(ctx context.Context, handler JobHandler, db *dbop.Database, job *models.Job, report func(string, int)) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, db, job, report)
+}
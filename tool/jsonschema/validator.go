@@ -0,0 +1,477 @@
+// tool/jsonschema/validator.go
+
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Validator 按 JSON Schema 校验 JSON 数据，支持结构化输出常用的关键字：
+// type、enum、const、properties、required、additionalProperties、items、
+// minItems/maxItems、uniqueItems、minLength/maxLength、pattern、
+// minimum/maximum/exclusiveMinimum/exclusiveMaximum、multipleOf、
+// allOf/anyOf/oneOf/not，以及指向 #/definitions 或 #/$defs 的 $ref。format 等注解不做校验
+type Validator struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// maxDepth 校验时 Schema 的最大嵌套层数，递归定义的 Schema 遇到过深的数据时报错而不是耗尽栈空间
+const maxDepth = 100
+
+// ValidationError 描述一处不符合 Schema 的位置，Path 为 JSON Pointer
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Message)
+}
+
+// New 创建校验器，Schema 本身格式错误（如正则无法编译、$ref 循环引用自身）时返回错误
+func New(schema map[string]interface{}) (*Validator, error) {
+	v := &Validator{root: schema, patterns: make(map[string]*regexp.Regexp)}
+	if err := v.compile(schema); err != nil {
+		return nil, err
+	}
+	if err := v.checkCycles(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkCycles 拒绝不经过 properties、items 的循环引用。$ref 和 allOf/anyOf/oneOf/not 校验的是同一个值，
+// 只由它们组成的循环（如 {"$ref": "#"}）会无限递归。经过 properties、items 的递归每层都深入数据，可以结束
+func (v *Validator) checkCycles() error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[uintptr]int)
+	var visit func(schema map[string]interface{}) error
+	visit = func(schema map[string]interface{}) error {
+		key := reflect.ValueOf(schema).Pointer()
+		switch state[key] {
+		case visiting:
+			return fmt.Errorf("Schema 存在循环引用，校验无法结束")
+		case done:
+			return nil
+		}
+		state[key] = visiting
+		for _, next := range v.sameValueSchemas(schema) {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[key] = done
+		return nil
+	}
+
+	// 从每个子 Schema 出发检查，包括只在 $defs 中定义的
+	var walk func(node interface{}) error
+	walk = func(node interface{}) error {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if err := visit(n); err != nil {
+				return err
+			}
+			for _, child := range n {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, child := range n {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(v.root)
+}
+
+// sameValueSchemas 返回校验时作用于同一个值的子 Schema：$ref 的目标（有 $ref 时忽略其它关键字），或 allOf/anyOf/oneOf/not 中的 Schema
+func (v *Validator) sameValueSchemas(schema map[string]interface{}) []map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		// 无法解析的 $ref 在校验时报告
+		if target, err := v.resolve(ref); err == nil {
+			return []map[string]interface{}{target}
+		}
+		return nil
+	}
+	var schemas []map[string]interface{}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[keyword].([]interface{})
+		for _, sub := range subs {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				schemas = append(schemas, subSchema)
+			}
+		}
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok {
+		schemas = append(schemas, not)
+	}
+	return schemas
+}
+
+// compile 预编译 Schema 中的所有 pattern
+func (v *Validator) compile(node interface{}) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if pattern, ok := n["pattern"].(string); ok {
+			if _, exists := v.patterns[pattern]; !exists {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return fmt.Errorf("无效的 pattern %q: %w", pattern, err)
+				}
+				v.patterns[pattern] = re
+			}
+		}
+		for _, child := range n {
+			if err := v.compile(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range n {
+			if err := v.compile(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate 校验已解码的 JSON 数据（encoding/json 解码得到的 interface{}），返回所有不符合的位置
+func (v *Validator) Validate(data interface{}) []ValidationError {
+	var errs []ValidationError
+	v.validate(v.root, data, "", 0, &errs)
+	return errs
+}
+
+// ValidateJSON 解码并校验 JSON 文本
+func (v *Validator) ValidateJSON(raw []byte) (interface{}, []ValidationError) {
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, []ValidationError{{Message: fmt.Sprintf("不是合法的 JSON: %v", err)}}
+	}
+	return data, v.Validate(data)
+}
+
+func (v *Validator) validate(schema map[string]interface{}, data interface{}, path string, depth int, errs *[]ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if depth > maxDepth {
+		fail("Schema 嵌套超过 %d 层", maxDepth)
+		return
+	}
+	depth++
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		v.validate(target, data, path, depth, errs)
+		return
+	}
+
+	if types, ok := schemaTypes(schema["type"]); ok && !matchesAnyType(types, data) {
+		fail("类型应为 %s，实际为 %s", strings.Join(types, " 或 "), typeName(data))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(candidate, data) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("取值必须是 %s 之一", compactJSON(enum))
+		}
+	}
+	if constant, ok := schema["const"]; ok && !jsonEqual(constant, data) {
+		fail("取值必须是 %s", compactJSON(constant))
+	}
+
+	switch value := data.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, value, path, depth, errs)
+	case []interface{}:
+		v.validateArray(schema, value, path, depth, errs)
+	case string:
+		length := len([]rune(value))
+		if min, ok := number(schema["minLength"]); ok && float64(length) < min {
+			fail("长度不能少于 %v", min)
+		}
+		if max, ok := number(schema["maxLength"]); ok && float64(length) > max {
+			fail("长度不能超过 %v", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !v.patterns[pattern].MatchString(value) {
+			fail("不匹配正则 %s", pattern)
+		}
+	case float64:
+		if min, ok := number(schema["minimum"]); ok && value < min {
+			fail("不能小于 %v", min)
+		}
+		if max, ok := number(schema["maximum"]); ok && value > max {
+			fail("不能大于 %v", max)
+		}
+		if min, ok := number(schema["exclusiveMinimum"]); ok && value <= min {
+			fail("必须大于 %v", min)
+		}
+		if max, ok := number(schema["exclusiveMaximum"]); ok && value >= max {
+			fail("必须小于 %v", max)
+		}
+		if step, ok := number(schema["multipleOf"]); ok && step > 0 {
+			if quotient := value / step; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+				fail("必须是 %v 的倍数", step)
+			}
+		}
+	}
+
+	v.validateCombinators(schema, data, path, depth, errs)
+}
+
+func (v *Validator) validateObject(schema map[string]interface{}, value map[string]interface{}, path string, depth int, errs *[]ValidationError) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, item := range required {
+			name, _ := item.(string)
+			if _, exists := value[name]; !exists {
+				*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("缺少必填字段 %s", name)})
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // 错误按字段名排序，便于阅读和复现
+	for _, key := range keys {
+		childPath := path + "/" + escapePointer(key)
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			v.validate(propSchema, value[key], childPath, depth, errs)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("不允许出现字段 %s", key)})
+			}
+		case map[string]interface{}:
+			v.validate(additional, value[key], childPath, depth, errs)
+		}
+	}
+}
+
+func (v *Validator) validateArray(schema map[string]interface{}, value []interface{}, path string, depth int, errs *[]ValidationError) {
+	if min, ok := number(schema["minItems"]); ok && float64(len(value)) < min {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("元素个数不能少于 %v", min)})
+	}
+	if max, ok := number(schema["maxItems"]); ok && float64(len(value)) > max {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("元素个数不能超过 %v", max)})
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := 0; i < len(value); i++ {
+			for j := i + 1; j < len(value); j++ {
+				if jsonEqual(value[i], value[j]) {
+					*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("第 %d 和第 %d 个元素重复", i, j)})
+				}
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range value {
+			v.validate(items, item, fmt.Sprintf("%s/%d", path, i), depth, errs)
+		}
+	}
+}
+
+func (v *Validator) validateCombinators(schema map[string]interface{}, data interface{}, path string, depth int, errs *[]ValidationError) {
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				v.validate(subSchema, data, path, depth, errs)
+			}
+		}
+	}
+	if any, ok := schema["anyOf"].([]interface{}); ok {
+		if v.countMatches(any, data, path, depth) == 0 {
+			*errs = append(*errs, ValidationError{Path: path, Message: "不满足 anyOf 中的任何一个 Schema"})
+		}
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		if matched := v.countMatches(one, data, path, depth); matched != 1 {
+			*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("应恰好满足 oneOf 中的一个 Schema，实际满足 %d 个", matched)})
+		}
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok {
+		var subErrs []ValidationError
+		v.validate(not, data, path, depth, &subErrs)
+		if len(subErrs) == 0 {
+			*errs = append(*errs, ValidationError{Path: path, Message: "不应满足 not 中的 Schema"})
+		}
+	}
+}
+
+// countMatches 统计 data 满足 schemas 中的几个
+func (v *Validator) countMatches(schemas []interface{}, data interface{}, path string, depth int) int {
+	matched := 0
+	for _, sub := range schemas {
+		subSchema, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var subErrs []ValidationError
+		v.validate(subSchema, data, path, depth, &subErrs)
+		if len(subErrs) == 0 {
+			matched++
+		}
+	}
+	return matched
+}
+
+// resolve 解析文档内的 $ref，例如 #/definitions/item、#/$defs/item
+func (v *Validator) resolve(ref string) (map[string]interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("只支持文档内的 $ref: %s", ref)
+	}
+	var node interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("无法解析 $ref: %s", ref)
+		}
+		node = object[part]
+	}
+	target, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("无法解析 $ref: %s", ref)
+	}
+	return target, nil
+}
+
+// schemaTypes 读取 type 关键字，可能是字符串或字符串数组
+func schemaTypes(raw interface{}) ([]string, bool) {
+	switch t := raw.(type) {
+	case string:
+		return []string{t}, true
+	case []interface{}:
+		var types []string
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types, len(types) > 0
+	}
+	return nil, false
+}
+
+func matchesAnyType(types []string, data interface{}) bool {
+	for _, t := range types {
+		if matchesType(t, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(t string, data interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := data.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := data.([]interface{})
+		return ok
+	case "string":
+		_, ok := data.(string)
+		return ok
+	case "number":
+		_, ok := data.(float64)
+		return ok
+	case "integer":
+		n, ok := data.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := data.(bool)
+		return ok
+	case "null":
+		return data == nil
+	}
+	return false
+}
+
+func typeName(data interface{}) string {
+	switch n := data.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", data)
+}
+
+func number(raw interface{}) (float64, bool) {
+	switch n := raw.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func compactJSON(value interface{}) string {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(raw)
+}
+
+// escapePointer 按 JSON Pointer 规则转义字段名
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
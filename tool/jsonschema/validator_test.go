@@ -0,0 +1,143 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// mustNew 解析 JSON 格式的 Schema 并创建校验器
+func mustNew(t *testing.T, schema string) *Validator {
+	t.Helper()
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	v, err := New(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// check 校验 data，wantPaths 为空表示应当通过，否则为各个错误的 Path
+func check(t *testing.T, v *Validator, data string, wantPaths ...string) {
+	t.Helper()
+	_, errs := v.ValidateJSON([]byte(data))
+	var paths []string
+	for _, err := range errs {
+		paths = append(paths, err.Path)
+	}
+	if strings.Join(paths, ",") != strings.Join(wantPaths, ",") {
+		t.Errorf("%s: errors %v, want paths %v", data, errs, wantPaths)
+	}
+}
+
+func TestValidateKeywords(t *testing.T) {
+	v := mustNew(t, `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z]+$"},
+			"age": {"type": "integer"},
+			"level": {"enum": ["low", "high"]},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"contact": {"oneOf": [
+				{"type": "string"},
+				{"type": "object", "required": ["phone"]}
+			]}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`)
+
+	check(t, v, `{"name": "alice", "age": 30, "level": "low", "tags": ["a", "b"], "contact": "x@example.com"}`)
+	check(t, v, `[]`, "")
+	check(t, v, `{}`, "")
+	check(t, v, `{"name": "alice", "age": 1.5}`, "/age")
+	check(t, v, `{"name": "Alice"}`, "/name")
+	check(t, v, `{"name": "alice", "level": "medium"}`, "/level")
+	check(t, v, `{"name": "alice", "tags": ["a", 1]}`, "/tags/1")
+	check(t, v, `{"name": "alice", "extra": true}`, "")
+	check(t, v, `{"name": "alice", "contact": {"phone": "123"}}`)
+	check(t, v, `{"name": "alice", "contact": {"email": "x@example.com"}}`, "/contact")
+	check(t, v, `{"name": "alice", "contact": 1}`, "/contact")
+
+	if _, errs := v.ValidateJSON([]byte(`{"name":`)); len(errs) != 1 {
+		t.Errorf("invalid JSON: errors %v", errs)
+	}
+}
+
+func TestValidateAdditionalPropertiesSchema(t *testing.T) {
+	v := mustNew(t, `{"type": "object", "additionalProperties": {"type": "number"}}`)
+	check(t, v, `{"a": 1, "b": 2.5}`)
+	check(t, v, `{"a": 1, "b": "2"}`, "/b")
+}
+
+func TestValidateRef(t *testing.T) {
+	v := mustNew(t, `{
+		"type": "object",
+		"properties": {
+			"item": {"$ref": "#/$defs/item"},
+			"legacy": {"$ref": "#/definitions/item"},
+			"slash": {"$ref": "#/$defs/a~1b"}
+		},
+		"$defs": {
+			"item": {"type": "object", "required": ["id"]},
+			"a/b": {"type": "string"}
+		},
+		"definitions": {
+			"item": {"type": "object", "required": ["id"]}
+		}
+	}`)
+	check(t, v, `{"item": {"id": 1}, "legacy": {"id": 2}, "slash": "x"}`)
+	check(t, v, `{"item": {}, "legacy": {}, "slash": 1}`, "/item", "/legacy", "/slash")
+
+	v = mustNew(t, `{"properties": {"item": {"$ref": "#/$defs/missing"}}}`)
+	check(t, v, `{"item": 1}`, "/item")
+}
+
+// TestValidateRecursiveRef 经过 properties、items 的递归引用是合法的，每层都会深入数据
+func TestValidateRecursiveRef(t *testing.T) {
+	v := mustNew(t, `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"children": {"type": "array", "items": {"$ref": "#"}}
+		}
+	}`)
+	check(t, v, `{"name": "root", "children": [{"name": "a", "children": [{"name": "b"}]}]}`)
+	check(t, v, `{"name": "root", "children": [{"name": "a", "children": [{"name": 1}]}]}`, "/children/0/children/0/name")
+
+	// 数据嵌套过深时返回校验错误而不是耗尽栈空间
+	v = mustNew(t, `{"type": "object", "properties": {"child": {"$ref": "#"}}}`)
+	data := strings.Repeat(`{"child": `, maxDepth) + `{}` + strings.Repeat(`}`, maxDepth)
+	_, errs := v.ValidateJSON([]byte(data))
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "嵌套") {
+		t.Errorf("deeply nested data: errors %v", errs)
+	}
+}
+
+// TestNewRejectsCyclicRef 只经过 $ref 和组合关键字的循环引用在创建校验器时报错
+func TestNewRejectsCyclicRef(t *testing.T) {
+	for _, schema := range []string{
+		`{"$ref": "#"}`,
+		`{"properties": {"a": {"$ref": "#/$defs/self"}}, "$defs": {"self": {"$ref": "#/$defs/self"}}}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}}`,
+		`{"allOf": [{"$ref": "#"}]}`,
+		`{"$defs": {"a": {"anyOf": [{"type": "string"}, {"not": {"$ref": "#/$defs/a"}}]}}}`,
+	} {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(parsed); err == nil {
+			t.Errorf("%s: expected an error", schema)
+		}
+	}
+}
+
+func TestNewRejectsInvalidPattern(t *testing.T) {
+	if _, err := New(map[string]interface{}{"pattern": "("}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
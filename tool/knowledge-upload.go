@@ -97,25 +97,19 @@ func HandleUploadFile(c *gin.Context, db *dbop.Database) {
 			//此文件已经在知识库下，在解析中，我将查询最新的解析状态
 			if stepFileStatus == "processing" {
 				c.JSON(http.StatusOK, gin.H{
-					"status": "此文件已经在知识库下，在解析中，请在页面点击【更新状态】查询最新的解析状态",
+					"status": "此文件已经在知识库下，在解析中，后台会自动同步解析状态，也可在页面点击【更新状态】立即查询",
 				})
 				return
 			}
-			//此文件已经在知识库下，但为跟知识库进行绑定，执行向量化动作
+			//此文件已经在知识库下，但为跟知识库进行绑定，由后台任务执行绑定和向量化
 			if stepFileStatus == "uploaded" {
-				_, err = BindFilesToVectorStore(vectorStoreID, fileStepFileID)
-				if err != nil {
-					logrus.Errorf("绑定文件到向量库报错: %v", err)
-					c.JSON(http.StatusBadRequest, gin.H{"error": "绑定文件到向量库报错"})
-					return
-				}
-				c.JSON(http.StatusOK, gin.H{
-					"status": "此文件此前已经在知识库下，但未绑定知识库，现已进行绑定，请点击【更新状态】查询最新的解析状态",
-				})
+				logrus.Infof("文件 %s 已上传但未绑定知识库 %s", fileStepFileID, vectorStoreID)
+				enqueueKnowledgeUpload(c, db, uploadedFile[0].FileID, vectorStoreID, userName,
+					"此文件此前已经在知识库下，但未绑定知识库，已加入处理队列进行绑定")
 				return
 			}
 			//此文件已上传，但未在此知识库下，将进行上传，解析，更新状态
-			handleExistingFile(uploadedFile[0], vectorStoreID, uploadDir, file_web_path, c, db)
+			handleExistingFile(uploadedFile[0], vectorStoreID, userName, file_web_path, c, db)
 			return
 		} else {
 			c.JSON(http.StatusOK, gin.H{
@@ -176,7 +170,7 @@ func validateModelOwner(modelOwner string, c *gin.Context) error {
 }
 
 // 处理已存在的文件
-func handleExistingFile(uploadedFile *models.UploadedFile, vectorStoreID, uploadDir, file_web_path string, c *gin.Context, db *dbop.Database) {
+func handleExistingFile(uploadedFile *models.UploadedFile, vectorStoreID, userName, file_web_path string, c *gin.Context, db *dbop.Database) {
 	//file_web_host := os.Getenv("FILE_WEB_HOST")
 	//如果是聊天窗口上传的文件
 	if vectorStoreID == "local" {
@@ -207,35 +201,9 @@ func handleExistingFile(uploadedFile *models.UploadedFile, vectorStoreID, upload
 			})
 			return
 		} else {
-			//如果知识库或者类型有一个对不上，就说明该文件虽然上传过，但不再同一个知识库，或者不是retrieval用途，则需要上传文件到stepfun
-			// 获取上传目录
-			//uploadDir := getUploadDir()
-			FilePath := filepath.Join(uploadDir, uploadedFile.FilePath)
-			//向调用doc parser上传文件
-			uploadResp, err := UploadFileToStepFunWithExtract(FilePath, uploadedFile.Filename, "retrieval")
-			if err != nil {
-				logrus.Errorf("上传文件到stepfun报错 %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "上传文件到stepfun报错"})
-				return
-			}
-			// 更新数据库中的 files 表，存储 API 返回的文件信息，增加 fileID
-			err = db.InsertFile(uploadResp.ID, vectorStoreID, uploadResp.Bytes, uploadedFile.FileID, "processing", "retrieval")
-			if err != nil {
-				logrus.Errorf("插入files表，retrieval的文件数据 %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "插入files表，retrieval的文件数据报错"})
-				return
-			}
-			//再绑定文件到知识库。确保文件进行向量化
-			//fileIDs := []string{uploadedFile.StepFileID}
-			_, err = BindFilesToVectorStore(vectorStoreID, uploadedFile.StepFileID)
-			if err != nil {
-				logrus.Errorf("绑定文件到知识库报错 %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "绑定文件到知识库报错"})
-				return
-			}
-			c.JSON(http.StatusOK, gin.H{
-				"status": "文件绑定到知识库，正常向量化中，可以在知识库点击获取向量化状态，获取向量化进度",
-			})
+			//如果知识库或者类型有一个对不上，就说明该文件虽然上传过，但不再同一个知识库，或者不是retrieval用途，
+			//则需要由后台任务上传文件到stepfun（本地知识库在本地切片、向量化）
+			enqueueKnowledgeUpload(c, db, uploadedFile.FileID, vectorStoreID, userName, "文件已加入处理队列")
 		}
 	}
 }
@@ -335,76 +303,47 @@ func processNewFileUpload(
 		return
 	}
 
-	// 根据 vectorStoreID 处理不同逻辑，外部 API 调用移出事务之外
+	// 根据 vectorStoreID 处理不同逻辑，解析、上传等耗时操作交给后台任务
 	if vectorStoreID == "local" {
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"file_id":       fileID,
 			"status":        "文件已上传",
 			"file_web_path": file_web_path,
-		})
-		return
-	}
-
-	// 调用外部 API 上传文件到 StepFun
-	uploadResp, err := UploadFileToStepFunWithExtract(filePath, fileName, "retrieval")
-	if err != nil {
-		logrus.Errorf("上传文件到stepfun报错 %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "上传文件到stepfun报错"})
-		return
-	}
-
-	// 插入 files 表的数据，这里可以使用新的事务
-	tx, err = db.BeginTransaction()
-	if err != nil {
-		logrus.WithError(err).Error("启动事务时出错")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库错误"})
-		return
-	}
-
-	defer func() {
-		if p := recover(); p != nil {
-			tx.Rollback()
-			logrus.Errorf("捕获到 panic: %v", p)
-			err = fmt.Errorf("panic: %v", p)
-		} else if err != nil {
-			tx.Rollback()
-		} else {
-			commitErr := db.CommitTransaction(tx)
-			if commitErr != nil {
-				logrus.WithError(commitErr).Error("提交事务时出错")
-				err = commitErr
+		}
+		// 聊天窗口上传的文档提前解析，发送消息时直接使用解析结果
+		if isTextFile(fileName) {
+			job, _, jobErr := EnqueueJob(db, models.JobFileExtract, fileID, "", userName, nil)
+			if jobErr != nil {
+				logrus.Errorf("创建文件 %s 的解析任务报错 %v", fileID, jobErr)
+			} else {
+				response["job_id"] = job.ID
 			}
 		}
-	}()
-
-	// 插入 files 表
-	err = db.InsertFile(uploadResp.ID, vectorStoreID, uploadResp.Bytes, fileID, "uploaded", "retrieval")
-	if err != nil {
-		logrus.Errorf("插入files表，retrieval的文件数据 %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "插入files表，retrieval的文件数据报错"})
+		c.JSON(http.StatusOK, response)
 		return
 	}
 
-	// 绑定文件到知识库
-	_, err = BindFilesToVectorStore(vectorStoreID, uploadResp.ID)
-	if err != nil {
-		logrus.Errorf("绑定文件到知识库报错 %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "绑定文件到知识库报错"})
-		return
-	}
+	enqueueKnowledgeUpload(c, db, fileID, vectorStoreID, userName, "文件已上传，已加入处理队列")
+	return
+}
 
-	// 更新 files 状态为 processing
-	err = db.UpdateFilesStatus(fileID, "processing")
+// enqueueKnowledgeUpload 创建文件加入知识库的后台任务，立即返回任务ID，进度通过 /api/jobs/:id 查询
+func enqueueKnowledgeUpload(c *gin.Context, db *dbop.Database, fileID, vectorStoreID, userName, status string) {
+	job, created, err := EnqueueJob(db, models.JobKnowledgeUpload, fileID, vectorStoreID, userName, nil)
 	if err != nil {
-		logrus.Errorf("更新files状态为processing %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新files状态为processing报错"})
+		logrus.Errorf("创建文件 %s 加入知识库 %s 的任务报错 %v", fileID, vectorStoreID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建后台任务报错"})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"status": "文件绑定到知识库，正常向量化中，可以在知识库点击获取向量化状态，获取向量化进度",
+	if !created {
+		status = "此文件正在加入知识库，请勿重复上传"
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"file_id":    fileID,
+		"job_id":     job.ID,
+		"job_status": job.Status,
+		"status":     status,
 	})
-	return
 }
 
 // 判断是否为文本文件的函数
@@ -0,0 +1,145 @@
+// knowledge_upload_job.go
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"openapi-cms/tool/extractor"
+	"openapi-cms/tool/rag"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// knowledgeUploadPollTimeout 任务中等待 StepFun 向量化完成的最长时间，超时后交给后台状态同步继续跟踪
+const knowledgeUploadPollTimeout = 2 * time.Minute
+
+// runKnowledgeUploadJob 把上传文件加入知识库。本地知识库在本地解析、切片并向量化；StepFun 知识库依次上传文件、
+// 绑定到知识库并查询向量化状态。已完成的步骤记录在 files 表中，重试时从中断的步骤继续
+func runKnowledgeUploadJob(ctx context.Context, db *dbop.Database, job *models.Job, report func(stage string, progress int)) (interface{}, error) {
+	record, err := db.GetUploadedFileByID(job.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("查询上传文件失败: %w", err)
+	}
+	if record == nil {
+		return nil, Permanent(fmt.Errorf("上传文件 %s 不存在", job.FileID))
+	}
+	kb, err := db.GetKnowledgeBaseByID(job.VectorStoreID)
+	if err != nil {
+		return nil, fmt.Errorf("查询知识库失败: %w", err)
+	}
+	if kb == nil {
+		return nil, Permanent(fmt.Errorf("知识库 %s 不存在", job.VectorStoreID))
+	}
+	filePath := filepath.Join(getUploadDir(), record.FilePath)
+	if kb.ModelOwner == models.ModelOwnerLocal {
+		return indexLocalKnowledgeFile(ctx, db, job, filePath, report)
+	}
+
+	existing, err := db.GetKnowledgeBaseFile(job.VectorStoreID, job.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("查询知识库文件失败: %w", err)
+	}
+	var stepFileID, status string
+	if existing != nil {
+		stepFileID, status = existing.VectorFileID, existing.Status
+		if status == "failed" {
+			return nil, Permanent(fmt.Errorf("文件此前在知识库中向量化失败，请先从知识库移除后重新上传"))
+		}
+	} else {
+		report("upload", 10)
+		uploadResp, err := UploadFileToStepFunWithExtract(filePath, record.Filename, "retrieval")
+		if err != nil {
+			return nil, fmt.Errorf("上传文件到stepfun报错: %w", err)
+		}
+		if err := db.InsertFile(uploadResp.ID, job.VectorStoreID, uploadResp.Bytes, job.FileID, "uploaded", "retrieval"); err != nil {
+			return nil, fmt.Errorf("插入files表，retrieval的文件数据报错: %w", err)
+		}
+		stepFileID, status = uploadResp.ID, "uploaded"
+	}
+
+	if status == "uploaded" {
+		report("bind", 40)
+		if _, err := BindFilesToVectorStore(job.VectorStoreID, stepFileID); err != nil {
+			return nil, fmt.Errorf("绑定文件到知识库报错: %w", err)
+		}
+		if err := db.UpdateFileStatusByID(stepFileID, "processing"); err != nil {
+			return nil, err
+		}
+		status = "processing"
+	}
+	if status == "processing" {
+		report("poll", 70)
+		if status, err = pollKnowledgeFile(ctx, db, stepFileID, job.FileID); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"step_file_id": stepFileID, "status": status}, nil
+}
+
+// pollKnowledgeFile 查询 StepFun 文件的向量化状态直到完成、失败或超时。超时不算失败，返回 processing，
+// 之后由后台状态同步更新
+func pollKnowledgeFile(ctx context.Context, db *dbop.Database, stepFileID, uploadedFileID string) (string, error) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	timeout := time.After(knowledgeUploadPollTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			logrus.Infof("文件 %s 仍在向量化，交给后台状态同步继续跟踪", stepFileID)
+			return "processing", nil
+		case <-ticker.C:
+			statusResp, err := GetStepFunFile(ctx, stepFileID)
+			if err != nil {
+				logrus.Warnf("查询文件 %s 的状态失败: %v", stepFileID, err)
+				continue
+			}
+			status, uploadedStatus, reason := classifyFileStatus(statusResp)
+			if status == "" {
+				continue
+			}
+			if err := db.SaveFileStatusCheck(stepFileID, uploadedFileID, statusResp.Status, status, uploadedStatus, reason); err != nil {
+				return "", err
+			}
+			if status == "failed" {
+				return "", Permanent(fmt.Errorf("文件向量化失败: %s", reason))
+			}
+			return status, nil
+		}
+	}
+}
+
+// indexLocalKnowledgeFile 把文件切片、向量化后存入本地知识库，并在 files 表中记录为已完成
+func indexLocalKnowledgeFile(ctx context.Context, db *dbop.Database, job *models.Job, filePath string, report func(stage string, progress int)) (interface{}, error) {
+	embedder, err := rag.NewEmbedder()
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("创建向量化服务报错: %w", err))
+	}
+	report("extract", 30)
+	chunks, textBytes, err := rag.IndexFile(ctx, db, embedder, job.VectorStoreID, job.FileID, filePath)
+	if err != nil {
+		if errors.Is(err, extractor.ErrUnsupported) || errors.Is(err, extractor.ErrNoText) {
+			return nil, Permanent(err)
+		}
+		return nil, fmt.Errorf("文件向量化失败: %w", err)
+	}
+	report("index", 90)
+	existing, err := db.GetKnowledgeBaseFile(job.VectorStoreID, job.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("查询知识库文件失败: %w", err)
+	}
+	if existing == nil {
+		if err := db.InsertFile("local-"+uuid.New().String(), job.VectorStoreID, textBytes, job.FileID, "completed", "retrieval"); err != nil {
+			return nil, fmt.Errorf("插入files表，retrieval的文件数据报错: %w", err)
+		}
+	}
+	logrus.Infof("文件 %s 已加入本地知识库 %s，共 %d 个切片", job.FileID, job.VectorStoreID, chunks)
+	return map[string]interface{}{"chunks": chunks, "status": "completed"}, nil
+}
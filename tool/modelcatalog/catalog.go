@@ -0,0 +1,238 @@
+// tool/modelcatalog/catalog.go
+
+package modelcatalog
+
+import (
+	_ "embed"
+	"fmt"
+	"openapi-cms/models"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultCatalogYAML 内置的模型目录，未设置 MODEL_CATALOG_FILE 时使用
+//
+//go:embed models.yaml
+var defaultCatalogYAML []byte
+
+// DefaultTier 请求未指定 performance_level 且目录中没有配置厂商默认档位时使用的档位
+const DefaultTier = "balanced"
+
+// Catalog 模型目录，按厂商管理可用模型及选择规则
+type Catalog struct {
+	models            []models.ModelSpec
+	byName            map[string]models.ModelSpec
+	defaultTiers      map[string]string
+	fallbackProviders map[string][]string
+}
+
+type catalogFile struct {
+	DefaultTiers      map[string]string   `yaml:"default_tiers"`      // 各厂商未指定 performance_level 时使用的档位
+	FallbackProviders map[string][]string `yaml:"fallback_providers"` // 各厂商失败后依次切换的厂商
+	Models            []models.ModelSpec  `yaml:"models"`
+}
+
+var (
+	defaultCatalog *Catalog
+	defaultMu      sync.Mutex
+)
+
+// Parse 解析 YAML 格式的模型目录
+func Parse(data []byte) (*Catalog, error) {
+	var file catalogFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析模型目录失败: %w", err)
+	}
+	if len(file.Models) == 0 {
+		return nil, fmt.Errorf("模型目录为空")
+	}
+
+	catalog := &Catalog{
+		byName:            make(map[string]models.ModelSpec, len(file.Models)),
+		defaultTiers:      file.DefaultTiers,
+		fallbackProviders: file.FallbackProviders,
+	}
+	for _, spec := range file.Models {
+		if spec.Name == "" || spec.Provider == "" {
+			return nil, fmt.Errorf("模型目录中存在缺少 name 或 provider 的条目")
+		}
+		if _, exists := catalog.byName[spec.Name]; exists {
+			return nil, fmt.Errorf("模型 %s 重复定义", spec.Name)
+		}
+		if spec.MaxInputTokens <= 0 {
+			return nil, fmt.Errorf("模型 %s 未设置 max_input_tokens", spec.Name)
+		}
+		if len(spec.Modalities) == 0 {
+			spec.Modalities = []string{"text"}
+		}
+		if spec.DisplayName == "" {
+			spec.DisplayName = spec.Name
+		}
+		catalog.models = append(catalog.models, spec)
+		catalog.byName[spec.Name] = spec
+	}
+	return catalog, nil
+}
+
+// Load 加载模型目录：设置了 MODEL_CATALOG_FILE 时读取该文件，否则使用内置目录
+func Load() (*Catalog, error) {
+	path := os.Getenv("MODEL_CATALOG_FILE")
+	if path == "" {
+		return Parse(defaultCatalogYAML)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取模型目录文件 %s 失败: %w", path, err)
+	}
+	return Parse(data)
+}
+
+// Init 加载模型目录并设为默认目录，在服务启动时调用
+func Init() (*Catalog, error) {
+	catalog, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	defaultMu.Lock()
+	defaultCatalog = catalog
+	defaultMu.Unlock()
+	logrus.Infof("已加载模型目录，共 %d 个模型", len(catalog.models))
+	return catalog, nil
+}
+
+// Default 返回默认模型目录，未调用 Init 时使用内置目录
+func Default() *Catalog {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultCatalog == nil {
+		catalog, err := Parse(defaultCatalogYAML)
+		if err != nil {
+			// 内置目录随代码发布，解析失败属于编程错误
+			panic(err)
+		}
+		defaultCatalog = catalog
+	}
+	return defaultCatalog
+}
+
+// DefaultTier 返回厂商的默认档位
+func (c *Catalog) DefaultTier(provider string) string {
+	if tier, ok := c.defaultTiers[provider]; ok && tier != "" {
+		return tier
+	}
+	return DefaultTier
+}
+
+// FallbackProviders 返回厂商失败后依次切换的厂商
+func (c *Catalog) FallbackProviders(provider string) []string {
+	return c.fallbackProviders[provider]
+}
+
+// Get 按名称查找模型
+func (c *Catalog) Get(name string) (models.ModelSpec, bool) {
+	spec, ok := c.byName[name]
+	return spec, ok
+}
+
+// List 列出模型，provider 为空时返回全部
+func (c *Catalog) List(provider string) []models.ModelSpec {
+	result := []models.ModelSpec{}
+	for _, spec := range c.models {
+		if provider == "" || spec.Provider == provider {
+			result = append(result, spec)
+		}
+	}
+	return result
+}
+
+// Candidates 按选择顺序返回候选模型：先是指定档位中支持该输入类型的模型，再是其他档位的模型，
+// 同一组内按最大输入从小到大排列，使短对话优先使用小窗口（更便宜）的模型
+func (c *Catalog) Candidates(provider, tier, fileType string) []models.ModelSpec {
+	if tier == "" {
+		tier = c.DefaultTier(provider)
+	}
+	modality := Modality(fileType)
+	var inTier, others []models.ModelSpec
+	for _, spec := range c.models {
+		if spec.Provider != provider || !spec.SupportsModality(modality) {
+			continue
+		}
+		if spec.InTier(tier) {
+			inTier = append(inTier, spec)
+		} else {
+			others = append(others, spec)
+		}
+	}
+	byInput := func(list []models.ModelSpec) {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].MaxInputTokens < list[j].MaxInputTokens
+		})
+	}
+	byInput(inTier)
+	byInput(others)
+	return append(inTier, others...)
+}
+
+// Select 按档位、文件类型选择第一个能容纳输入的模型。fits 判断输入是否在模型的 max_input_tokens 之内，
+// 调用方可以在其中结合本地估算和远程计数
+func (c *Catalog) Select(provider, tier, fileType string, fits func(spec models.ModelSpec) bool) (models.ModelSpec, error) {
+	candidates := c.Candidates(provider, tier, fileType)
+	if len(candidates) == 0 {
+		return models.ModelSpec{}, fmt.Errorf("%s 没有支持 %s 输入的模型", provider, Modality(fileType))
+	}
+	for _, spec := range candidates {
+		if fits(spec) {
+			return spec, nil
+		}
+	}
+	largest := candidates[0]
+	for _, spec := range candidates {
+		if spec.MaxInputTokens > largest.MaxInputTokens {
+			largest = spec
+		}
+	}
+	return models.ModelSpec{}, fmt.Errorf("输入内容超过了 %s 的最大限制 %d tokens", largest.Name, largest.MaxInputTokens)
+}
+
+// Fallbacks 返回 current 之后仍能容纳 estimate 个输入 token 的候选模型，按选择顺序排列
+func (c *Catalog) Fallbacks(provider, current, tier, fileType string, estimate int) []string {
+	var names []string
+	found := false
+	for _, spec := range c.Candidates(provider, tier, fileType) {
+		if spec.Name == current {
+			found = true
+			continue
+		}
+		if found && estimate <= spec.MaxInputTokens {
+			names = append(names, spec.Name)
+		}
+	}
+	return names
+}
+
+// Prices 返回目录中配置了价格的模型价格表（元/百万 token）
+func (c *Catalog) Prices() map[string]models.ModelPrice {
+	prices := make(map[string]models.ModelPrice)
+	for _, spec := range c.models {
+		if spec.PromptPrice > 0 || spec.CompletionPrice > 0 {
+			prices[spec.Name] = models.ModelPrice{Prompt: spec.PromptPrice, Completion: spec.CompletionPrice}
+		}
+	}
+	return prices
+}
+
+// Modality 将请求的 file_type 转换为模型目录中的输入类型
+func Modality(fileType string) string {
+	switch fileType {
+	case "img":
+		return "image"
+	case "video":
+		return "video"
+	default:
+		return "text"
+	}
+}
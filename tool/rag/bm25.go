@@ -0,0 +1,76 @@
+// tool/rag/bm25.go
+
+package rag
+
+import (
+	"math"
+	"openapi-cms/models"
+)
+
+// BM25 参数，取常用的默认值
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// bm25Scores 计算每个切片对查询的 BM25 分数，词项与向量化使用同一套切分规则，
+// 英文、数字按整词匹配，产品名、型号等可以精确命中
+func bm25Scores(query string, chunks []models.KnowledgeChunk) []float64 {
+	scores := make([]float64, len(chunks))
+	queryTerms := uniqueTerms(Terms(query))
+	if len(queryTerms) == 0 || len(chunks) == 0 {
+		return scores
+	}
+
+	frequencies := make([]map[string]int, len(chunks))
+	lengths := make([]float64, len(chunks))
+	documentFrequency := make(map[string]int, len(queryTerms))
+	totalLength := 0
+	for i, chunk := range chunks {
+		terms := Terms(chunk.Content)
+		lengths[i] = float64(len(terms))
+		totalLength += len(terms)
+		tf := make(map[string]int)
+		for _, term := range terms {
+			tf[term]++
+		}
+		frequencies[i] = tf
+		for _, term := range queryTerms {
+			if tf[term] > 0 {
+				documentFrequency[term]++
+			}
+		}
+	}
+	averageLength := float64(totalLength) / float64(len(chunks))
+	if averageLength == 0 {
+		return scores
+	}
+
+	n := float64(len(chunks))
+	for i, tf := range frequencies {
+		length := lengths[i]
+		for _, term := range queryTerms {
+			f := float64(tf[term])
+			if f == 0 {
+				continue
+			}
+			df := float64(documentFrequency[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			scores[i] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*length/averageLength))
+		}
+	}
+	return scores
+}
+
+// uniqueTerms 去掉重复的词项，保持原有顺序
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	result := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			result = append(result, term)
+		}
+	}
+	return result
+}
@@ -0,0 +1,139 @@
+// tool/rag/chunker.go
+
+package rag
+
+import (
+	"openapi-cms/tool/extractor"
+	"strings"
+	"unicode/utf8"
+)
+
+// 默认的切片参数，按字符（rune）计算
+const (
+	DefaultChunkSize    = 800
+	DefaultChunkOverlap = 100
+)
+
+// Chunk 切片结果
+type Chunk struct {
+	Index    int
+	Location string // 切片所在的页、工作表或幻灯片，没有标记时为空
+	Content  string
+}
+
+// Split 把 extractor.Extract 的结果切分为不超过 size 个字符的切片。切片不跨页、工作表和幻灯片，
+// 优先在段落、换行和句末标点处断开，同一段内相邻切片重叠 overlap 个字符，避免上下文在边界处丢失
+func Split(text string, size, overlap int) []Chunk {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap < 0 || overlap >= size/2 {
+		overlap = size / 8
+	}
+
+	var chunks []Chunk
+	for _, section := range extractor.Sections(text) {
+		for _, content := range splitSection(section.Text, size, overlap) {
+			chunks = append(chunks, Chunk{Index: len(chunks), Location: section.Location, Content: content})
+		}
+	}
+	return chunks
+}
+
+// splitSection 按段落累积，超过 size 时输出一个切片，并以上一个切片的末尾作为下一个切片的开头
+func splitSection(text string, size, overlap int) []string {
+	// 片段接在重叠部分和段落分隔符之后也不能超过 size
+	limit := size - overlap - len("\n\n")
+	if limit < 1 {
+		limit = 1
+	}
+	var pieces []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if utf8.RuneCountInString(paragraph) <= limit {
+			pieces = append(pieces, paragraph)
+			continue
+		}
+		pieces = append(pieces, splitLong(paragraph, limit)...)
+	}
+
+	var chunks []string
+	var current strings.Builder
+	currentLen, fresh := 0, true
+	for _, piece := range pieces {
+		pieceLen := utf8.RuneCountInString(piece)
+		if !fresh && currentLen+2+pieceLen > size {
+			chunk := current.String()
+			chunks = append(chunks, chunk)
+			current.Reset()
+			tail := overlapTail(chunk, overlap)
+			current.WriteString(tail)
+			currentLen, fresh = utf8.RuneCountInString(tail), true
+		}
+		if currentLen > 0 {
+			current.WriteString("\n\n")
+			currentLen += 2
+		}
+		current.WriteString(piece)
+		currentLen += pieceLen
+		fresh = false
+	}
+	if !fresh {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// splitLong 把超长的段落切为不超过 limit 个字符的片段，优先在换行和句末标点处断开
+func splitLong(text string, limit int) []string {
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > limit {
+		cut := limit
+		for i := limit - 1; i >= limit/2; i-- {
+			if isBreak(runes[i]) {
+				cut = i + 1
+				break
+			}
+		}
+		if part := strings.TrimSpace(string(runes[:cut])); part != "" {
+			parts = append(parts, part)
+		}
+		runes = runes[cut:]
+	}
+	if part := strings.TrimSpace(string(runes)); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// overlapTail 取切片末尾约 overlap 个字符，尽量从句子开头截取
+func overlapTail(chunk string, overlap int) string {
+	if overlap <= 0 {
+		return ""
+	}
+	runes := []rune(chunk)
+	if len(runes) <= overlap {
+		return ""
+	}
+	start := len(runes) - overlap
+	for i := start; i < len(runes)-overlap/2; i++ {
+		if isBreak(runes[i]) {
+			start = i + 1
+			break
+		}
+	}
+	return strings.TrimSpace(string(runes[start:]))
+}
+
+// isBreak 判断是否为可以断开切片的位置：换行或句末标点
+func isBreak(r rune) bool {
+	switch r {
+	case '\n', '。', '！', '？', '；', '.', '!', '?', ';':
+		return true
+	}
+	return false
+}
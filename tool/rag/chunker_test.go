@@ -0,0 +1,172 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// paragraphs 生成 n 个段落，每段由若干以句号结尾的句子组成
+func paragraphs(n int) string {
+	var parts []string
+	for i := 0; i < n; i++ {
+		parts = append(parts, fmt.Sprintf("第%d段的第一句话。这是第%d段的第二句话！第%d段结束？", i+1, i+1, i+1))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func TestSplitShortText(t *testing.T) {
+	chunks := Split("  只有一段。  ", 100, 10)
+	if len(chunks) != 1 || chunks[0].Content != "只有一段。" || chunks[0].Index != 0 || chunks[0].Location != "" {
+		t.Fatalf("got %+v", chunks)
+	}
+	if chunks := Split("\n\n  \n\n", 100, 10); len(chunks) != 0 {
+		t.Errorf("blank text: got %+v", chunks)
+	}
+}
+
+// TestSplitBoundaries 切片不超过 size，编号连续，拼起来覆盖全部段落
+func TestSplitBoundaries(t *testing.T) {
+	text := paragraphs(30)
+	for _, tt := range []struct{ size, overlap int }{{60, 10}, {100, 20}, {200, 0}, {37, 5}} {
+		t.Run(fmt.Sprintf("size=%d overlap=%d", tt.size, tt.overlap), func(t *testing.T) {
+			chunks := Split(text, tt.size, tt.overlap)
+			if len(chunks) < 2 {
+				t.Fatalf("expected several chunks, got %d", len(chunks))
+			}
+			joined := ""
+			for i, chunk := range chunks {
+				if chunk.Index != i {
+					t.Errorf("chunk %d has index %d", i, chunk.Index)
+				}
+				if n := utf8.RuneCountInString(chunk.Content); n > tt.size {
+					t.Errorf("chunk %d has %d runes, size is %d", i, n, tt.size)
+				}
+				if chunk.Content != strings.TrimSpace(chunk.Content) {
+					t.Errorf("chunk %d is not trimmed: %q", i, chunk.Content)
+				}
+				joined += chunk.Content
+			}
+			for _, paragraph := range strings.Split(text, "\n\n") {
+				// 超长段落会被拆开，按句子检查
+				for _, sentence := range strings.SplitAfter(paragraph, "。") {
+					if sentence != "" && !strings.Contains(joined, sentence) {
+						t.Errorf("sentence %q is missing from the chunks", sentence)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestSplitOverlap 相邻切片的开头是上一个切片末尾不超过 overlap 个字符的内容，并尽量从句子开头截取
+func TestSplitOverlap(t *testing.T) {
+	const size, overlap = 80, 20
+	chunks := Split(paragraphs(20), size, overlap)
+	if len(chunks) < 3 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	for i := 1; i < len(chunks); i++ {
+		head := strings.SplitN(chunks[i].Content, "\n\n", 2)[0]
+		if !strings.HasSuffix(chunks[i-1].Content, head) {
+			t.Errorf("chunk %d does not start with the tail of chunk %d: %q / %q", i, i-1, head, chunks[i-1].Content)
+		}
+		if n := utf8.RuneCountInString(head); n > overlap && strings.Contains(chunks[i].Content, "\n\n") {
+			t.Errorf("chunk %d overlap has %d runes, want at most %d", i, n, overlap)
+		}
+	}
+
+	// 重叠部分从句末标点之后开始
+	text := "第一句话的内容比较长一些。第二句。\n\n下一段的内容也比较长一些。"
+	chunks = Split(text, 30, 6)
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks: %+v", len(chunks), chunks)
+	}
+	if want := "第二句。\n\n下一段的内容也比较长一些。"; chunks[1].Content != want {
+		t.Errorf("second chunk = %q, want %q", chunks[1].Content, want)
+	}
+
+	// overlap 为 0 时切片之间没有重复内容
+	text = paragraphs(20)
+	var contents []string
+	for _, chunk := range Split(text, size, 0) {
+		contents = append(contents, chunk.Content)
+	}
+	if joined := strings.Join(contents, "\n\n"); joined != text {
+		t.Errorf("chunks without overlap do not join back to the text:\n%s", joined)
+	}
+}
+
+// TestSplitLongParagraph 超长段落优先在句末标点处断开，没有标点时按长度硬切
+func TestSplitLongParagraph(t *testing.T) {
+	sentence := "这是一个完整的句子。"
+	chunks := Split(strings.Repeat(sentence, 10), 40, 0)
+	for i, chunk := range chunks {
+		if !strings.HasSuffix(chunk.Content, "。") {
+			t.Errorf("chunk %d does not end at a sentence break: %q", i, chunk.Content)
+		}
+	}
+
+	// 硬切的片段加上重叠部分和段落分隔符也不能超过 size
+	for _, chunk := range Split(strings.Repeat("x", 95), 20, 5) {
+		if n := utf8.RuneCountInString(chunk.Content); n > 20 {
+			t.Errorf("chunk with overlap has %d runes: %q", n, chunk.Content)
+		}
+	}
+
+	chunks = Split(strings.Repeat("x", 95), 20, 0)
+	total := 0
+	for i, chunk := range chunks {
+		n := utf8.RuneCountInString(chunk.Content)
+		if n > 20 {
+			t.Errorf("chunk %d has %d runes", i, n)
+		}
+		total += strings.Count(chunk.Content, "x")
+	}
+	if total != 95 {
+		t.Errorf("chunks contain %d characters, want 95", total)
+	}
+}
+
+// TestSplitSections 切片不跨页，并记录所在的页
+func TestSplitSections(t *testing.T) {
+	text := "=== 第 1 页 ===\n第一页的内容。\n=== 第 2 页 ===\n第二页的内容。"
+	chunks := Split(text, 100, 10)
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks: %+v", len(chunks), chunks)
+	}
+	for i, want := range []Chunk{
+		{Index: 0, Location: "第 1 页", Content: "第一页的内容。"},
+		{Index: 1, Location: "第 2 页", Content: "第二页的内容。"},
+	} {
+		if chunks[i] != want {
+			t.Errorf("chunk %d = %+v, want %+v", i, chunks[i], want)
+		}
+	}
+}
+
+// TestSplitDefaults 非法参数回退为默认值
+func TestSplitDefaults(t *testing.T) {
+	text := strings.Repeat("句子。", 500)
+	for _, chunk := range Split(text, 0, -1) {
+		if n := utf8.RuneCountInString(chunk.Content); n > DefaultChunkSize {
+			t.Errorf("chunk has %d runes, default size is %d", n, DefaultChunkSize)
+		}
+	}
+	// overlap 不小于 size 的一半时改为 size/8
+	chunks := Split(text, 40, 30)
+	for i := 1; i < len(chunks); i++ {
+		head := strings.SplitN(chunks[i].Content, "\n\n", 2)[0]
+		if n := utf8.RuneCountInString(head); n > 40/8 {
+			t.Errorf("chunk %d overlap has %d runes, want at most %d", i, n, 40/8)
+		}
+	}
+	for _, size := range []int{1, 2, 3} {
+		for _, chunk := range Split("ab\n\ncd", size, 0) {
+			if n := utf8.RuneCountInString(chunk.Content); n > size {
+				t.Errorf("size %d: chunk %q has %d runes", size, chunk.Content, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// tool/rag/embedder.go
+
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Embedder 把文本转为向量。Name 会和向量一起保存，更换模型后旧的向量不再参与检索
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// defaultHashDimensions 哈希向量的维度
+const defaultHashDimensions = 512
+
+// embedders 按 EMBEDDING_PROVIDER 注册的向量化实现
+var embedders = map[string]func() (Embedder, error){
+	"hash":   func() (Embedder, error) { return NewHashEmbedder(defaultHashDimensions), nil },
+	"openai": newOpenAIEmbedder,
+}
+
+// NewEmbedder 按环境变量 EMBEDDING_PROVIDER 创建向量化实现，默认使用不依赖外部服务的哈希向量
+func NewEmbedder() (Embedder, error) {
+	provider := os.Getenv("EMBEDDING_PROVIDER")
+	if provider == "" {
+		provider = "hash"
+	}
+	newEmbedder, ok := embedders[provider]
+	if !ok {
+		return nil, fmt.Errorf("不支持的向量化服务: %s", provider)
+	}
+	return newEmbedder()
+}
+
+// hashEmbedder 对词项做特征哈希得到向量。结果确定、不需要模型，适合测试和没有向量化服务的部署，
+// 效果接近关键词匹配
+type hashEmbedder struct {
+	dimensions int
+}
+
+// NewHashEmbedder 创建指定维度的哈希向量化实现
+func NewHashEmbedder(dimensions int) Embedder {
+	return &hashEmbedder{dimensions: dimensions}
+}
+
+func (e *hashEmbedder) Name() string {
+	return fmt.Sprintf("hash-%d", e.dimensions)
+}
+
+func (e *hashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.dimensions)
+		for _, term := range Terms(text) {
+			h := fnv.New32a()
+			h.Write([]byte(term))
+			sum := h.Sum32()
+			// 用哈希的最高位决定符号，减少冲突带来的偏差
+			if sum&(1<<31) != 0 {
+				vector[int(sum%uint32(e.dimensions))]--
+			} else {
+				vector[int(sum%uint32(e.dimensions))]++
+			}
+		}
+		vectors[i] = normalizeVector(vector)
+	}
+	return vectors, nil
+}
+
+// openAIEmbeddingBatch 每次请求的最大文本数
+const openAIEmbeddingBatch = 32
+
+// openAIEmbedder 调用兼容 OpenAI /embeddings 接口的向量化服务
+type openAIEmbedder struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+// newOpenAIEmbedder 读取 EMBEDDING_API_URL、EMBEDDING_API_KEY、EMBEDDING_MODEL，
+// 地址和密钥未配置时使用 OPENAI_API_URL、OPENAI_API_KEY
+func newOpenAIEmbedder() (Embedder, error) {
+	e := &openAIEmbedder{
+		url:    os.Getenv("EMBEDDING_API_URL"),
+		apiKey: os.Getenv("EMBEDDING_API_KEY"),
+		model:  os.Getenv("EMBEDDING_MODEL"),
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+	if e.url == "" {
+		e.url = os.Getenv("OPENAI_API_URL")
+	}
+	if e.apiKey == "" {
+		e.apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if e.model == "" {
+		e.model = "text-embedding-3-small"
+	}
+	if e.url == "" || e.apiKey == "" {
+		return nil, fmt.Errorf("向量化服务的地址或密钥未配置")
+	}
+	e.url += "/embeddings"
+	return e, nil
+}
+
+func (e *openAIEmbedder) Name() string {
+	return e.model
+}
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += openAIEmbeddingBatch {
+		end := start + openAIEmbeddingBatch
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := e.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// embedBatch 请求一批文本的向量，按返回的 index 还原顺序
+func (e *openAIEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+e.apiKey)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求向量化服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("向量化服务返回 %d: %s", resp.StatusCode, msg)
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析向量化响应失败: %w", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("向量化服务返回了 %d 个向量，请求了 %d 个", len(result.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, item := range result.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("向量化响应中的 index %d 越界", item.Index)
+		}
+		vectors[item.Index] = normalizeVector(item.Embedding)
+	}
+	return vectors, nil
+}
+
+// normalizeVector 把向量归一化为单位长度，检索时点积即为余弦相似度
+func normalizeVector(vector []float32) []float32 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return vector
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vector {
+		vector[i] /= norm
+	}
+	return vector
+}
@@ -0,0 +1,162 @@
+// tool/rag/index.go
+
+package rag
+
+import (
+	"context"
+	"fmt"
+	"openapi-cms/dbop"
+	"openapi-cms/models"
+	"openapi-cms/tool/extractor"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IndexFile 在本地解析文件、切片并向量化，替换文件在知识库中已有的切片。返回切片数和提取出的文本字节数
+func IndexFile(ctx context.Context, db *dbop.Database, embedder Embedder, vectorStoreID, fileID, path string) (int, int, error) {
+	text, err := extractor.ExtractFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	chunks := Split(text, DefaultChunkSize, DefaultChunkOverlap)
+	contents := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		contents[i] = chunk.Content
+	}
+	vectors, err := embedder.Embed(ctx, contents)
+	if err != nil {
+		return 0, 0, fmt.Errorf("向量化失败: %w", err)
+	}
+
+	records := make([]models.KnowledgeChunk, len(chunks))
+	for i, chunk := range chunks {
+		records[i] = models.KnowledgeChunk{
+			VectorStoreID:  vectorStoreID,
+			FileID:         fileID,
+			ChunkIndex:     chunk.Index,
+			Location:       chunk.Location,
+			Content:        chunk.Content,
+			Embedding:      vectors[i],
+			EmbeddingModel: embedder.Name(),
+		}
+	}
+	if err := db.ReplaceKnowledgeChunks(vectorStoreID, fileID, records); err != nil {
+		return 0, 0, fmt.Errorf("保存切片失败: %w", err)
+	}
+	return len(records), len(text), nil
+}
+
+// 检索参数
+const (
+	rrfK            = 60 // 倒数排名融合的平滑常数
+	rerankCandidate = 4  // 启用重排序时，取 topK 的几倍作为候选
+	minRerankInput  = 20 // 重排序候选的最少个数
+)
+
+// Search 混合检索：分别按 BM25 关键词匹配和向量相似度对知识库的切片排名，用倒数排名融合（RRF）合并，
+// 配置了 reranker 时再对融合后的候选重排序。返回最相关的 topK 个切片，按 Score 从高到低排列。
+// 切片的向量与当前 embedder 不一致（更换过模型）时只参与关键词匹配
+func Search(ctx context.Context, db *dbop.Database, embedder Embedder, reranker Reranker, vectorStoreID, query string, topK int) ([]models.ScoredChunk, error) {
+	chunks, err := db.ListKnowledgeChunks(vectorStoreID)
+	if err != nil {
+		return nil, fmt.Errorf("读取切片失败: %w", err)
+	}
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+	return rankChunks(ctx, embedder, reranker, query, chunks, topK)
+}
+
+// rankChunks 对已读取的切片做混合排名，返回前 topK 个
+func rankChunks(ctx context.Context, embedder Embedder, reranker Reranker, query string, chunks []models.KnowledgeChunk, topK int) ([]models.ScoredChunk, error) {
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("向量化查询失败: %w", err)
+	}
+
+	results := make([]models.ScoredChunk, len(chunks))
+	keywordScores := bm25Scores(query, chunks)
+	var keywordRanked, vectorRanked []int
+	for i, chunk := range chunks {
+		results[i] = models.ScoredChunk{KnowledgeChunk: chunk, KeywordScore: keywordScores[i]}
+		if keywordScores[i] > 0 {
+			keywordRanked = append(keywordRanked, i)
+		}
+		if chunk.EmbeddingModel == embedder.Name() {
+			results[i].VectorScore = dot(vectors[0], chunk.Embedding)
+			if results[i].VectorScore > 0 {
+				vectorRanked = append(vectorRanked, i)
+			}
+		}
+	}
+	sort.SliceStable(keywordRanked, func(a, b int) bool {
+		return results[keywordRanked[a]].KeywordScore > results[keywordRanked[b]].KeywordScore
+	})
+	sort.SliceStable(vectorRanked, func(a, b int) bool {
+		return results[vectorRanked[a]].VectorScore > results[vectorRanked[b]].VectorScore
+	})
+	for _, ranked := range [][]int{keywordRanked, vectorRanked} {
+		for rank, i := range ranked {
+			results[i].Score += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	// 关键词和向量都没有命中的切片不返回
+	candidates := make([]models.ScoredChunk, 0, len(results))
+	for _, result := range results {
+		if result.Score > 0 {
+			candidates = append(candidates, result)
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Score > candidates[b].Score })
+
+	if reranker != nil && len(candidates) > 1 {
+		limit := topK * rerankCandidate
+		if limit < minRerankInput {
+			limit = minRerankInput
+		}
+		if len(candidates) > limit {
+			candidates = candidates[:limit]
+		}
+		if err := rerank(ctx, reranker, query, candidates); err != nil {
+			// 重排序失败不影响检索，沿用融合后的排名
+			logrus.Warnf("重排序失败，使用融合排名: %v", err)
+		}
+	}
+	if topK > 0 && len(candidates) > topK {
+		candidates = candidates[:topK]
+	}
+	return candidates, nil
+}
+
+// rerank 用重排序分数替换候选的 Score 并重新排序
+func rerank(ctx context.Context, reranker Reranker, query string, candidates []models.ScoredChunk) error {
+	documents := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		documents[i] = candidate.Content
+	}
+	scores, err := reranker.Rerank(ctx, query, documents)
+	if err != nil {
+		return err
+	}
+	for i := range candidates {
+		score := scores[i]
+		candidates[i].RerankScore = &score
+		candidates[i].Score = score
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Score > candidates[b].Score })
+	return nil
+}
+
+// dot 计算两个单位向量的点积，维度不一致时返回 0
+func dot(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
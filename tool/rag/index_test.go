@@ -0,0 +1,206 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"math"
+	"openapi-cms/models"
+	"testing"
+)
+
+// embedChunks 用 embedder 为每段内容生成切片，编号与下标一致
+func embedChunks(t *testing.T, embedder Embedder, contents ...string) []models.KnowledgeChunk {
+	t.Helper()
+	vectors, err := embedder.Embed(context.Background(), contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := make([]models.KnowledgeChunk, len(contents))
+	for i, content := range contents {
+		chunks[i] = models.KnowledgeChunk{ChunkIndex: i, Content: content, Embedding: vectors[i], EmbeddingModel: embedder.Name()}
+	}
+	return chunks
+}
+
+func chunkIndexes(results []models.ScoredChunk) []int {
+	indexes := make([]int, len(results))
+	for i, result := range results {
+		indexes[i] = result.ChunkIndex
+	}
+	return indexes
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHashEmbedder(t *testing.T) {
+	embedder := NewHashEmbedder(64)
+	vectors, err := embedder.Embed(context.Background(), []string{"向量检索 test", "向量检索 test", "", "完全不同"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors[0]) != 64 {
+		t.Fatalf("got %d dimensions", len(vectors[0]))
+	}
+	if got := dot(vectors[0], vectors[0]); math.Abs(got-1) > 1e-6 {
+		t.Errorf("vector is not normalized: |v|² = %f", got)
+	}
+	if got := dot(vectors[0], vectors[1]); math.Abs(got-1) > 1e-6 {
+		t.Errorf("same text gives different vectors: %f", got)
+	}
+	if got := dot(vectors[0], vectors[2]); got != 0 {
+		t.Errorf("empty text similarity = %f, want 0", got)
+	}
+	if got := dot(vectors[0], vectors[1][:32]); got != 0 {
+		t.Errorf("dimension mismatch similarity = %f, want 0", got)
+	}
+}
+
+// TestRankChunksTopK 命中查询词越多的切片排名越高，只返回 topK 个，没有命中的切片不返回
+func TestRankChunksTopK(t *testing.T) {
+	embedder := NewHashEmbedder(defaultHashDimensions)
+	chunks := embedChunks(t, embedder,
+		"lambda mu nu xi",
+		"alpha beta zeta eta",
+		"alpha beta gamma delta",
+		"alpha theta iota kappa",
+		"alpha beta gamma epsilon",
+	)
+	query := "alpha beta gamma delta"
+
+	results, err := rankChunks(context.Background(), embedder, nil, query, chunks, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := chunkIndexes(results), []int{2, 4, 1, 3}; !equalInts(got, want) {
+		t.Fatalf("ranking = %v, want %v", got, want)
+	}
+	queryVector, _ := embedder.Embed(context.Background(), []string{query})
+	for i, result := range results {
+		if i > 0 && result.Score > results[i-1].Score {
+			t.Errorf("result %d score %f is above result %d score %f", i, result.Score, i-1, results[i-1].Score)
+		}
+		if i > 0 && result.VectorScore > results[i-1].VectorScore {
+			t.Errorf("result %d vector score %f is above result %d", i, result.VectorScore, i-1)
+		}
+		if want := dot(queryVector[0], result.Embedding); result.VectorScore != want {
+			t.Errorf("result %d vector score = %f, want %f", i, result.VectorScore, want)
+		}
+		if result.RerankScore != nil {
+			t.Errorf("result %d has a rerank score without a reranker", i)
+		}
+	}
+
+	results, err = rankChunks(context.Background(), embedder, nil, query, chunks, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := chunkIndexes(results), []int{2, 4}; !equalInts(got, want) {
+		t.Errorf("top 2 = %v, want %v", got, want)
+	}
+
+	results, err = rankChunks(context.Background(), embedder, nil, "omega", chunks, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("unmatched query returned %v", chunkIndexes(results))
+	}
+}
+
+// TestRankChunksOtherEmbeddingModel 向量来自其它模型的切片只参与关键词匹配
+func TestRankChunksOtherEmbeddingModel(t *testing.T) {
+	embedder := NewHashEmbedder(defaultHashDimensions)
+	chunks := embedChunks(t, embedder, "alpha beta", "alpha gamma")
+	chunks[0].EmbeddingModel = "other-model"
+
+	results, err := rankChunks(context.Background(), embedder, nil, "alpha beta", chunks, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for _, result := range results {
+		if result.ChunkIndex == 0 && (result.VectorScore != 0 || result.KeywordScore == 0) {
+			t.Errorf("chunk from another model: vector %f keyword %f", result.VectorScore, result.KeywordScore)
+		}
+	}
+}
+
+// fakeReranker 按 scores 返回分数，err 不为空时返回错误
+type fakeReranker struct {
+	scores map[string]float64
+	err    error
+}
+
+func (r *fakeReranker) Name() string {
+	return "fake"
+}
+
+func (r *fakeReranker) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	scores := make([]float64, len(documents))
+	for i, document := range documents {
+		scores[i] = r.scores[document]
+	}
+	return scores, nil
+}
+
+func TestRankChunksRerank(t *testing.T) {
+	embedder := NewHashEmbedder(defaultHashDimensions)
+	contents := []string{"alpha beta gamma", "alpha beta", "alpha"}
+	chunks := embedChunks(t, embedder, contents...)
+	query := "alpha beta gamma"
+
+	reranker := &fakeReranker{scores: map[string]float64{contents[0]: 0.1, contents[1]: 0.2, contents[2]: 0.9}}
+	results, err := rankChunks(context.Background(), embedder, reranker, query, chunks, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := chunkIndexes(results), []int{2, 1}; !equalInts(got, want) {
+		t.Fatalf("reranked top 2 = %v, want %v", got, want)
+	}
+	for _, result := range results {
+		if result.RerankScore == nil || *result.RerankScore != result.Score {
+			t.Errorf("chunk %d: rerank score %v, score %f", result.ChunkIndex, result.RerankScore, result.Score)
+		}
+	}
+
+	// 重排序失败时沿用融合排名
+	results, err = rankChunks(context.Background(), embedder, &fakeReranker{err: errors.New("unavailable")}, query, chunks, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := chunkIndexes(results), []int{0, 1}; !equalInts(got, want) {
+		t.Errorf("fallback top 2 = %v, want %v", got, want)
+	}
+}
+
+func TestBM25Scores(t *testing.T) {
+	chunks := []models.KnowledgeChunk{
+		{Content: "产品型号 X100 的说明"},
+		{Content: "产品介绍"},
+		{Content: "无关内容"},
+	}
+	scores := bm25Scores("X100 产品", chunks)
+	if !(scores[0] > scores[1] && scores[1] > 0 && scores[2] == 0) {
+		t.Errorf("scores = %v", scores)
+	}
+	for _, score := range bm25Scores("", chunks) {
+		if score != 0 {
+			t.Errorf("empty query scored %f", score)
+		}
+	}
+}
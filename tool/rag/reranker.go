@@ -0,0 +1,111 @@
+// tool/rag/reranker.go
+
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Reranker 对候选切片按与查询的相关性重新打分，返回的分数与 documents 一一对应，越大越相关
+type Reranker interface {
+	Name() string
+	Rerank(ctx context.Context, query string, documents []string) ([]float64, error)
+}
+
+// rerankers 按 RERANK_PROVIDER 注册的重排序实现
+var rerankers = map[string]func() (Reranker, error){
+	"cohere": newCohereReranker,
+}
+
+// NewReranker 按环境变量 RERANK_PROVIDER 创建重排序实现，未配置时返回 nil，检索结果只按融合后的排名排序
+func NewReranker() (Reranker, error) {
+	provider := os.Getenv("RERANK_PROVIDER")
+	if provider == "" {
+		return nil, nil
+	}
+	newReranker, ok := rerankers[provider]
+	if !ok {
+		return nil, fmt.Errorf("不支持的重排序服务: %s", provider)
+	}
+	return newReranker()
+}
+
+// cohereReranker 调用 Cohere 格式的 /rerank 接口，Jina、SiliconFlow、Xinference 等也兼容这一格式
+type cohereReranker struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+// newCohereReranker 读取 RERANK_API_URL、RERANK_API_KEY、RERANK_MODEL
+func newCohereReranker() (Reranker, error) {
+	r := &cohereReranker{
+		url:    os.Getenv("RERANK_API_URL"),
+		apiKey: os.Getenv("RERANK_API_KEY"),
+		model:  os.Getenv("RERANK_MODEL"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if r.url == "" || r.apiKey == "" || r.model == "" {
+		return nil, fmt.Errorf("重排序服务的地址、密钥或模型未配置")
+	}
+	r.url += "/rerank"
+	return r, nil
+}
+
+func (r *cohereReranker) Name() string {
+	return r.model
+}
+
+func (r *cohereReranker) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":     r.model,
+		"query":     query,
+		"documents": documents,
+		"top_n":     len(documents),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.apiKey)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求重排序服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("重排序服务返回 %d: %s", resp.StatusCode, msg)
+	}
+
+	var result struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析重排序响应失败: %w", err)
+	}
+	scores := make([]float64, len(documents))
+	for _, item := range result.Results {
+		if item.Index < 0 || item.Index >= len(documents) {
+			return nil, fmt.Errorf("重排序响应中的 index %d 越界", item.Index)
+		}
+		scores[item.Index] = item.RelevanceScore
+	}
+	return scores, nil
+}
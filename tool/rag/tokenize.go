@@ -0,0 +1,49 @@
+// tool/rag/tokenize.go
+
+package rag
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Terms 把文本切分为检索用的词项：英文、数字按连续字符成词并转为小写，
+// 中日韩文字没有分词，按单字和相邻两字输出
+func Terms(text string) []string {
+	var terms []string
+	var word strings.Builder
+	var prev rune
+	flushWord := func() {
+		if word.Len() > 0 {
+			terms = append(terms, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			terms = append(terms, string(r))
+			if prev != 0 {
+				terms = append(terms, string([]rune{prev, r}))
+			}
+			prev = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flushWord()
+		}
+		prev = 0
+	}
+	flushWord()
+	return terms
+}
+
+// isCJK 判断是否为中日韩文字
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
@@ -0,0 +1,84 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"openapi-cms/models"
+	"os"
+)
+
+// GetVectorStore 查询 StepFun 知识库，返回中的 UsageBytes 为知识库当前的用量
+func GetVectorStore(vectorStoreID string) (*models.StepFunResponse, error) {
+	apiKey := os.Getenv("STEPFUN_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("未设置 STEPFUN_API_KEY 环境变量")
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://api.stepfun.com/v1/vector_stores/%s", vectorStoreID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建 HTTP 请求失败: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("执行 HTTP 请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("接收到非200响应: %d - %s", resp.StatusCode, string(bodyBytes))
+	}
+	var store models.StepFunResponse
+	if err := json.NewDecoder(resp.Body).Decode(&store); err != nil {
+		return nil, fmt.Errorf("解析响应 JSON 失败: %w", err)
+	}
+	return &store, nil
+}
+
+// DeleteVectorStore 删除 StepFun 知识库，知识库已不存在时视为成功
+func DeleteVectorStore(vectorStoreID string) error {
+	return deleteStepFunResource(fmt.Sprintf("https://api.stepfun.com/v1/vector_stores/%s", vectorStoreID))
+}
+
+// UnbindFileFromVectorStore 解除文件与 StepFun 知识库的绑定，绑定已不存在时视为成功
+func UnbindFileFromVectorStore(vectorStoreID, fileID string) error {
+	return deleteStepFunResource(fmt.Sprintf("https://api.stepfun.com/v1/vector_stores/%s/files/%s", vectorStoreID, fileID))
+}
+
+// DeleteStepFunFile 删除上传到 StepFun 的文件，文件已不存在时视为成功
+func DeleteStepFunFile(fileID string) error {
+	return deleteStepFunResource(fmt.Sprintf("https://api.stepfun.com/v1/files/%s", fileID))
+}
+
+// deleteStepFunResource 对 StepFun 资源发送 DELETE 请求，404 表示资源已被删除
+func deleteStepFunResource(url string) error {
+	apiKey := os.Getenv("STEPFUN_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("未设置 STEPFUN_API_KEY 环境变量")
+	}
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("创建 HTTP 请求失败: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("执行 HTTP 请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("接收到非200响应: %d - %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// tool/tokenizer/tokenizer.go
+
+package tokenizer
+
+import (
+	"encoding/json"
+	"math"
+	"openapi-cms/models"
+	"unicode"
+)
+
+// 估算参数取值偏保守，结果通常略高于 StepFun /v1/token/count 的返回，便于选择模型时留出余量
+const (
+	cjkTokensPerRune   = 0.75 // 中日韩文字，平均约 1.3 个字对应 1 个 token
+	latinCharsPerToken = 3.6  // 英文、数字等连续字符，平均约 3.6 个字符对应 1 个 token
+	messageOverhead    = 4    // 每条消息的角色、分隔符等固定开销
+	replyOverhead      = 3    // 补全回复的起始标记
+	imageTokens        = 800  // 每张图片（detail=high）的估算 token 数
+	lowDetailImage     = 300  // detail=low 的图片
+	videoTokens        = 6000 // 每段视频的估算 token 数
+)
+
+// EstimateText 估算一段文本的 token 数
+func EstimateText(text string) int {
+	var cjk, latin float64
+	var latinRun, symbols int
+	flushLatin := func() {
+		if latinRun > 0 {
+			latin += math.Ceil(float64(latinRun) / latinCharsPerToken)
+			latinRun = 0
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushLatin()
+			cjk += cjkTokensPerRune
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			latinRun++
+		case unicode.IsSpace(r):
+			flushLatin()
+		default:
+			// 标点、符号、emoji 通常单独成 token
+			flushLatin()
+			symbols++
+		}
+	}
+	flushLatin()
+	return int(math.Ceil(cjk)+latin) + symbols
+}
+
+// EstimateMessage 估算单条消息的 token 数，Content 支持字符串和内容数组（文本、图片、视频）
+func EstimateMessage(msg models.StepFunMessage) int {
+	tokens := messageOverhead + EstimateText(msg.Role)
+	switch content := msg.Content.(type) {
+	case nil:
+	case string:
+		tokens += EstimateText(content)
+	case []models.StepFunMessageContent:
+		for _, part := range content {
+			tokens += estimatePart(part)
+		}
+	default:
+		// JSON 解码得到的 []interface{} 等结构，重新解析为内容数组
+		raw, err := json.Marshal(content)
+		if err != nil {
+			break
+		}
+		var parts []models.StepFunMessageContent
+		if err := json.Unmarshal(raw, &parts); err != nil {
+			tokens += EstimateText(string(raw))
+			break
+		}
+		for _, part := range parts {
+			tokens += estimatePart(part)
+		}
+	}
+	return tokens
+}
+
+// EstimateMessages 估算整个消息列表的 token 数
+func EstimateMessages(messages []models.StepFunMessage) int {
+	tokens := replyOverhead
+	for _, msg := range messages {
+		tokens += EstimateMessage(msg)
+	}
+	return tokens
+}
+
+// estimatePart 估算内容数组中单个元素的 token 数
+func estimatePart(part models.StepFunMessageContent) int {
+	switch part.Type {
+	case "image_url":
+		if part.ImageURL != nil && part.ImageURL.Detail == "low" {
+			return lowDetailImage
+		}
+		return imageTokens
+	case "video_url":
+		return videoTokens
+	default:
+		return EstimateText(part.Text)
+	}
+}
+
+// isCJK 判断是否为中日韩文字或全角符号
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r) ||
+		(r >= 0x3000 && r <= 0x303F) || // 中文标点
+		(r >= 0xFF00 && r <= 0xFFEF) // 全角字符
+}
@@ -0,0 +1,98 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"openapi-cms/models"
+	"testing"
+)
+
+func TestEstimateText(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"你好", 2},           // 2 × 0.75 向上取整
+		{"你好世界", 3},         // 4 × 0.75
+		{"hello", 2},        // 5 / 3.6 向上取整
+		{"hello world", 4},  // 两个单词分别取整
+		{"hello, world", 5}, // 逗号单独计 1
+		{"GPT-4 模型", 5},     // GPT 1 + 连字符 1 + 4 1 + 两个汉字 2
+		{"，。！", 3},          // 全角标点按中文计
+		{"😀", 1},            // emoji 按符号计
+	}
+	for _, tt := range tests {
+		if got := EstimateText(tt.text); got != tt.want {
+			t.Errorf("EstimateText(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateMessage(t *testing.T) {
+	text := "这张图片里有什么？"
+	textTokens := EstimateText(text)
+	base := messageOverhead + EstimateText("user")
+	image := func(detail string) models.StepFunMessageContent {
+		return models.StepFunMessageContent{Type: "image_url", ImageURL: &models.StepFunMessageImageURL{URL: "https://example.com/a.png", Detail: detail}}
+	}
+	video := models.StepFunMessageContent{Type: "video_url", VideoURL: &models.StepFunMessageVideoURL{URL: "https://example.com/a.mp4"}}
+
+	tests := []struct {
+		name    string
+		content interface{}
+		want    int
+	}{
+		{"string", text, base + textTokens},
+		{"nil", nil, base},
+		{"text part", []models.StepFunMessageContent{{Type: "text", Text: text}}, base + textTokens},
+		{"high detail image", []models.StepFunMessageContent{{Type: "text", Text: text}, image("high")}, base + textTokens + imageTokens},
+		{"low detail image", []models.StepFunMessageContent{image("low")}, base + lowDetailImage},
+		{"image without detail", []models.StepFunMessageContent{image("")}, base + imageTokens},
+		{"video", []models.StepFunMessageContent{{Type: "text", Text: text}, video}, base + textTokens + videoTokens},
+		{"two images", []models.StepFunMessageContent{image("high"), image("high")}, base + 2*imageTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EstimateMessage(models.StepFunMessage{Role: "user", Content: tt.content})
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEstimateMessageDecodedContent 前端 JSON 解码得到的 []interface{} 与内容数组的估算一致
+func TestEstimateMessageDecodedContent(t *testing.T) {
+	typed := models.StepFunMessage{Role: "user", Content: []models.StepFunMessageContent{
+		{Type: "text", Text: "Describe this image."},
+		{Type: "image_url", ImageURL: &models.StepFunMessageImageURL{URL: "https://example.com/a.png", Detail: "low"}},
+	}}
+	raw, err := json.Marshal(typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded models.StepFunMessage
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.Content.([]interface{}); !ok {
+		t.Fatalf("decoded content is %T", decoded.Content)
+	}
+	if got, want := EstimateMessage(decoded), EstimateMessage(typed); got != want {
+		t.Errorf("decoded estimate %d, typed estimate %d", got, want)
+	}
+}
+
+func TestEstimateMessages(t *testing.T) {
+	messages := []models.StepFunMessage{
+		{Role: "system", Content: "你是一个助手"},
+		{Role: "user", Content: "hello"},
+	}
+	want := replyOverhead + EstimateMessage(messages[0]) + EstimateMessage(messages[1])
+	if got := EstimateMessages(messages); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got := EstimateMessages(nil); got != replyOverhead {
+		t.Errorf("empty messages: got %d, want %d", got, replyOverhead)
+	}
+}
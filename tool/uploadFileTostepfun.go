@@ -2,6 +2,7 @@ package tool
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -138,45 +139,56 @@ func UploadFileToStepFunWithExtract(filePath, filename, purpose string) (*FileSt
 
 // GetFileStatus 获取文件解析的状态
 func getFileStatus(fileID string) (string, error) {
+	statusResp, err := GetStepFunFile(context.Background(), fileID)
+	if err != nil {
+		return "", err
+	}
+	return statusResp.Status, nil
+}
+
+// stepFunFileTimeout 查询 StepFun 文件状态的超时时间，避免同步状态时被一个无响应的请求卡住
+const stepFunFileTimeout = 15 * time.Second
+
+// GetStepFunFile 查询上传到 StepFun 的文件，返回中包含解析状态和失败原因，请求随 ctx 取消
+func GetStepFunFile(ctx context.Context, fileID string) (*models.FileStatusResponse, error) {
 	// 构建 StepFun API 的 URL
 	url := fmt.Sprintf("https://api.stepfun.com/v1/files/%s", fileID)
 
 	// 创建 HTTP GET 请求
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("创建 HTTP 请求失败: %w", err)
+		return nil, fmt.Errorf("创建 HTTP 请求失败: %w", err)
 	}
 
 	// 设置必要的头部
 	apiKey := os.Getenv("STEPFUN_API_KEY")
 	if apiKey == "" {
-		return "", fmt.Errorf("未设置 STEPFUN_API_KEY 环境变量")
+		return nil, fmt.Errorf("未设置 STEPFUN_API_KEY 环境变量")
 	}
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
 	// 发送请求
-	client := &http.Client{}
+	client := &http.Client{Timeout: stepFunFileTimeout}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("执行 HTTP 请求失败: %w", err)
+		return nil, fmt.Errorf("执行 HTTP 请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// 检查响应状态码
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("接收到非200响应: %d - %s", resp.StatusCode, string(bodyBytes))
+		return nil, fmt.Errorf("接收到非200响应: %d - %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	// 解析响应 JSON
 	var statusResp models.FileStatusResponse
 	err = json.NewDecoder(resp.Body).Decode(&statusResp)
 	if err != nil {
-		return "", fmt.Errorf("解析响应 JSON 失败: %w", err)
+		return nil, fmt.Errorf("解析响应 JSON 失败: %w", err)
 	}
 
-	// 返回状态
-	return statusResp.Status, nil
+	return &statusResp, nil
 }
 
 // PollFileStatus 每隔一秒查询一次文件状态，直到状态为 "success" 或超过超时时间